@@ -4,46 +4,36 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
-)
 
-type Paths []string
+	"pakalucki/wingologrotate/logrotate"
+)
 
-type LogEntry struct {
-	Path      Paths      `yaml:"path"`
-	Type      string     `yaml:"type"`
-	Condition *Condition `yaml:"condition,omitempty"`
+type Logging struct {
+	Format string `yaml:"format,omitempty"` // text or json
+	Level  string `yaml:"level,omitempty"`  // debug, info, warn or error
+	Source *bool  `yaml:"source,omitempty"` // include file:line of the log call
 }
 
-type Condition struct {
-	Age               *string `yaml:"age,omitempty"` //since last modified
-	MaxKeep           *int    `yaml:"max_keep,omitempty"`
-	Size              *string `yaml:"size,omitempty"`
-	TimeInterval      *string `yaml:"time_interval,omitempty"`
-	Compress          *bool   `yaml:"compress,omitempty"`
-	CompressionFormat *string `yaml:"compression_format,omitempty"`
+type HTTPServer struct {
+	Listen         string `yaml:"listen"`                    // address of the optional HTTP listener serving /metrics, /healthz and /status
+	UnhealthyAfter int    `yaml:"unhealthy_after,omitempty"` // consecutive failed runs of an entry before /healthz fails
 }
 
-type Config struct {
-	Logs     []LogEntry `yaml:"logs"`
-	Schedule string     `yaml:"schedule"`
+type ControlServer struct {
+	Listen string `yaml:"listen"` // loopback address of the control API used by the trigger, pause-entry and status commands
 }
 
-func (entry *LogEntry) setDefaults() {
-	if entry.Type == "rotate" && entry.Condition == nil {
-		entry.Condition = &Condition{}
-
-		if entry.Condition.Compress == nil {
-			defaultCompress := true
-			entry.Condition.Compress = &defaultCompress
-		}
-
-		if entry.Condition.CompressionFormat == nil {
-			defaultFormat := "gzip"
-			entry.Condition.CompressionFormat = &defaultFormat
-		}
-	}
+// Config is the configuration file of the service: the entries run by the
+// rotation engine plus the settings of the service itself.
+type Config struct {
+	logrotate.Config `yaml:",inline"`
+	SelfLog          *logrotate.Condition `yaml:"self_log,omitempty"` // rotation of wingologrotate's own log
+	Logging          *Logging             `yaml:"logging,omitempty"`
+	HTTP             *HTTPServer          `yaml:"http,omitempty"`
+	Control          *ControlServer       `yaml:"control,omitempty"`
 }
 
 func loadConfig(filePath string) (Config, error) {
@@ -58,25 +48,55 @@ func loadConfig(filePath string) (Config, error) {
 		return Config{}, fmt.Errorf("failed to parse YAML file: %v", err)
 	}
 
-	for i := range config.Logs {
-		config.Logs[i].setDefaults()
+	if err := config.ApplyDefaults(); err != nil {
+		return Config{}, err
+	}
+
+	if config.StateDir == "" {
+		config.StateDir = "state"
+	}
+	if !filepath.IsAbs(config.StateDir) {
+		config.StateDir = filepath.Join(exeDir, config.StateDir)
+	}
+
+	resolveKeyPaths(config.SelfLog)
+	for _, entry := range config.Logs {
+		resolveKeyPaths(entry.Condition)
+		for i := range entry.Steps {
+			resolveKeyPaths(&entry.Steps[i].Condition)
+		}
 	}
 
 	return config, nil
 }
 
-func (p *Paths) UnmarshalYAML(value *yaml.Node) error {
-	var singlePath string
-	if err := value.Decode(&singlePath); err == nil {
-		*p = Paths{singlePath}
-		return nil
+// resolveKeyPaths makes relative paths of key and credential files relative
+// to the executable, as the working directory of a service is not.
+func resolveKeyPaths(condition *logrotate.Condition) {
+	if condition == nil {
+		return
 	}
-
-	var multiplePaths []string
-	if err := value.Decode(&multiplePaths); err == nil {
-		*p = Paths(multiplePaths)
-		return nil
+	if condition.SignWith != nil && !filepath.IsAbs(*condition.SignWith) {
+		signWith := filepath.Join(exeDir, *condition.SignWith)
+		condition.SignWith = &signWith
+	}
+	if condition.Upload != nil && condition.Upload.S3 != nil {
+		resolvePath(&condition.Upload.S3.CredentialsFile)
 	}
+	if condition.Upload != nil && condition.Upload.SFTP != nil {
+		resolvePath(&condition.Upload.SFTP.IdentityFile)
+		resolvePath(&condition.Upload.SFTP.KnownHostsFile)
+	}
+	for i, key := range condition.EncryptTo {
+		if !strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN") && !filepath.IsAbs(key) {
+			condition.EncryptTo[i] = filepath.Join(exeDir, key)
+		}
+	}
+}
 
-	return fmt.Errorf("failed to unmarshal path, expected a string or a list of strings")
+// resolvePath makes a relative path relative to the executable.
+func resolvePath(path *string) {
+	if *path != "" && !filepath.IsAbs(*path) {
+		*path = filepath.Join(exeDir, *path)
+	}
 }
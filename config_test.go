@@ -18,6 +18,11 @@ logs:
     condition:
       size: "100MB"
 schedule: "*/5 * * * *"
+self_log:
+  size: "10MB"
+  max_keep: 3
+http:
+  listen: "127.0.0.1:9180"
 `
 
 	tempFile := filepath.Join(t.TempDir(), "config.yaml")
@@ -34,6 +39,10 @@ schedule: "*/5 * * * *"
 		t.Errorf("Expected 2 log entries, got %d", len(config.Logs))
 	}
 
+	if config.Logs[0].Name != "entry-1" || config.Logs[1].Name != "entry-2" {
+		t.Errorf("Expected default entry names, got %s and %s", config.Logs[0].Name, config.Logs[1].Name)
+	}
+
 	if config.Logs[0].Type != "delete" {
 		t.Errorf("Expected type 'delete', got %s", config.Logs[0].Type)
 	}
@@ -45,4 +54,33 @@ schedule: "*/5 * * * *"
 	if config.Schedule != "*/5 * * * *" {
 		t.Errorf("Expected schedule '*/5 * * * *', got %s", config.Schedule)
 	}
+
+	if config.SelfLog == nil || *config.SelfLog.Size != "10MB" || *config.SelfLog.MaxKeep != 3 {
+		t.Errorf("Expected self_log section to be parsed, got %+v", config.SelfLog)
+	}
+
+	if config.HTTP == nil || config.HTTP.Listen != "127.0.0.1:9180" {
+		t.Errorf("Expected http section to be parsed, got %+v", config.HTTP)
+	}
+}
+
+func TestLoadConfigDuplicateNames(t *testing.T) {
+	yamlContent := `
+logs:
+  - name: app
+    path: "/path/to/log/*.log"
+    type: delete
+  - name: app
+    path: "/path/to/other/*.log"
+    type: delete
+`
+
+	tempFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(tempFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create temp config file: %v", err)
+	}
+
+	if _, err := loadConfig(tempFile); err == nil {
+		t.Errorf("Expected error for duplicate entry names, got none")
+	}
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"pakalucki/wingologrotate/logrotate"
+)
+
+// The control API lets operators trigger, pause and resume entries and reload
+// the configuration of the running instance. It is only ever served on a
+// loopback address, and every request has to carry the token the instance
+// writes to its state directory on start, readable by the accounts allowed to
+// manage it. Requests from browsers, which send an Origin header, and
+// requests for other host names, as after DNS rebinding, are rejected.
+
+// controlTokenFile is the name of the file in the state directory holding the
+// token of the control API.
+const controlTokenFile = "control.token"
+
+// controlReloadTimeout bounds how long a reload waits for running tasks
+// before cancelling them.
+const controlReloadTimeout = time.Minute
+
+type controlResponse struct {
+	OK              bool     `json:"ok"`
+	Error           string   `json:"error,omitempty"`
+	RestartRequired []string `json:"restart_required,omitempty"` // changed settings a reload does not apply
+}
+
+// restartSettings returns the settings that differ between current and next
+// and only take effect on a restart, as a reload only replaces the entries,
+// the schedules and the number of workers of the engine.
+func restartSettings(current, next Config) []string {
+	var settings []string
+	changed := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			settings = append(settings, name)
+		}
+	}
+	changed("state_dir", current.StateDir, next.StateDir)
+	changed("self_log", current.SelfLog, next.SelfLog)
+	changed("logging", current.Logging, next.Logging)
+	changed("http", current.HTTP, next.HTTP)
+	changed("control", current.Control, next.Control)
+	return settings
+}
+
+// newControlHandler serves the control API of engine, started with config.
+func newControlHandler(engine *logrotate.Engine, token string, config Config, reloadConfig func() (Config, error)) http.Handler {
+	var mu sync.Mutex
+	current := config
+
+	reply := func(w http.ResponseWriter, err error) {
+		switch {
+		case errors.Is(err, logrotate.ErrUnknownEntry):
+			writeJSON(w, http.StatusNotFound, controlResponse{Error: err.Error()})
+			return
+		case errors.Is(err, logrotate.ErrEngineStopped):
+			writeJSON(w, http.StatusServiceUnavailable, controlResponse{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, controlResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, controlResponse{OK: true})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, engine.Status())
+	})
+	mux.HandleFunc("POST /entries/{name}/trigger", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, engine.Trigger(r.PathValue("name")))
+	})
+	mux.HandleFunc("POST /entries/{name}/pause", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, engine.PauseEntry(r.PathValue("name")))
+	})
+	mux.HandleFunc("POST /entries/{name}/resume", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, engine.ResumeEntry(r.PathValue("name")))
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		engine.Pause()
+		reply(w, nil)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		engine.Resume()
+		reply(w, nil)
+	})
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		next, err := reloadConfig()
+		if err != nil {
+			reply(w, err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, cancel := context.WithTimeout(r.Context(), controlReloadTimeout)
+		defer cancel()
+		engine.Reload(ctx, next.Config)
+
+		restart := restartSettings(current, next)
+		if len(restart) > 0 {
+			slog.Warn("Reloaded configuration changes settings that need a restart", "settings", restart)
+		}
+		// The settings needing a restart keep their running values.
+		current.Config = next.Config
+		writeJSON(w, http.StatusOK, controlResponse{OK: true, RestartRequired: restart})
+	})
+	return requireControlToken(token, mux)
+}
+
+// requireControlToken rejects requests to next that do not carry token as a
+// bearer token, come from a browser or name a host other than a loopback one.
+func requireControlToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeJSON(w, http.StatusForbidden, controlResponse{Error: "cross-origin requests are not allowed"})
+			return
+		}
+		if !isLoopbackHost(r.Host) {
+			writeJSON(w, http.StatusForbidden, controlResponse{Error: "host must be a loopback address"})
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, controlResponse{Error: "missing or invalid control token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newControlToken generates the token of the control API and writes it to
+// the state directory, replacing the token of an earlier instance.
+func newControlToken(stateDir string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate control token: %v", err)
+	}
+	token := hex.EncodeToString(secret)
+
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %v", err)
+	}
+	path := filepath.Join(stateDir, controlTokenFile)
+	if err := os.WriteFile(path+".tmp", []byte(token), 0600); err != nil {
+		return "", fmt.Errorf("failed to write control token: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return "", fmt.Errorf("failed to write control token: %v", err)
+	}
+	return token, nil
+}
+
+// readControlToken reads the token of the running instance from the state
+// directory.
+func readControlToken(stateDir string) (string, error) {
+	token, err := os.ReadFile(filepath.Join(stateDir, controlTokenFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to read control token: %v", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// isLoopbackHost reports whether the Host header of a request names a
+// loopback address, with or without a port.
+func isLoopbackHost(host string) bool {
+	if isLoopbackAddress(host) {
+		return true
+	}
+	return isLoopbackAddress(net.JoinHostPort(strings.Trim(host, "[]"), "0"))
+}
+
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startControlServer serves the control API of engine, started with config,
+// on config.Control.Listen.
+func startControlServer(config Config, engine *logrotate.Engine) (*http.Server, error) {
+	settings := config.Control
+	if !isLoopbackAddress(settings.Listen) {
+		return nil, fmt.Errorf("control API must listen on a loopback address, got %s", settings.Listen)
+	}
+	token, err := newControlToken(config.StateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Addr: settings.Listen,
+		Handler: newControlHandler(engine, token, config, func() (Config, error) {
+			return loadConfig(configPath)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Starting control API", "address", settings.Listen)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Control API failed", "address", settings.Listen, "error", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// controlRequest sends a request to the control API of the running instance
+// described by config and returns the indented JSON response.
+func controlRequest(config Config, method, path string) (string, error) {
+	if config.Control == nil || config.Control.Listen == "" {
+		return "", fmt.Errorf("control API is not configured (control.listen)")
+	}
+
+	token, err := readControlToken(config.StateDir)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(method, "http://"+config.Control.Listen+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach running instance: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		out.Reset()
+		out.Write(body)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var failure controlResponse
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return "", errors.New(failure.Error)
+		}
+		return "", fmt.Errorf("unexpected response %s: %s", resp.Status, out.String())
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pakalucki/wingologrotate/logrotate"
+)
+
+func TestControlAPI(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+
+	var config Config
+	config.Schedule = "@every 1h"
+	config.Logs = []logrotate.LogEntry{
+		{Name: "app", Type: "delete", Path: logrotate.Paths{filepath.Join(tempDir, "*.log")}},
+	}
+	engine := newEngine(config)
+	engine.Start(context.Background())
+	defer engine.Stop(time.Second)
+
+	var reloaded Config
+	reloaded.Schedule = "@every 1h"
+	reloaded.Logs = []logrotate.LogEntry{
+		{Name: "app", Type: "delete", Path: logrotate.Paths{filepath.Join(tempDir, "*.log")}},
+		{Name: "other", Type: "delete", Path: logrotate.Paths{filepath.Join(tempDir, "*.txt")}},
+	}
+	reloaded.Logging = &Logging{Level: "debug"}
+	stateDir := filepath.Join(tempDir, "state")
+	token, err := newControlToken(stateDir)
+	if err != nil {
+		t.Fatalf("newControlToken() error: %v", err)
+	}
+	server := httptest.NewServer(newControlHandler(engine, token, config, func() (Config, error) {
+		return reloaded, nil
+	}))
+	defer server.Close()
+
+	client := Config{Control: &ControlServer{Listen: strings.TrimPrefix(server.URL, "http://")}}
+	client.StateDir = stateDir
+
+	if _, err := controlRequest(client, "POST", "/entries/app/pause"); err != nil {
+		t.Fatalf("pause-entry error: %v", err)
+	}
+	if status := engine.Status(); !status.Entries[0].Paused {
+		t.Errorf("Expected entry to be paused")
+	}
+
+	if _, err := controlRequest(client, "POST", "/entries/app/trigger"); err != nil {
+		t.Fatalf("trigger error: %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected triggered run to delete %s even while paused", file)
+	}
+
+	out, err := controlRequest(client, "GET", "/status")
+	if err != nil {
+		t.Fatalf("status error: %v", err)
+	}
+	var status logrotate.Status
+	if err := json.Unmarshal([]byte(out), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if len(status.Entries) != 1 || !status.Entries[0].Paused || status.Entries[0].LastResult != "success" {
+		t.Errorf("Unexpected status after trigger: %+v", status)
+	}
+
+	if _, err := controlRequest(client, "POST", "/entries/missing/trigger"); err == nil || !strings.Contains(err.Error(), "unknown entry") {
+		t.Errorf("Expected unknown entry error, got %v", err)
+	}
+
+	if _, err := controlRequest(client, "POST", "/pause"); err != nil {
+		t.Fatalf("pause error: %v", err)
+	}
+	if _, err := controlRequest(client, "POST", "/entries/app/resume"); err != nil {
+		t.Fatalf("resume-entry error: %v", err)
+	}
+	if status := engine.Status(); !status.Paused || status.Entries[0].Paused {
+		t.Errorf("Expected scheduler to be paused and entry resumed, got %+v", status)
+	}
+	if _, err := controlRequest(client, "POST", "/resume"); err != nil {
+		t.Fatalf("resume error: %v", err)
+	}
+	if status := engine.Status(); status.Paused {
+		t.Errorf("Expected scheduler to run again after resuming")
+	}
+
+	out, err = controlRequest(client, "POST", "/reload")
+	if err != nil {
+		t.Fatalf("reload error: %v", err)
+	}
+	var reply controlResponse
+	if err := json.Unmarshal([]byte(out), &reply); err != nil || !reflect.DeepEqual(reply.RestartRequired, []string{"logging"}) {
+		t.Errorf("Expected reload to report the logging settings as needing a restart, got %s", out)
+	}
+	status = engine.Status()
+	if !status.Running || len(status.Entries) != 2 || status.Entries[0].LastResult != "success" {
+		t.Errorf("Expected reload to add an entry and keep results, got %+v", status)
+	}
+
+	engine.Stop(time.Second)
+	if _, err := controlRequest(client, "POST", "/entries/app/trigger"); err == nil || !strings.Contains(err.Error(), "engine stopped") {
+		t.Errorf("Expected trigger of a stopped engine to be refused, got %v", err)
+	}
+}
+
+func TestControlAPIRejectsUnauthenticated(t *testing.T) {
+	var calls int
+	handler := requireControlToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	tests := []struct {
+		name   string
+		host   string
+		header map[string]string
+		want   int
+	}{
+		{name: "valid", host: "127.0.0.1:9181", header: map[string]string{"Authorization": "Bearer secret"}, want: http.StatusOK},
+		{name: "localhost without port", host: "localhost", header: map[string]string{"Authorization": "Bearer secret"}, want: http.StatusOK},
+		{name: "no token", host: "127.0.0.1:9181", want: http.StatusUnauthorized},
+		{name: "wrong token", host: "127.0.0.1:9181", header: map[string]string{"Authorization": "Bearer guess"}, want: http.StatusUnauthorized},
+		{name: "cross-origin", host: "127.0.0.1:9181", header: map[string]string{"Authorization": "Bearer secret", "Origin": "https://example.com"}, want: http.StatusForbidden},
+		{name: "rebound host", host: "attacker.example:9181", header: map[string]string{"Authorization": "Bearer secret"}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/pause", nil)
+		req.Host = tt.host
+		for name, value := range tt.header {
+			req.Header.Set(name, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, recorder.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected only the valid requests to reach the API, got %d", calls)
+	}
+}
+
+func TestIsLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected bool
+	}{
+		{"127.0.0.1:9181", true},
+		{"localhost:9181", true},
+		{"[::1]:9181", true},
+		{"0.0.0.0:9181", false},
+		{":9181", false},
+		{"10.0.0.5:9181", false},
+		{"127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isLoopbackAddress(tt.address); got != tt.expected {
+			t.Errorf("isLoopbackAddress(%q) = %v, expected %v", tt.address, got, tt.expected)
+		}
+	}
+}
+
+func TestStartControlServerRejectsNonLoopback(t *testing.T) {
+	if _, err := startControlServer(Config{Control: &ControlServer{Listen: "0.0.0.0:9181"}}, newEngine(Config{})); err == nil {
+		t.Errorf("Expected error for non-loopback control address, got none")
+	}
+}
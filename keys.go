@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"pakalucki/wingologrotate/logrotate"
+)
+
+// generateKeys writes a new key pair to <name>.key and <name>.pub: by default
+// an encryption key for encrypt_to, which should be generated away from the
+// hosts rotating logs as they only need the public key, and with -sign a
+// signing key for sign_with, whose public key verifies signatures elsewhere.
+func generateKeys(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	sign := flags.Bool("sign", false, "generate an Ed25519 signing key instead of an encryption key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: keygen [-sign] <name>")
+	}
+	name := flags.Arg(0)
+
+	generate := logrotate.GenerateKey
+	if *sign {
+		generate = logrotate.GenerateSigningKey
+	}
+	privatePEM, publicPEM, err := generate()
+	if err != nil {
+		return err
+	}
+
+	if err := writeNewFile(name+".key", privatePEM, 0600); err != nil {
+		return err
+	}
+	if err := writeNewFile(name+".pub", publicPEM, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote private key %s.key and public key %s.pub\n", name, name)
+	return nil
+}
+
+// decryptArchives decrypts the .enc archives given as arguments next to them
+// with the private key given by -key.
+func decryptArchives(args []string) error {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	keyPath := flags.String("key", "", "private key file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: decrypt -key <private key file> <archive>...")
+	}
+
+	keyData, err := os.ReadFile(*keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read private key: %v", err)
+	}
+	identity, err := logrotate.ParseIdentity(keyData)
+	if err != nil {
+		return err
+	}
+
+	for _, archive := range flags.Args() {
+		decrypted, err := logrotate.DecryptFile(archive, identity)
+		if err != nil {
+			return fmt.Errorf("%s: %v", archive, err)
+		}
+		fmt.Printf("decrypted %s to %s\n", archive, decrypted)
+	}
+	return nil
+}
+
+// verifySignatures checks the archives in the directory given as argument
+// against their signatures and the public key given by -key, printing the
+// result of every check.
+func verifySignatures(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("verify-signature", flag.ContinueOnError)
+	keyPath := flags.String("key", "", "public key file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" || flags.NArg() != 1 {
+		return fmt.Errorf("usage: verify-signature -key <public key file> <archive directory>")
+	}
+
+	keyData, err := os.ReadFile(*keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read public key: %v", err)
+	}
+	publicKey, err := logrotate.ParseVerifyKey(keyData)
+	if err != nil {
+		return err
+	}
+
+	checks, err := logrotate.VerifySignatures(ctx, flags.Arg(0), publicKey)
+	if err != nil {
+		return err
+	}
+	return printChecks(out, checks)
+}
+
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
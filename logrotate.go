@@ -1,166 +1,173 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"io"
+	"log/slog"
+	"net/http"
 	"path/filepath"
 	"time"
 
-	"github.com/robfig/cron/v3"
+	"pakalucki/wingologrotate/logrotate"
 )
 
 var (
 	exeDir     = getExecutablePath()
 	configPath = filepath.Join(exeDir, "configs", "wingologrotate.yaml")
 	logOutput  = filepath.Join(exeDir, "logs", "wingologrotate.log")
+
+	// waitForLock makes an instance wait for a running one to exit instead of
+	// exiting itself.
+	waitForLock bool
 )
 
-func runLogRotation() {
-	setupLogging(logOutput)
+// logRotation is a running instance: the rotation engine and its listeners.
+type logRotation struct {
+	engine  *logrotate.Engine
+	lock    *logrotate.Lock
+	servers []*http.Server
+}
+
+// acquireInstanceLock makes sure only one instance rotates the configured
+// files at a time.
+func acquireInstanceLock(ctx context.Context, config Config) (*logrotate.Lock, error) {
+	path := filepath.Join(config.StateDir, "wingologrotate.lock")
+	if waitForLock {
+		slog.Info("Waiting for instance lock", "path", path)
+		return logrotate.WaitLock(ctx, path, 5*time.Second, logrotate.Options{})
+	}
+	return logrotate.AcquireLock(path, logrotate.Options{})
+}
+
+// runOnce runs every entry once and returns, for use from a scheduled task.
+func runOnce(ctx context.Context) error {
+	config, err := loadConfig(configPath)
+	setupLogging(logOutput, config.SelfLog, config.Logging)
 	defer closeLogFile()
 
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return err
+	}
+
+	lock, err := acquireInstanceLock(ctx, config)
+	if err != nil {
+		slog.Error("Failed to acquire instance lock", "error", err)
+		return err
+	}
+	defer lock.Release()
+
+	engine := newEngine(config)
+	recoverErr := engine.Recover(ctx)
+	return errors.Join(recoverErr, engine.RunOnce(ctx))
+}
+
+// verifyArchives checks the archives of the configured entries against their
+// checksum sidecars and prints the result of every check to out.
+func verifyArchives(ctx context.Context, out io.Writer) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	engine := logrotate.New(config.Config, logrotate.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	return printChecks(out, engine.VerifyArchives(ctx))
+}
+
+// printChecks prints the result of every archive check to out and fails if
+// any of them did.
+func printChecks(out io.Writer, checks []logrotate.ArchiveCheck) error {
+	failed := 0
+	for _, check := range checks {
+		if check.Err != nil {
+			failed++
+			fmt.Fprintf(out, "FAILED %s: %v\n", check.Path, check.Err)
+			continue
+		}
+		fmt.Fprintf(out, "OK     %s\n", check.Path)
+	}
+	fmt.Fprintf(out, "%d archives checked, %d failed\n", len(checks), failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d archives failed verification", failed, len(checks))
+	}
+	return nil
+}
+
+func startLogRotation(ctx context.Context) (*logRotation, error) {
 	config, err := loadConfig(configPath)
+	setupLogging(logOutput, config.SelfLog, config.Logging)
+
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		slog.Error("Failed to load configuration", "error", err)
+		closeLogFile()
+		return nil, err
 	}
 
-	c := cron.New()
+	lock, err := acquireInstanceLock(ctx, config)
+	if err != nil {
+		slog.Error("Failed to acquire instance lock", "error", err)
+		closeLogFile()
+		return nil, err
+	}
 
-	for _, logEntry := range config.Logs {
-		schedule := config.Schedule
-		task := createTask(logEntry)
+	rotation := &logRotation{engine: newEngine(config), lock: lock}
+	// Failures are logged and reported through the hooks, the remaining
+	// entries still have to be rotated.
+	_ = rotation.engine.Recover(ctx)
+	rotation.engine.Start(ctx)
+
+	if config.HTTP != nil {
+		rotation.servers = append(rotation.servers, startHTTPServer(config.HTTP, rotation.engine))
+	}
 
-		_, err := c.AddFunc(schedule, task)
+	if config.Control != nil {
+		server, err := startControlServer(config, rotation.engine)
 		if err != nil {
-			log.Printf("Failed to schedule task for path %s: %v", logEntry.Path, err)
+			slog.Error("Failed to start control API", "error", err)
 		} else {
-			log.Printf("Scheduled task for path %s", logEntry.Path)
+			rotation.servers = append(rotation.servers, server)
 		}
 	}
 
-	c.Start()
+	return rotation, nil
+}
 
-	select {}
+func newEngine(config Config) *logrotate.Engine {
+	options := logrotate.Options{Hooks: metricsHooks()}
+	if config.HTTP != nil {
+		options.UnhealthyAfter = config.HTTP.UnhealthyAfter
+	}
+	return logrotate.New(config.Config, options)
+}
 
+func (r *logRotation) pause() {
+	r.engine.Pause()
 }
 
-func createTask(logEntry LogEntry) func() {
-	return func() {
-		for _, path := range logEntry.Path {
-			log.Printf("Running task for path: %s", path)
-			matchingFiles, err := filepath.Glob(filepath.Clean(path))
-			if err != nil {
-				log.Printf("Failed to expand wildcard for path %s: %v", path, err)
-				return
-			}
-
-			switch logEntry.Type {
-			case "delete":
-				for _, file := range matchingFiles {
-					if logEntry.Condition != nil && logEntry.Condition.Age != nil {
-						ageDuration, err := parseDuration(*logEntry.Condition.Age)
-						if err != nil {
-							log.Printf("Invalid age format for file %s: %v", file, err)
-							continue
-						}
-
-						fileInfo, err := os.Stat(file)
-						if err != nil {
-							log.Printf("Failed to get file info for %s: %v", file, err)
-							continue
-						}
-						fileAge := time.Since(fileInfo.ModTime())
-
-						if fileAge < ageDuration {
-							continue
-						}
-					}
-
-					log.Printf("Deleting file: %s", file)
-					err := os.Remove(file)
-					if err != nil {
-						log.Printf("Failed to delete file %s: %v", file, err)
-					} else {
-						log.Printf("Successfully deleted file: %s", file)
-					}
-				}
-
-			case "rotate":
-				rotateLogFiles(logEntry)
-
-			default:
-				log.Printf("Unsupported task type: %s", logEntry.Type)
-			}
-		}
-	}
+func (r *logRotation) resume() {
+	r.engine.Resume()
 }
 
-func rotateLogFiles(logEntry LogEntry) {
-	for _, path := range logEntry.Path {
-		log.Printf("Rotating logs for path: %s", path)
-		matchingFiles, err := filepath.Glob(filepath.Clean(path))
-		if err != nil {
-			log.Printf("Failed to expand wildcard for path %s: %v", path, err)
-			return
-		}
+// stop waits up to timeout for running tasks before cancelling them, then
+// shuts down the listeners and closes the log file.
+func (r *logRotation) stop(timeout time.Duration) {
+	r.engine.Stop(timeout)
 
-		for _, file := range matchingFiles {
-			fileInfo, err := os.Stat(file)
-			if err != nil {
-				log.Printf("Failed to get file info for %s: %v", file, err)
-				continue
-			}
-
-			rotateDueToSize := false
-			if logEntry.Condition.Size != nil {
-				maxSize, err := parseSize(*logEntry.Condition.Size)
-				if err != nil {
-					log.Printf("Invalid size format for rotation: %v", err)
-					continue
-				}
-
-				if fileInfo.Size() >= maxSize {
-					rotateDueToSize = true
-				}
-			}
-
-			rotateDueToAge := false
-			if logEntry.Condition.Age != nil {
-				ageDuration, err := parseDuration(*logEntry.Condition.Age)
-				if err != nil {
-					log.Printf("Invalid age format for rotation: %v", err)
-					continue
-				}
-
-				fileAge := time.Since(fileInfo.ModTime())
-				if fileAge >= ageDuration {
-					rotateDueToAge = true
-				}
-			}
-
-			if rotateDueToSize || rotateDueToAge {
-				rotatedFilePath := fmt.Sprintf("%s.%s", file, time.Now().Format("20060102-150405"))
-				if err := os.Rename(file, rotatedFilePath); err != nil {
-					log.Printf("Failed to rotate log file %s: %v", file, err)
-					continue
-				}
-				log.Printf("Rotated log file: %s to %s", file, rotatedFilePath)
-
-				if logEntry.Condition.Compress == nil || *logEntry.Condition.Compress {
-					if err := compressLogFile(rotatedFilePath, "gzip"); err != nil { // todo read me from config
-						log.Printf("Failed to compress rotated log file %s: %v", rotatedFilePath, err)
-					} else {
-						log.Printf("Compressed log file: %s", rotatedFilePath)
-					}
-				}
-
-				if logEntry.Condition.MaxKeep != nil {
-					if err := removeOldLogFiles(filepath.Dir(file), filepath.Base(file), *logEntry.Condition.MaxKeep); err != nil {
-						log.Printf("Failed to remove old log files: %v", err)
-					}
-				}
-			}
+	for _, server := range r.servers {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down listener", "address", server.Addr, "error", err)
 		}
+		cancel()
 	}
+
+	if err := r.lock.Release(); err != nil {
+		slog.Error("Failed to release instance lock", "error", err)
+	}
+
+	slog.Info("Stopped log rotation")
+	closeLogFile()
 }
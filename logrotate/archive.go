@@ -0,0 +1,445 @@
+package logrotate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Formats of the bundles written by archive and compact steps.
+const (
+	bundleTar     = "tar"
+	bundleTarGzip = "tar.gz"
+	bundleTarZstd = "tar.zst"
+	bundleZip     = "zip"
+)
+
+const defaultBundleName = "{entry}.{date}-{time}"
+
+// bundleFormat returns the archive_format of step. Archive steps default to
+// tar.gz, compact steps to tar, as the archives they bundle are compressed
+// already.
+func bundleFormat(step *Step) string {
+	if step.ArchiveFormat != nil {
+		return *step.ArchiveFormat
+	}
+	if step.Action == actionCompact {
+		return bundleTar
+	}
+	return bundleTarGzip
+}
+
+// validBundleFormat reports whether format is one bundles can be written in.
+func validBundleFormat(format string) bool {
+	switch format {
+	case bundleTar, bundleTarGzip, bundleTarZstd, bundleZip:
+		return true
+	default:
+		return false
+	}
+}
+
+// bundleName returns the path of the bundle step writes in root, and a glob
+// matching it and the bundles of earlier runs. The archive_name template of
+// step may contain {entry}, {host}, {year}, {month}, {day}, {date} and {time}
+// and is relative to root unless absolute; the extension of the format is
+// added to it.
+func bundleName(step *Step, entry, root string, now time.Time) (string, string) {
+	template := defaultBundleName
+	if step.Action == actionCompact {
+		template = defaultCompactName(step)
+	}
+	if step.ArchiveName != nil {
+		template = *step.ArchiveName
+	}
+	if !filepath.IsAbs(template) {
+		template = filepath.Join(root, template)
+	}
+
+	hostname, _ := os.Hostname()
+	fixed := strings.NewReplacer("{entry}", entry, "{host}", hostname)
+	name := strings.NewReplacer(
+		"{year}", now.Format("2006"),
+		"{month}", now.Format("01"),
+		"{day}", now.Format("02"),
+		"{date}", now.Format("20060102"),
+		"{time}", now.Format("150405"),
+	).Replace(fixed.Replace(template))
+	pattern := strings.NewReplacer(
+		"{year}", "*", "{month}", "*", "{day}", "*", "{date}", "*", "{time}", "*",
+	).Replace(fixed.Replace(template))
+
+	// The extra wildcard matches the suffix of bundles renamed to not
+	// overwrite one of the same name.
+	ext := "." + bundleFormat(step)
+	return name + ext, pattern + "*" + ext
+}
+
+// archiveStep adds the file to the bundle of the run if it is older than the
+// age of step. Archives, sidecars and temporary files are left alone, so a
+// bundle written among the files it bundles is not bundled again, and so is
+// the active file, like by compress.
+func (e *Engine) archiveStep(run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	name := filepath.Base(file.path)
+	if isArchive(name) || isSidecar(name) || strings.HasSuffix(name, tempName("")) {
+		return false, nil
+	}
+	if file.active {
+		run.logger.Debug("Skipping active file", "path", file.path)
+		return false, nil
+	}
+	_, due, err := e.dueByAge(step, file)
+	if err != nil || !due {
+		return false, err
+	}
+	run.addToBundle(file.path)
+	return false, nil
+}
+
+// archiveFiles writes files into one bundle in root and deletes them once it
+// is in place, and verified if step asks for it. Files whose size or
+// modification time changed while they were bundled are kept, as the bundle
+// misses what was written to them meanwhile. It returns the bundle as the
+// file the steps following step work on, pruned along with the bundles of
+// earlier runs.
+func (e *Engine) archiveFiles(ctx context.Context, run *taskRun, step *Step, root string, files []string) (*pipelineFile, error) {
+	name, pattern := bundleName(step, run.entry, root, e.clock.Now())
+	verify := step.Verify != nil && *step.Verify
+	bundled, sums, err := e.bundleFiles(ctx, step, name, pattern, root, files, verify)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, sum := range sums {
+		total += sum.size
+	}
+	run.logger.Info("Archived files", "path", bundled.path, "files", len(files), "bytes", total)
+
+	for i, file := range files {
+		fileInfo, err := e.fs.Stat(file)
+		if err != nil {
+			return bundled, stepFailed("stat", "Failed to get file info", file, err)
+		}
+		if fileInfo.Size() != sums[i].size || !fileInfo.ModTime().Equal(sums[i].modTime) {
+			run.logger.Warn("Keeping file changed while it was archived", "path", file, "archive", bundled.path)
+			continue
+		}
+		if err := e.fs.Remove(file); err != nil {
+			return bundled, stepFailed("archive", "Failed to remove archived file", file, err)
+		}
+		run.done(FileEvent{Action: "archived", Path: file, NewPath: bundled.path, Size: sums[i].size})
+	}
+	return bundled, nil
+}
+
+// bundleFiles writes files into a bundle named name, or name with a suffix
+// if it is taken, and verifies it if asked to. It returns the bundle, to be
+// pruned along with the files matching pattern, and the digests of files.
+func (e *Engine) bundleFiles(ctx context.Context, step *Step, name, pattern, root string, files []string, verify bool) (*pipelineFile, []*digest, error) {
+	format := bundleFormat(step)
+	name = availableName(e.fs, name, "."+format)
+
+	if err := e.fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, nil, stepFailed(step.Action, "Failed to create archive directory", name, err)
+	}
+	sums, err := writeBundle(ctx, e.fs, name, format, root, files)
+	if err != nil {
+		return nil, nil, stepFailed(step.Action, "Failed to write archive", name, err)
+	}
+	if verify {
+		if err := verifyBundle(ctx, e.fs, name, format, root, files, sums); err != nil {
+			e.fs.Remove(name)
+			return nil, nil, stepFailed("verify", "Archive verification failed", name, err)
+		}
+	}
+	return &pipelineFile{original: name, path: name, root: root, siblings: pattern}, sums, nil
+}
+
+// availableName returns name, or name with -1, -2, ... inserted before ext
+// if it is taken.
+func availableName(fsys FS, name, ext string) string {
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		// Errors other than the name being free are left to creating the
+		// bundle to report.
+		if _, err := fsys.Stat(candidate); err != nil {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// memberName returns the name file is stored under in a bundle, its
+// slash-separated path relative to root.
+func memberName(root, file string) string {
+	name, err := filepath.Rel(root, file)
+	if err != nil || strings.HasPrefix(name, "..") {
+		name = filepath.Base(file)
+	}
+	return filepath.ToSlash(name)
+}
+
+// writeBundle streams files into a bundle of format at path, storing them
+// relative to root, and returns their digests. The bundle is written under a
+// temporary name and renamed into place once synced.
+func writeBundle(ctx context.Context, fsys FS, path, format, root string, files []string) ([]*digest, error) {
+	output, err := fsys.Create(tempName(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %v", err)
+	}
+
+	var sums []*digest
+	switch format {
+	case bundleZip:
+		sums, err = writeZipBundle(ctx, fsys, output, root, files)
+	case bundleTar, bundleTarGzip, bundleTarZstd:
+		sums, err = writeTarBundle(ctx, fsys, output, format, root, files)
+	default:
+		err = fmt.Errorf("unsupported archive format: %s", format)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		if syncErr := output.Sync(); syncErr != nil {
+			err = fmt.Errorf("failed to sync archive: %v", syncErr)
+		}
+	}
+	if closeErr := output.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close archive: %v", closeErr)
+	}
+	if err == nil {
+		if renameErr := fsys.Rename(tempName(path), path); renameErr != nil {
+			err = fmt.Errorf("failed to rename archive: %v", renameErr)
+		}
+	}
+	if err == nil {
+		if err = syncDir(fsys, filepath.Dir(path)); err != nil {
+			fsys.Remove(path)
+		}
+	}
+	if err != nil {
+		fsys.Remove(tempName(path))
+		return nil, err
+	}
+	return sums, nil
+}
+
+// nopWriteCloser is the compressor of uncompressed tar bundles.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func writeTarBundle(ctx context.Context, fsys FS, output io.Writer, format, root string, files []string) ([]*digest, error) {
+	var compressor io.WriteCloser
+	switch format {
+	case bundleTarZstd:
+		encoder, err := zstd.NewWriter(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start zstd stream: %v", err)
+		}
+		compressor = encoder
+	case bundleTarGzip:
+		compressor = gzip.NewWriter(output)
+	default:
+		compressor = nopWriteCloser{output}
+	}
+	archive := tar.NewWriter(compressor)
+
+	sums, err := addBundleFiles(ctx, fsys, root, files, func(fileInfo os.FileInfo, name string) (io.Writer, error) {
+		header, err := tar.FileInfoHeader(fileInfo, "")
+		if err != nil {
+			return nil, err
+		}
+		header.Name = name
+		return archive, archive.WriteHeader(header)
+	})
+	if err != nil {
+		compressor.Close()
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		compressor.Close()
+		return nil, fmt.Errorf("failed to finish tar archive: %v", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish %s stream: %v", format, err)
+	}
+	return sums, nil
+}
+
+func writeZipBundle(ctx context.Context, fsys FS, output io.Writer, root string, files []string) ([]*digest, error) {
+	archive := zip.NewWriter(output)
+	sums, err := addBundleFiles(ctx, fsys, root, files, func(fileInfo os.FileInfo, name string) (io.Writer, error) {
+		header, err := zip.FileInfoHeader(fileInfo)
+		if err != nil {
+			return nil, err
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+		return archive.CreateHeader(header)
+	})
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish zip archive: %v", err)
+	}
+	return sums, nil
+}
+
+// addBundleFiles copies every file into the writer create returns for its
+// member, named by memberName.
+func addBundleFiles(ctx context.Context, fsys FS, root string, files []string, create func(os.FileInfo, string) (io.Writer, error)) ([]*digest, error) {
+	sums := make([]*digest, 0, len(files))
+	for _, file := range files {
+		sum, err := addBundleFile(ctx, fsys, root, file, create)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	return sums, nil
+}
+
+func addBundleFile(ctx context.Context, fsys FS, root, file string, create func(os.FileInfo, string) (io.Writer, error)) (*digest, error) {
+	input, err := fsys.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", file, err)
+	}
+	defer input.Close()
+
+	fileInfo, err := input.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info of %s: %v", file, err)
+	}
+	writer, err := create(fileInfo, memberName(root, file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s: %v", file, err)
+	}
+	// The size in the header is what tar expects, a file growing meanwhile
+	// is cut there.
+	sum := newDigest()
+	sum.modTime = fileInfo.ModTime()
+	content := io.TeeReader(io.LimitReader(&contextReader{ctx: ctx, r: input}, fileInfo.Size()), sum)
+	if _, err := io.Copy(writer, content); err != nil {
+		return nil, fmt.Errorf("failed to archive %s: %v", file, err)
+	}
+	return sum, nil
+}
+
+// verifyBundle reads the bundle at path back and checks that it holds files,
+// in order, with the content recorded in want.
+func verifyBundle(ctx context.Context, fsys FS, path, format, root string, files []string, want []*digest) error {
+	i := 0
+	err := readBundle(ctx, fsys, path, format, func(name string, content io.Reader) error {
+		if i >= len(files) {
+			return fmt.Errorf("%w: unexpected member %s", ErrChecksumMismatch, name)
+		}
+		if wantName := memberName(root, files[i]); name != wantName {
+			return fmt.Errorf("%w: member %d is %s, expected %s", ErrChecksumMismatch, i+1, name, wantName)
+		}
+		got := newDigest()
+		if _, err := io.Copy(got, content); err != nil {
+			return fmt.Errorf("failed to read member %s: %v", name, err)
+		}
+		if got.size != want[i].size || !bytes.Equal(got.Sum(), want[i].Sum()) {
+			return fmt.Errorf("%w: member %s differs from %s", ErrChecksumMismatch, name, files[i])
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if i != len(files) {
+		return fmt.Errorf("%w: archive holds %d of %d files", ErrChecksumMismatch, i, len(files))
+	}
+	return nil
+}
+
+// readBundle calls member with the name and content of every member of the
+// bundle at path in order.
+func readBundle(ctx context.Context, fsys FS, path, format string, member func(string, io.Reader) error) error {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	if format == bundleZip {
+		readerAt, ok := file.(io.ReaderAt)
+		if !ok {
+			return fmt.Errorf("zip archive %s does not support random access", path)
+		}
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to get archive info: %v", err)
+		}
+		archive, err := zip.NewReader(readerAt, fileInfo.Size())
+		if err != nil {
+			return fmt.Errorf("failed to read zip archive: %v", err)
+		}
+		for _, entry := range archive.File {
+			content, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("failed to open zip member %s: %v", entry.Name, err)
+			}
+			err = member(entry.Name, &contextReader{ctx: ctx, r: content})
+			content.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var stream io.Reader = file
+	switch format {
+	case bundleTarGzip:
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip stream: %v", err)
+		}
+		defer gzipReader.Close()
+		stream = gzipReader
+	case bundleTarZstd:
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read zstd stream: %v", err)
+		}
+		defer decoder.Close()
+		stream = decoder
+	}
+
+	archive := tar.NewReader(&contextReader{ctx: ctx, r: stream})
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %v", err)
+		}
+		if err := member(header.Name, archive); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package logrotate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bundleContents returns the contents of the files in the bundle at path by
+// their names.
+func bundleContents(t *testing.T, path, format string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+
+	if format == bundleZip {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatalf("Expected zip archive, got %v", err)
+		}
+		defer archive.Close()
+		for _, file := range archive.File {
+			reader, _ := file.Open()
+			content, _ := io.ReadAll(reader)
+			reader.Close()
+			contents[file.Name] = string(content)
+		}
+		return contents
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer file.Close()
+	var stream io.Reader = file
+	switch format {
+	case bundleTarZstd:
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			t.Fatalf("Expected zstd stream, got %v", err)
+		}
+		defer decoder.Close()
+		stream = decoder
+	case bundleTarGzip:
+		stream, err = gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("Expected gzip stream, got %v", err)
+		}
+	}
+
+	archive := tar.NewReader(stream)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return contents
+		}
+		if err != nil {
+			t.Fatalf("Expected tar archive, got %v", err)
+		}
+		content, _ := io.ReadAll(archive)
+		contents[header.Name] = string(content)
+	}
+}
+
+func TestArchiveFiles(t *testing.T) {
+	now := time.Date(2024, 9, 13, 12, 0, 0, 0, time.UTC)
+
+	for _, format := range []string{bundleTarGzip, bundleTarZstd, bundleZip} {
+		t.Run(format, func(t *testing.T) {
+			tempDir := t.TempDir()
+			write := func(name, content string, age time.Duration) string {
+				path := filepath.Join(tempDir, name)
+				_ = os.MkdirAll(filepath.Dir(path), 0755)
+				_ = os.WriteFile(path, []byte(content), 0644)
+				_ = os.Chtimes(path, now.Add(-age), now.Add(-age))
+				return path
+			}
+			old := []string{
+				write("a/req-1.log", "first\n", 2*time.Hour),
+				write("b/req-2.log", "second\n", 2*time.Hour),
+			}
+			recent := write("a/req-3.log", "third\n", time.Minute)
+			// The newest file of b is still being written to.
+			active := write("b/req-4.log", "fourth\n", 90*time.Minute)
+			earlier := write("requests.20240912-120000."+format, "earlier bundle", 24*time.Hour)
+
+			var events []FileEvent
+			engine := New(Config{}, Options{Clock: &fakeClock{now: now}, Hooks: Hooks{OnFile: func(event FileEvent) { events = append(events, event) }}})
+			err := engine.task(LogEntry{
+				Name:      "requests",
+				Path:      Paths{filepath.Join(tempDir, "*", "req-*.log")},
+				Type:      "archive",
+				Condition: &Condition{Age: stringPtr("1h"), ArchiveFormat: stringPtr(format), MaxKeep: intPtr(1)},
+			})(context.Background())
+			if err != nil {
+				t.Fatalf("task() error: %v", err)
+			}
+
+			bundle := filepath.Join(tempDir, "requests.20240913-120000."+format)
+			want := map[string]string{"a/req-1.log": "first\n", "b/req-2.log": "second\n"}
+			if got := bundleContents(t, bundle, format); !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected bundle of %v, got %v", want, got)
+			}
+
+			for _, file := range append(old, earlier) {
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("Expected %s to be removed, got %v", file, err)
+				}
+			}
+			for _, kept := range []string{recent, active} {
+				if _, err := os.Stat(kept); err != nil {
+					t.Errorf("Expected %s to be kept, got %v", kept, err)
+				}
+			}
+
+			var actions []string
+			for _, event := range events {
+				actions = append(actions, event.Action)
+			}
+			sort.Strings(actions)
+			wantActions := []string{"archived", "archived", "pruned"}
+			if !reflect.DeepEqual(actions, wantActions) {
+				t.Errorf("Expected actions %v, got %v", wantActions, actions)
+			}
+		})
+	}
+}
+
+func TestArchiveFilesWriteFailure(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "req-1.log")
+	_ = os.WriteFile(file, []byte("first\n"), 0644)
+	_ = os.Chtimes(file, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	_ = os.WriteFile(filepath.Join(tempDir, "req-2.log"), []byte("active\n"), 0644)
+
+	engine := New(Config{}, Options{})
+	err := engine.task(LogEntry{
+		Name:      "requests",
+		Path:      Paths{filepath.Join(tempDir, "*.log")},
+		Type:      "archive",
+		Condition: &Condition{ArchiveName: stringPtr(filepath.Join(file, "bundle"))},
+	})(context.Background())
+	if err == nil {
+		t.Fatal("Expected error writing the bundle below a file")
+	}
+
+	if content, _ := os.ReadFile(file); string(content) != "first\n" {
+		t.Errorf("Expected file kept after failed archiving, got %q", content)
+	}
+}
+
+// appendOnRenameFS is the OS filesystem appending a line to file whenever
+// something is renamed, like a writer racing the bundle being put in place.
+type appendOnRenameFS struct {
+	OSFS
+	file string
+}
+
+func (fsys appendOnRenameFS) Rename(oldpath, newpath string) error {
+	if output, err := os.OpenFile(fsys.file, os.O_APPEND|os.O_WRONLY, 0644); err == nil {
+		output.WriteString("late line\n")
+		output.Close()
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+func TestArchiveFilesChangedMeanwhile(t *testing.T) {
+	tempDir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	write := func(name, content string) string {
+		path := filepath.Join(tempDir, name)
+		_ = os.WriteFile(path, []byte(content), 0644)
+		_ = os.Chtimes(path, old, old)
+		return path
+	}
+	unchanged := write("req-1.log", "first\n")
+	changed := write("req-2.log", "second\n")
+	_ = os.WriteFile(filepath.Join(tempDir, "req-3.log"), []byte("active\n"), 0644)
+
+	var events []FileEvent
+	engine := New(Config{}, Options{FS: appendOnRenameFS{file: changed}, Hooks: Hooks{OnFile: func(event FileEvent) { events = append(events, event) }}})
+	err := engine.task(LogEntry{
+		Name:      "requests",
+		Path:      Paths{filepath.Join(tempDir, "*.log")},
+		Type:      "archive",
+		Condition: &Condition{Age: stringPtr("1h")},
+	})(context.Background())
+	if err != nil {
+		t.Fatalf("task() error: %v", err)
+	}
+
+	if _, err := os.Stat(unchanged); !os.IsNotExist(err) {
+		t.Errorf("Expected archived file to be removed, got %v", err)
+	}
+	if content, _ := os.ReadFile(changed); string(content) != "second\nlate line\n" {
+		t.Errorf("Expected changed file to be kept with its late line, got %q", content)
+	}
+	if len(events) != 1 || events[0].Path != unchanged {
+		t.Errorf("Expected only the unchanged file to be archived, got %+v", events)
+	}
+}
+
+func TestBundleName(t *testing.T) {
+	now := time.Date(2024, 9, 13, 12, 30, 0, 0, time.UTC)
+	root := filepath.Join("D:", "logs")
+
+	name, pattern := bundleName(&Step{Action: actionArchive, Condition: Condition{ArchiveName: stringPtr("archive/{entry}-{year}-{month}-{day}")}}, "web", root, now)
+	if want := filepath.Join(root, "archive", "web-2024-09-13.tar.gz"); name != want {
+		t.Errorf("Expected name %s, got %s", want, name)
+	}
+	if want := filepath.Join(root, "archive", "web-*-*-**.tar.gz"); pattern != want {
+		t.Errorf("Expected pattern %s, got %s", want, pattern)
+	}
+	if matched, _ := filepath.Match(pattern, filepath.Join(root, "archive", "web-2024-09-13-1.tar.gz")); !matched {
+		t.Errorf("Expected pattern %s to match renamed bundle", pattern)
+	}
+}
@@ -0,0 +1,305 @@
+package logrotate
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// checksumSuffix is appended to the name of an archive to get the name of its
+// sidecar, which holds the sha256 of the archive in sha256sum format.
+const checksumSuffix = ".sha256"
+
+// ErrChecksumMismatch is returned when an archive does not match its sidecar
+// or the original it was compressed from.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// digest hashes and counts the bytes written to it.
+type digest struct {
+	hash    hash.Hash
+	size    int64
+	modTime time.Time // of the file hashed, for bundle members
+}
+
+func newDigest() *digest {
+	return &digest{hash: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *digest) Sum() []byte {
+	return d.hash.Sum(nil)
+}
+
+// verifyArchive decompresses archivePath and checks that its content has the
+// length and hash of the original recorded in want.
+func verifyArchive(ctx context.Context, fsys FS, archivePath string, compressionFormat string, want *digest) error {
+	file, err := fsys.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive for verification: %v", err)
+	}
+	defer file.Close()
+
+	content, closeContent, err := openArchive(file, archivePath, compressionFormat)
+	if err != nil {
+		return err
+	}
+	defer closeContent()
+
+	got := newDigest()
+	if _, err := io.Copy(got, &contextReader{ctx: ctx, r: content}); err != nil {
+		return fmt.Errorf("failed to decompress archive for verification: %v", err)
+	}
+	if got.size != want.size {
+		return fmt.Errorf("%w: archive holds %d bytes, original has %d", ErrChecksumMismatch, got.size, want.size)
+	}
+	if !bytes.Equal(got.Sum(), want.Sum()) {
+		return fmt.Errorf("%w: decompressed archive differs from original", ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// openArchive returns the decompressed content of the single-file archive
+// file at archivePath, and a function releasing its decompressor.
+func openArchive(file File, archivePath, compressionFormat string) (io.Reader, func(), error) {
+	switch compressionFormat {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read gzip archive: %v", err)
+		}
+		return gzipReader, func() { gzipReader.Close() }, nil
+	case "zip":
+		readerAt, ok := file.(io.ReaderAt)
+		if !ok {
+			return nil, nil, fmt.Errorf("zip archive %s does not support random access", archivePath)
+		}
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get archive info: %v", err)
+		}
+		archive, err := zip.NewReader(readerAt, fileInfo.Size())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read zip archive: %v", err)
+		}
+		if len(archive.File) != 1 {
+			return nil, nil, fmt.Errorf("expected a single file in zip archive, found %d", len(archive.File))
+		}
+		entry, err := archive.File[0].Open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open zip entry: %v", err)
+		}
+		return entry, func() { entry.Close() }, nil
+	case "zstd":
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read zstd archive: %v", err)
+		}
+		return decoder, decoder.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression format: %s", compressionFormat)
+	}
+}
+
+// writeChecksum atomically writes the sidecar of archivePath.
+func writeChecksum(fsys FS, archivePath string, sum []byte) error {
+	sidecar := archivePath + checksumSuffix
+	tempPath := tempName(sidecar)
+
+	file, err := fsys.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create checksum file: %v", err)
+	}
+
+	_, err = fmt.Fprintf(file, "%s  %s\n", hex.EncodeToString(sum), filepath.Base(archivePath))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Rename(tempPath, sidecar)
+	}
+	if err != nil {
+		fsys.Remove(tempPath)
+		return fmt.Errorf("failed to write checksum file: %v", err)
+	}
+	return nil
+}
+
+// readChecksum returns the sha256 recorded in the sidecar of archivePath.
+func readChecksum(fsys FS, archivePath string) ([]byte, error) {
+	file, err := fsys.Open(archivePath + checksumSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, 4096))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum file: %v", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("checksum file of %s is empty", archivePath)
+	}
+	sum, err := hex.DecodeString(fields[0])
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid checksum in checksum file of %s", archivePath)
+	}
+	return sum, nil
+}
+
+// verifyChecksum hashes archivePath and compares it with its sidecar.
+func verifyChecksum(ctx context.Context, fsys FS, archivePath string) error {
+	want, err := readChecksum(fsys, archivePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := fsys.Open(archivePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("archive is missing")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	got := newDigest()
+	if _, err := io.Copy(got, &contextReader{ctx: ctx, r: file}); err != nil {
+		return fmt.Errorf("failed to read archive: %v", err)
+	}
+	if !bytes.Equal(got.Sum(), want) {
+		return fmt.Errorf("%w: archive does not match its checksum file", ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// ArchiveCheck is the result of checking an archive against its checksum
+// sidecar.
+type ArchiveCheck struct {
+	Entry string
+	Path  string
+	Err   error // nil when the archive matches its sidecar
+}
+
+// archiveLocations returns globs matching the archives the pipeline of entry
+// leaves behind: next to the files it matches, as the bundles of its archive
+// and compact steps and below the destinations of its copy and move steps,
+// following the files through the steps in order.
+func (entry *LogEntry) archiveLocations() []string {
+	var globs []string
+	seen := make(map[string]bool)
+	add := func(found []string) {
+		for _, glob := range found {
+			if !seen[glob] {
+				seen[glob] = true
+				globs = append(globs, glob)
+			}
+		}
+	}
+
+	steps := entry.pipeline()
+	for _, pattern := range entry.Path {
+		pattern = filepath.Clean(pattern)
+		root := globRoot(pattern)
+		current := []string{pattern, pattern + ".*"}
+		add(current)
+		for i := range steps {
+			step := &steps[i]
+			switch step.Action {
+			case actionArchive, actionCompact:
+				root = globRoot(entry.Path[0])
+				_, bundles := bundleName(step, entry.Name, root, time.Time{})
+				current = []string{bundles}
+			case actionCopy, actionMove:
+				if step.Destination == nil {
+					continue
+				}
+				var mirrored []string
+				for _, glob := range current {
+					mirrored = append(mirrored, mirrorGlobs(*step.Destination, root, glob)...)
+				}
+				if step.Action == actionMove {
+					current = mirrored
+				} else {
+					current = append(current, mirrored...)
+				}
+			default:
+				continue
+			}
+			add(current)
+		}
+	}
+	return globs
+}
+
+// mirrorGlobs returns the globs matching the files matched by glob once
+// copied or moved below destination, including the numbered directories of
+// the rename conflict policy, see mirrorPath.
+func mirrorGlobs(destination, root, glob string) []string {
+	relative, err := filepath.Rel(root, glob)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		relative = filepath.Base(glob)
+	}
+	target := filepath.Join(destination, relative)
+	dir, name := filepath.Split(target)
+	return []string{target, filepath.Join(dir, "[0-9]*", name)}
+}
+
+// VerifyArchives checks the archives of every entry but delete ones against
+// their .sha256 sidecars, wherever its pipeline leaves them, see
+// archiveLocations. Archives without a sidecar are not checked.
+func (e *Engine) VerifyArchives(ctx context.Context) []ArchiveCheck {
+	e.mu.Lock()
+	entries := e.entries
+	e.mu.Unlock()
+
+	var checks []ArchiveCheck
+	seen := make(map[string]bool)
+	for _, logEntry := range entries {
+		if logEntry.Type == "delete" {
+			continue
+		}
+
+		for _, glob := range logEntry.archiveLocations() {
+			sidecars, err := e.fs.Glob(glob + checksumSuffix)
+			if err != nil {
+				checks = append(checks, ArchiveCheck{Entry: logEntry.Name, Path: glob, Err: fmt.Errorf("failed to list checksum files: %v", err)})
+				continue
+			}
+			sort.Strings(sidecars)
+
+			for _, sidecar := range sidecars {
+				archive := strings.TrimSuffix(sidecar, checksumSuffix)
+				if seen[archive] {
+					continue
+				}
+				seen[archive] = true
+
+				checks = append(checks, ArchiveCheck{Entry: logEntry.Name, Path: archive, Err: verifyChecksum(ctx, e.fs, archive)})
+			}
+		}
+	}
+	return checks
+}
@@ -0,0 +1,195 @@
+package logrotate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressFileChecksum(t *testing.T) {
+	for _, format := range []string{"gzip", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			tempDir := t.TempDir()
+			file := filepath.Join(tempDir, "app.log.1")
+			_ = os.WriteFile(file, []byte(strings.Repeat("log line\n", 1000)), 0644)
+
+			result, err := compressFile(context.Background(), OSFS{}, file, format, compressOptions{Verify: true, Checksum: true})
+			if err != nil {
+				t.Fatalf("compressFile() error: %v", err)
+			}
+			archive := result.Path
+
+			data, _ := os.ReadFile(archive)
+			sum := sha256.Sum256(data)
+			sidecar, err := os.ReadFile(archive + checksumSuffix)
+			if err != nil {
+				t.Fatalf("Expected checksum sidecar: %v", err)
+			}
+			want := hex.EncodeToString(sum[:]) + "  " + filepath.Base(archive) + "\n"
+			if string(sidecar) != want {
+				t.Errorf("Expected sidecar %q, got %q", want, sidecar)
+			}
+
+			if err := verifyChecksum(context.Background(), OSFS{}, archive); err != nil {
+				t.Errorf("verifyChecksum() error: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyArchiveMismatch(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log.1")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+
+	result, err := compressFile(context.Background(), OSFS{}, file, "gzip", compressOptions{})
+	if err != nil {
+		t.Fatalf("compressFile() error: %v", err)
+	}
+	archive := result.Path
+
+	want := newDigest()
+	want.Write([]byte("other line\n"))
+	if err := verifyArchive(context.Background(), OSFS{}, archive, "gzip", want); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch for different original, got %v", err)
+	}
+
+	_ = os.WriteFile(archive, []byte("not gzip"), 0644)
+	if err := verifyArchive(context.Background(), OSFS{}, archive, "gzip", want); err == nil {
+		t.Errorf("Expected error for corrupt archive")
+	}
+}
+
+func TestVerifyArchives(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"good.log.1", "tampered.log.1", "unchecked.log.1"} {
+		file := filepath.Join(tempDir, name)
+		_ = os.WriteFile(file, []byte("log line\n"), 0644)
+		options := compressOptions{Checksum: name != "unchecked.log.1"}
+		if _, err := compressFile(context.Background(), OSFS{}, file, "gzip", options); err != nil {
+			t.Fatalf("compressFile() error: %v", err)
+		}
+	}
+	_ = os.WriteFile(filepath.Join(tempDir, "tampered.log.1.gz"), []byte("tampered"), 0644)
+
+	engine := New(Config{
+		Schedule: "@every 1h",
+		Logs:     []LogEntry{{Name: "app", Path: Paths{filepath.Join(tempDir, "*.log")}, Type: "rotate"}},
+	}, Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+
+	checks := engine.VerifyArchives(context.Background())
+	if len(checks) != 2 {
+		t.Fatalf("Expected 2 archives with sidecars to be checked, got %+v", checks)
+	}
+	for _, check := range checks {
+		tampered := strings.Contains(check.Path, "tampered")
+		if tampered && !errors.Is(check.Err, ErrChecksumMismatch) {
+			t.Errorf("Expected checksum mismatch for %s, got %v", check.Path, check.Err)
+		}
+		if !tampered && check.Err != nil {
+			t.Errorf("Expected %s to match its sidecar, got %v", check.Path, check.Err)
+		}
+		if check.Entry != "app" {
+			t.Errorf("Expected check for entry app, got %+v", check)
+		}
+	}
+}
+
+func TestVerifyArchivesMoved(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+	file := filepath.Join(source, "app.log.1")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+	if _, err := compressFile(context.Background(), OSFS{}, file, "gzip", compressOptions{Checksum: true}); err != nil {
+		t.Fatalf("compressFile() error: %v", err)
+	}
+
+	moveEntry := LogEntry{
+		Name:      "app",
+		Path:      Paths{filepath.Join(source, "app.log.*")},
+		Type:      "move",
+		Condition: &Condition{Destination: stringPtr(destination)},
+	}
+	engine := New(Config{Schedule: "@every 1h", Logs: []LogEntry{moveEntry}}, Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := engine.task(moveEntry)(context.Background()); err != nil {
+		t.Fatalf("move error: %v", err)
+	}
+
+	moved := filepath.Join(destination, "app.log.1.gz")
+	for _, path := range []string{moved, moved + checksumSuffix} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("Expected %s to be moved, got %v", path, err)
+		}
+	}
+
+	checks := engine.VerifyArchives(context.Background())
+	if len(checks) != 1 || checks[0].Path != moved || checks[0].Err != nil {
+		t.Fatalf("Expected moved archive to be checked and match, got %+v", checks)
+	}
+
+	_ = os.WriteFile(moved, []byte("tampered"), 0644)
+	checks = engine.VerifyArchives(context.Background())
+	if len(checks) != 1 || !errors.Is(checks[0].Err, ErrChecksumMismatch) {
+		t.Errorf("Expected checksum mismatch for tampered moved archive, got %+v", checks)
+	}
+}
+
+func TestArchiveLocations(t *testing.T) {
+	entry := LogEntry{
+		Name: "app",
+		Path: Paths{filepath.Join("logs", "*.log")},
+		Type: "pipeline",
+		Steps: []Step{
+			{Action: actionCopy, Condition: Condition{Destination: stringPtr("copies")}},
+			{Action: actionArchive},
+			{Action: actionMove, Condition: Condition{Destination: stringPtr("bundles")}},
+		},
+	}
+	want := []string{
+		filepath.Join("logs", "*.log"),
+		filepath.Join("logs", "*.log.*"),
+		filepath.Join("copies", "*.log"),
+		filepath.Join("copies", "[0-9]*", "*.log"),
+		filepath.Join("copies", "*.log.*"),
+		filepath.Join("copies", "[0-9]*", "*.log.*"),
+		filepath.Join("logs", "app.*-**.tar.gz"),
+		filepath.Join("bundles", "app.*-**.tar.gz"),
+		filepath.Join("bundles", "[0-9]*", "app.*-**.tar.gz"),
+	}
+	if got := entry.archiveLocations(); !slices.Equal(got, want) {
+		t.Errorf("archiveLocations() = %v, want %v", got, want)
+	}
+}
+
+func TestRemoveOldFilesChecksums(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"app.log.1.gz", "app.log.1.gz.sha256", "app.log.2.gz", "app.log.2.gz.sha256"} {
+		_ = os.WriteFile(filepath.Join(tempDir, name), []byte("data"), 0644)
+	}
+	old := filepath.Join(tempDir, "app.log.1.gz")
+	oldTime := time.Now().Add(-time.Hour)
+	_ = os.Chtimes(old, oldTime, oldTime)
+
+	removed, err := removeOldFiles(OSFS{}, slog.New(slog.NewTextHandler(io.Discard, nil)), tempDir, "app.log", 1)
+	if err != nil {
+		t.Fatalf("removeOldFiles() error: %v", err)
+	}
+	if len(removed) != 1 || removed[0].Path != old {
+		t.Errorf("Expected only the oldest archive to be pruned, got %+v", removed)
+	}
+	if _, err := os.Stat(old + checksumSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected sidecar to be removed with its archive")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "app.log.2.gz.sha256")); err != nil {
+		t.Errorf("Expected sidecar of kept archive to be kept: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+package logrotate
+
+import "time"
+
+// Clock tells the engine the current time, which file ages and rotated file
+// names are based on.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,151 @@
+package logrotate
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Periods compact steps group archives by.
+const (
+	periodDay   = "day"
+	periodMonth = "month"
+)
+
+// defaultCompactName returns the name template of the bundles of a compact
+// step, naming the period they hold.
+func defaultCompactName(step *Step) string {
+	if step.Period != nil && *step.Period == periodMonth {
+		return "{entry}.{year}-{month}"
+	}
+	return "{entry}.{year}-{month}-{day}"
+}
+
+// periodStart returns the start of the period t is in.
+func periodStart(t time.Time, period string) time.Time {
+	year, month, day := t.Date()
+	if period == periodMonth {
+		day = 1
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// periodEnd returns the start of the period following the one starting at
+// start.
+func periodEnd(start time.Time, period string) time.Time {
+	if period == periodMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// compactStep adds the file to the archives the run compacts. Only archives
+// are compacted: plain files, sidecars and temporary files are left alone,
+// sidecars are bundled with their archive.
+func (e *Engine) compactStep(run *taskRun, file *pipelineFile) (bool, error) {
+	name := filepath.Base(file.path)
+	if !isArchive(name) || isSidecar(name) || strings.HasSuffix(name, tempName("")) {
+		return false, nil
+	}
+	run.addToBundle(file.path)
+	return false, nil
+}
+
+// compactFiles groups files by the period of step they were last modified in
+// and bundles every group of a period that ended at least the age of step
+// ago, along with the sidecars of its files. A bundle is verified and dated
+// to its newest file before the files are deleted, so bundles of days can be
+// compacted into bundles of months in turn. Bundles of earlier runs matched
+// again are left alone. It returns the bundles written.
+func (e *Engine) compactFiles(ctx context.Context, run *taskRun, step *Step, root string, files []string) ([]*pipelineFile, error) {
+	var minAge time.Duration
+	if step.Age != nil {
+		age, err := ParseDuration(*step.Age)
+		if err != nil {
+			return nil, stepFailed("config", "Invalid age format", root, err)
+		}
+		minAge = age
+	}
+	now := e.clock.Now()
+	_, pattern := bundleName(step, run.entry, root, now)
+
+	type group struct {
+		files   []string
+		newest  time.Time
+		members int
+	}
+	groups := make(map[time.Time]*group)
+	for _, file := range files {
+		if matched, _ := filepath.Match(pattern, file); matched {
+			continue
+		}
+		fileInfo, err := e.fs.Stat(file)
+		if err != nil {
+			return nil, stepFailed("stat", "Failed to get file info", file, err)
+		}
+		modTime := fileInfo.ModTime().In(now.Location())
+		start := periodStart(modTime, *step.Period)
+		if now.Sub(periodEnd(start, *step.Period)) < minAge {
+			continue
+		}
+
+		g := groups[start]
+		if g == nil {
+			g = &group{}
+			groups[start] = g
+		}
+		g.files = append(g.files, file)
+		for _, suffix := range sidecarSuffixes {
+			if _, err := e.fs.Stat(file + suffix); err == nil {
+				g.files = append(g.files, file+suffix)
+			}
+		}
+		g.members++
+		if modTime.After(g.newest) {
+			g.newest = modTime
+		}
+	}
+
+	starts := make([]time.Time, 0, len(groups))
+	for start := range groups {
+		starts = append(starts, start)
+	}
+	slices.SortFunc(starts, func(a, b time.Time) int { return a.Compare(b) })
+
+	var bundles []*pipelineFile
+	for _, start := range starts {
+		if ctx.Err() != nil {
+			return bundles, stepFailed("cancelled", "Task cancelled", root, ctx.Err())
+		}
+		g := groups[start]
+		slices.Sort(g.files)
+
+		name, _ := bundleName(step, run.entry, root, start)
+		bundled, sums, err := e.bundleFiles(ctx, step, name, pattern, root, g.files, true)
+		if err != nil {
+			return bundles, err
+		}
+		if err := e.fs.Chtimes(bundled.path, g.newest, g.newest); err != nil {
+			return bundles, stepFailed("compact", "Failed to date archive", bundled.path, err)
+		}
+
+		var total int64
+		for _, sum := range sums {
+			total += sum.size
+		}
+		run.logger.Info("Compacted archives", "path", bundled.path, "period", start.Format(time.DateOnly), "archives", g.members, "bytes", total)
+
+		for i, file := range g.files {
+			if err := e.fs.Remove(file); err != nil {
+				return bundles, stepFailed("compact", "Failed to remove compacted archive", file, err)
+			}
+			if !isSidecar(file) {
+				run.done(FileEvent{Action: "compacted", Path: file, NewPath: bundled.path, Size: sums[i].size})
+			}
+		}
+		bundles = append(bundles, bundled)
+	}
+	return bundles, nil
+}
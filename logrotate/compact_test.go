@@ -0,0 +1,124 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompactFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Date(2024, 9, 30, 12, 0, 0, 0, time.Local)
+	write := func(name, content string, modTime time.Time) string {
+		path := filepath.Join(tempDir, name)
+		_ = os.WriteFile(path, []byte(content), 0644)
+		_ = os.Chtimes(path, modTime, modTime)
+		return path
+	}
+	hourly := []string{
+		write("app.log.20240912-100000.gz", "10h", time.Date(2024, 9, 12, 10, 0, 0, 0, time.Local)),
+		write("app.log.20240912-110000.gz", "11h", time.Date(2024, 9, 12, 11, 0, 0, 0, time.Local)),
+		write("app.log.20240912-110000.gz.sha256", "checksum", time.Date(2024, 9, 12, 11, 0, 0, 0, time.Local)),
+		write("app.log.20240913-100000.gz", "next day", time.Date(2024, 9, 13, 10, 0, 0, 0, time.Local)),
+	}
+	recent := write("app.log.20240925-100000.gz", "recent", time.Date(2024, 9, 25, 10, 0, 0, 0, time.Local))
+	plain := write("app.log.20240912-120000", "not compressed yet", time.Date(2024, 9, 12, 12, 0, 0, 0, time.Local))
+
+	var actions []string
+	engine := New(Config{}, Options{Clock: &fakeClock{now: now}, Hooks: Hooks{OnFile: func(event FileEvent) { actions = append(actions, event.Action) }}})
+	daily := LogEntry{
+		Name:      "app-daily",
+		Path:      Paths{filepath.Join(tempDir, "app.log.*")},
+		Type:      "compact",
+		Condition: &Condition{Period: stringPtr(periodDay), Age: stringPtr("7d")},
+	}
+	if err := engine.task(daily)(context.Background()); err != nil {
+		t.Fatalf("task() error: %v", err)
+	}
+
+	first := filepath.Join(tempDir, "app-daily.2024-09-12.tar")
+	second := filepath.Join(tempDir, "app-daily.2024-09-13.tar")
+	want := map[string]string{
+		"app.log.20240912-100000.gz":        "10h",
+		"app.log.20240912-110000.gz":        "11h",
+		"app.log.20240912-110000.gz.sha256": "checksum",
+	}
+	if got := bundleContents(t, first, bundleTar); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected bundle of %v, got %v", want, got)
+	}
+	if got := bundleContents(t, second, bundleTar); !reflect.DeepEqual(got, map[string]string{"app.log.20240913-100000.gz": "next day"}) {
+		t.Errorf("Expected bundle of the next day, got %v", got)
+	}
+	if fileInfo, err := os.Stat(first); err != nil || !fileInfo.ModTime().Equal(time.Date(2024, 9, 12, 11, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected bundle dated to its newest archive, got %v", fileInfo.ModTime())
+	}
+	for _, file := range hourly {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", file, err)
+		}
+	}
+	for _, kept := range []string{recent, plain} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", kept, err)
+		}
+	}
+	wantActions := []string{"compacted", "compacted", "compacted"}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Errorf("Expected actions %v, got %v", wantActions, actions)
+	}
+
+	// Running again leaves the bundles alone, and a monthly entry rolls
+	// them up once the month is old enough.
+	if err := engine.task(daily)(context.Background()); err != nil {
+		t.Fatalf("task() error: %v", err)
+	}
+	if bundles, _ := filepath.Glob(filepath.Join(tempDir, "*.tar")); len(bundles) != 2 {
+		t.Errorf("Expected the daily bundles to be left alone, got %v", bundles)
+	}
+
+	engine.clock = &fakeClock{now: time.Date(2024, 10, 10, 12, 0, 0, 0, time.Local)}
+	monthly := LogEntry{
+		Name:      "app-monthly",
+		Path:      Paths{filepath.Join(tempDir, "app-daily.*.tar")},
+		Type:      "compact",
+		Condition: &Condition{Period: stringPtr(periodMonth), Age: stringPtr("7d"), ArchiveFormat: stringPtr(bundleTarZstd), MaxKeep: intPtr(3)},
+	}
+	if err := engine.task(monthly)(context.Background()); err != nil {
+		t.Fatalf("task() error: %v", err)
+	}
+	bundle := filepath.Join(tempDir, "app-monthly.2024-09.tar.zst")
+	if got := bundleContents(t, bundle, bundleTarZstd); len(got) != 2 {
+		t.Errorf("Expected monthly bundle of both daily bundles, got %v", got)
+	}
+}
+
+func TestVerifyBundle(t *testing.T) {
+	tempDir := t.TempDir()
+	files := []string{filepath.Join(tempDir, "a.log.gz"), filepath.Join(tempDir, "b.log.gz")}
+	for _, file := range files {
+		_ = os.WriteFile(file, []byte(filepath.Base(file)), 0644)
+	}
+
+	for _, format := range []string{bundleTar, bundleTarGzip, bundleTarZstd, bundleZip} {
+		bundle := filepath.Join(tempDir, "bundle."+format)
+		sums, err := writeBundle(context.Background(), OSFS{}, bundle, format, tempDir, files)
+		if err != nil {
+			t.Fatalf("writeBundle() error: %v", err)
+		}
+		if err := verifyBundle(context.Background(), OSFS{}, bundle, format, tempDir, files, sums); err != nil {
+			t.Errorf("Expected %s bundle to verify, got %v", format, err)
+		}
+
+		sums[1] = newDigest()
+		if err := verifyBundle(context.Background(), OSFS{}, bundle, format, tempDir, files, sums); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("Expected checksum mismatch for %s bundle, got %v", format, err)
+		}
+		if err := verifyBundle(context.Background(), OSFS{}, bundle, format, tempDir, files[:1], sums); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("Expected mismatch for unexpected member of %s bundle, got %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package logrotate
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressOptions are the optional steps of compressFile.
+type compressOptions struct {
+	Verify    bool         `json:"verify,omitempty"`     // decompress the archive and compare it with the original before removing it
+	Checksum  bool         `json:"checksum,omitempty"`   // write a sha256 sidecar next to the archive
+	EncryptTo []string     `json:"encrypt_to,omitempty"` // public keys the archive is encrypted to
+	SignWith  string       `json:"sign_with,omitempty"`  // Ed25519 key file signing the archive
+	Redact    []RedactRule `json:"redact,omitempty"`     // rules masking or dropping lines before compression
+	Level     int          `json:"level,omitempty"`      // compression level recorded in the archive, 0 for the default of the format
+}
+
+// compressResult describes the archive written by compressFile.
+type compressResult struct {
+	Path     string
+	Size     int64
+	Redacted int64 // lines masked by redaction rules
+	Dropped  int64 // lines dropped by redaction rules
+}
+
+func compressOptionsOf(condition *Condition) compressOptions {
+	var options compressOptions
+	if condition != nil {
+		options.Verify = condition.Verify != nil && *condition.Verify
+		options.Checksum = condition.Checksum != nil && *condition.Checksum
+		options.EncryptTo = condition.EncryptTo
+		if condition.SignWith != nil {
+			options.SignWith = *condition.SignWith
+		}
+		options.Redact = condition.Redact
+		if condition.CompressionLevel != nil {
+			options.Level = *condition.CompressionLevel
+		}
+	}
+	return options
+}
+
+func compressionFormat(condition *Condition) string {
+	if condition != nil && condition.CompressionFormat != nil {
+		return *condition.CompressionFormat
+	}
+	return "gzip"
+}
+
+// rotatedFileName returns the name a rotated copy of file should be renamed to,
+// making sure it does not clash with an earlier rotation in the same second.
+func rotatedFileName(fsys FS, now time.Time, file string) string {
+	base := fmt.Sprintf("%s.%s", file, now.Format("20060102-150405"))
+	rotated := base
+	for i := 1; ; i++ {
+		existing, _ := fsys.Glob(rotated + "*")
+		if len(existing) == 0 {
+			return rotated
+		}
+		rotated = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// archiveName returns the name of the archive compressing filePath in the
+// given format, with the .enc extension when it is encrypted.
+func archiveName(filePath string, compressionFormat string, options compressOptions) (string, error) {
+	var name string
+	switch compressionFormat {
+	case "gzip":
+		name = filePath + ".gz"
+	case "zip":
+		name = filePath + ".zip"
+	case "zstd":
+		name = filePath + ".zst"
+	default:
+		return "", fmt.Errorf("unsupported compression format: %s", compressionFormat)
+	}
+
+	if len(options.EncryptTo) > 0 {
+		name += encryptedSuffix
+	}
+	return name, nil
+}
+
+// sidecarSuffixes are the extensions of the files kept next to an archive,
+// which share its lifetime.
+var sidecarSuffixes = []string{checksumSuffix, signatureSuffix}
+
+func isSidecar(name string) bool {
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tempName is the name an archive is written to before it is complete.
+func tempName(archivePath string) string {
+	return archivePath + ".tmp"
+}
+
+// compressFile compresses filePath into a sibling archive in the given
+// format, removes the original and returns the name and size of the archive.
+// With options.Redact, lines are masked or dropped on the way into the
+// archive.
+//
+// The archive is written to a temporary file that is synced and renamed into
+// place once complete, so the archive name never refers to a partial archive
+// and the original is only removed after its archive is durable. With
+// options.Verify the temporary file is decompressed and compared with the
+// original first. With options.EncryptTo it is then encrypted, and with
+// options.Checksum a sidecar with the sha256 of the final archive, and with
+// options.SignWith its detached signature, are written before the rename. If
+// any step fails or ctx is cancelled, the temporary file is removed and the
+// original is left in place.
+//
+// With options.Level the archive is compressed at that level, which is
+// recorded in it for recompression, see archiveLevel.
+func compressFile(ctx context.Context, fsys FS, filePath string, compressionFormat string, options compressOptions) (compressResult, error) {
+	compressedFilePath, err := archiveName(filePath, compressionFormat, options)
+	if err != nil {
+		return compressResult{}, err
+	}
+	rules, err := compileRules(options.Redact)
+	if err != nil {
+		return compressResult{}, err
+	}
+
+	var compressFunc func(input io.Reader, output io.Writer) error
+	switch compressionFormat {
+	case "gzip":
+		compressFunc = func(input io.Reader, output io.Writer) error {
+			gzipWriter, err := gzip.NewWriterLevel(output, deflateLevel(options.Level))
+			if err != nil {
+				return fmt.Errorf("failed to start gzip stream: %v", err)
+			}
+			if options.Level != 0 {
+				gzipWriter.Comment = levelComment(options.Level)
+			}
+
+			if _, err := io.Copy(gzipWriter, input); err != nil {
+				gzipWriter.Close()
+				return fmt.Errorf("failed to compress file with gzip: %v", err)
+			}
+			if err := gzipWriter.Close(); err != nil {
+				return fmt.Errorf("failed to finish gzip stream: %v", err)
+			}
+			return nil
+		}
+	case "zip":
+		compressFunc = func(input io.Reader, output io.Writer) error {
+			archive := zip.NewWriter(output)
+			if options.Level != 0 {
+				archive.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+					return flate.NewWriter(w, options.Level)
+				})
+				if err := archive.SetComment(levelComment(options.Level)); err != nil {
+					return fmt.Errorf("failed to record compression level: %v", err)
+				}
+			}
+
+			writer, err := archive.Create(filepath.Base(filePath))
+			if err != nil {
+				archive.Close()
+				return fmt.Errorf("failed to create zip entry: %v", err)
+			}
+
+			if _, err := io.Copy(writer, input); err != nil {
+				archive.Close()
+				return fmt.Errorf("failed to write to zip: %v", err)
+			}
+			if err := archive.Close(); err != nil {
+				return fmt.Errorf("failed to finish zip archive: %v", err)
+			}
+			return nil
+		}
+	case "zstd":
+		compressFunc = func(input io.Reader, output io.Writer) error {
+			if options.Level != 0 {
+				if err := writeLevelFrame(output, options.Level); err != nil {
+					return fmt.Errorf("failed to record compression level: %v", err)
+				}
+			}
+			encoder, err := zstd.NewWriter(output, zstd.WithEncoderLevel(zstdLevel(options.Level)))
+			if err != nil {
+				return fmt.Errorf("failed to start zstd stream: %v", err)
+			}
+			if _, err := io.Copy(encoder, input); err != nil {
+				encoder.Close()
+				return fmt.Errorf("failed to compress file with zstd: %v", err)
+			}
+			if err := encoder.Close(); err != nil {
+				return fmt.Errorf("failed to finish zstd stream: %v", err)
+			}
+			return nil
+		}
+	}
+
+	inputFile, err := fsys.Open(filePath)
+	if err != nil {
+		return compressResult{}, fmt.Errorf("failed to open file for compression: %v", err)
+	}
+	defer inputFile.Close()
+
+	tempFilePath := tempName(strings.TrimSuffix(compressedFilePath, encryptedSuffix))
+	outputFile, err := fsys.Create(tempFilePath)
+	if err != nil {
+		return compressResult{}, fmt.Errorf("failed to create compressed file: %v", err)
+	}
+
+	var input io.Reader = &contextReader{ctx: ctx, r: inputFile}
+	var stats redactStats
+	if len(rules) > 0 {
+		input = newRedactReader(input, rules, &stats)
+	}
+
+	// With redaction, verification compares the archive with the redacted
+	// content rather than the original.
+	source := newDigest()
+	archive := newDigest()
+	err = compressFunc(io.TeeReader(input, source), io.MultiWriter(outputFile, archive))
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		if syncErr := outputFile.Sync(); syncErr != nil {
+			err = fmt.Errorf("failed to sync compressed file: %v", syncErr)
+		}
+	}
+	if closeErr := outputFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close compressed file: %v", closeErr)
+	}
+	if err == nil && options.Verify {
+		err = verifyArchive(ctx, fsys, tempFilePath, compressionFormat, source)
+	}
+	if err == nil && len(options.EncryptTo) > 0 {
+		encryptedFilePath := tempName(compressedFilePath)
+		archive = newDigest()
+		err = encryptFile(fsys, tempFilePath, encryptedFilePath, options.EncryptTo, archive)
+		fsys.Remove(tempFilePath)
+		tempFilePath = encryptedFilePath
+	}
+	if err == nil && options.Checksum {
+		err = writeChecksum(fsys, compressedFilePath, archive.Sum())
+	}
+	if err == nil && options.SignWith != "" {
+		err = writeSignature(fsys, compressedFilePath, archive.Sum(), options.SignWith)
+	}
+	if err == nil {
+		if renameErr := fsys.Rename(tempFilePath, compressedFilePath); renameErr != nil {
+			err = fmt.Errorf("failed to rename compressed file into place: %v", renameErr)
+		}
+	}
+	if err == nil {
+		// The original goes only once the archive is there after a crash.
+		if err = syncDir(fsys, filepath.Dir(compressedFilePath)); err != nil {
+			fsys.Remove(compressedFilePath)
+		}
+	}
+	if err != nil {
+		fsys.Remove(tempFilePath)
+		for _, suffix := range sidecarSuffixes {
+			fsys.Remove(compressedFilePath + suffix)
+		}
+		return compressResult{}, err
+	}
+
+	if err := inputFile.Close(); err != nil {
+		return compressResult{}, fmt.Errorf("failed to close input file: %v", err)
+	}
+
+	if err := fsys.Remove(filePath); err != nil {
+		return compressResult{}, fmt.Errorf("failed to remove original file after compression: %v", err)
+	}
+
+	fileInfo, err := fsys.Stat(compressedFilePath)
+	if err != nil {
+		return compressResult{}, fmt.Errorf("failed to get compressed file info: %v", err)
+	}
+
+	return compressResult{Path: compressedFilePath, Size: fileInfo.Size(), Redacted: stats.Redacted, Dropped: stats.Dropped}, nil
+}
+
+// contextReader stops reading from r once ctx is cancelled, so long copies
+// can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// removeOldFiles keeps the newest maxKeep rotated copies of baseFileName in
+// dir and returns an event for every file it removed.
+func removeOldFiles(fsys FS, logger *slog.Logger, dir, baseFileName string, maxKeep int) ([]FileEvent, error) {
+	return removeMatchingFiles(fsys, logger, filepath.Join(dir, baseFileName+".*"), maxKeep)
+}
+
+// removeMatchingFiles keeps the newest maxKeep files matching pattern,
+// removing the others along with their sidecars.
+func removeMatchingFiles(fsys FS, logger *slog.Logger, pattern string, maxKeep int) ([]FileEvent, error) {
+	candidates, err := fsys.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rotated log files: %v", err)
+	}
+
+	// Sidecars are removed along with their archive.
+	var matches []string
+	for _, match := range candidates {
+		if !isSidecar(match) {
+			matches = append(matches, match)
+		}
+	}
+
+	// Chunks of a split file may share a modification time, their names
+	// order them then.
+	sort.SliceStable(matches, func(i, j int) bool {
+		fileInfoI, err := fsys.Stat(matches[i])
+		if err != nil {
+			return false
+		}
+		fileInfoJ, err := fsys.Stat(matches[j])
+		if err != nil {
+			return true
+		}
+		return fileInfoI.ModTime().Before(fileInfoJ.ModTime())
+	})
+
+	var removed []FileEvent
+	for len(matches) > maxKeep {
+		oldestFile := matches[0]
+		var size int64
+		if fileInfo, err := fsys.Stat(oldestFile); err == nil {
+			size = fileInfo.Size()
+		}
+		if err := fsys.Remove(oldestFile); err != nil {
+			return removed, fmt.Errorf("failed to remove old log file %s: %v", oldestFile, err)
+		}
+		for _, suffix := range sidecarSuffixes {
+			if err := fsys.Remove(oldestFile + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return removed, fmt.Errorf("failed to remove %s file of %s: %v", suffix, oldestFile, err)
+			}
+		}
+		logger.Info("Removed old log file", "path", oldestFile, "action", "prune", "bytes", size)
+		removed = append(removed, FileEvent{Action: "pruned", Path: oldestFile, Size: size})
+		matches = matches[1:]
+	}
+
+	return removed, nil
+}
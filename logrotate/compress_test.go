@@ -0,0 +1,239 @@
+package logrotate
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCompressFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	originalFilePath := filepath.Join(tempDir, "test.log")
+	originalContent := []byte("This is a test log file content.")
+	err := os.WriteFile(originalFilePath, originalContent, 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test log file: %v", err)
+	}
+
+	t.Run("gzip Compression", func(t *testing.T) {
+		_, err = compressFile(context.Background(), OSFS{}, originalFilePath, "gzip", compressOptions{})
+		if err != nil {
+			t.Fatalf("compressFile() error: %v", err)
+		}
+
+		compressedFilePath := originalFilePath + ".gz"
+		if _, err := os.Stat(compressedFilePath); os.IsNotExist(err) {
+			t.Errorf("Compressed file does not exist: %s", compressedFilePath)
+		}
+
+		if _, err := os.Stat(originalFilePath); err == nil {
+			t.Errorf("Original file still exists: %s", originalFilePath)
+		}
+
+		compressedFile, err := os.Open(compressedFilePath)
+		if err != nil {
+			t.Fatalf("Failed to open compressed file: %v", err)
+		}
+		defer compressedFile.Close()
+
+		gzipReader, err := gzip.NewReader(compressedFile)
+		if err != nil {
+			t.Fatalf("Failed to create gzip reader: %v", err)
+		}
+		defer gzipReader.Close()
+
+		var decompressedContent bytes.Buffer
+		if _, err := io.Copy(&decompressedContent, gzipReader); err != nil {
+			t.Fatalf("Failed to decompress file: %v", err)
+		}
+
+		if !bytes.Equal(decompressedContent.Bytes(), originalContent) {
+			t.Errorf("Decompressed content does not match original. Got: %s, Want: %s", decompressedContent.String(), string(originalContent))
+		}
+	})
+
+	t.Run("Zip Compression", func(t *testing.T) {
+		err = os.WriteFile(originalFilePath, originalContent, 0644)
+		if err != nil {
+			t.Fatalf("Failed to create test log file: %v", err)
+		}
+
+		_, err = compressFile(context.Background(), OSFS{}, originalFilePath, "zip", compressOptions{})
+		if err != nil {
+			t.Fatalf("compressFile() error: %v", err)
+		}
+
+		compressedFilePath := originalFilePath + ".zip"
+		if _, err := os.Stat(compressedFilePath); os.IsNotExist(err) {
+			t.Errorf("Compressed file does not exist: %s", compressedFilePath)
+		}
+
+		if _, err := os.Stat(originalFilePath); err == nil {
+			t.Errorf("Original file still exists: %s", originalFilePath)
+		}
+
+		zipFile, err := os.Open(compressedFilePath)
+		if err != nil {
+			t.Fatalf("Failed to open zip file: %v", err)
+		}
+		defer zipFile.Close()
+
+		zipReader, err := zip.NewReader(zipFile, zipFileSize(zipFile))
+		if err != nil {
+			t.Fatalf("Failed to create zip reader: %v", err)
+		}
+
+		if len(zipReader.File) == 0 || zipReader.File[0].Name != "test.log" {
+			t.Fatalf("Expected zipped file to contain 'test.log', got: %v", zipReader.File)
+		}
+
+		zippedFile, err := zipReader.File[0].Open()
+		if err != nil {
+			t.Fatalf("Failed to open file inside zip: %v", err)
+		}
+		defer zippedFile.Close()
+
+		var decompressedContent bytes.Buffer
+		if _, err := io.Copy(&decompressedContent, zippedFile); err != nil {
+			t.Fatalf("Failed to decompress file from zip: %v", err)
+		}
+
+		if !bytes.Equal(decompressedContent.Bytes(), originalContent) {
+			t.Errorf("Decompressed content from zip does not match original. Got: %s, Want: %s", decompressedContent.String(), string(originalContent))
+		}
+	})
+}
+
+func TestCompressFileCancelled(t *testing.T) {
+	tempDir := t.TempDir()
+
+	originalFilePath := filepath.Join(tempDir, "test.log")
+	if err := os.WriteFile(originalFilePath, make([]byte, 1024*1024), 0644); err != nil {
+		t.Fatalf("Failed to create test log file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := compressFile(ctx, OSFS{}, originalFilePath, "gzip", compressOptions{}); err == nil {
+		t.Fatalf("Expected error for cancelled compression, got none")
+	}
+
+	if _, err := os.Stat(originalFilePath + ".gz"); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive to be created")
+	}
+	if _, err := os.Stat(originalFilePath + ".gz.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected partial archive to be removed")
+	}
+	if _, err := os.Stat(originalFilePath); err != nil {
+		t.Errorf("Expected original file to be kept: %v", err)
+	}
+}
+
+// dirSyncFailingFS fails to sync directories, as after losing the disk.
+type dirSyncFailingFS struct {
+	OSFS
+}
+
+func (f dirSyncFailingFS) Open(name string) (File, error) {
+	file, err := f.OSFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		return dirSyncFailingFile{file}, nil
+	}
+	return file, nil
+}
+
+type dirSyncFailingFile struct {
+	File
+}
+
+func (dirSyncFailingFile) Sync() error {
+	return errors.New("input/output error")
+}
+
+func TestCompressFileDirectorySyncFailed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directories are not synced on Windows")
+	}
+	tempDir := t.TempDir()
+
+	originalFilePath := filepath.Join(tempDir, "test.log")
+	if err := os.WriteFile(originalFilePath, []byte("line\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test log file: %v", err)
+	}
+
+	if _, err := compressFile(context.Background(), dirSyncFailingFS{}, originalFilePath, "gzip", compressOptions{Checksum: true}); err == nil {
+		t.Fatalf("Expected error for failed directory sync, got none")
+	}
+
+	if _, err := os.Stat(originalFilePath); err != nil {
+		t.Errorf("Expected original file to be kept: %v", err)
+	}
+	for _, path := range []string{originalFilePath + ".gz", originalFilePath + ".gz" + checksumSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+}
+
+// Helper function to get zip file size
+func zipFileSize(file *os.File) int64 {
+	fileInfo, _ := file.Stat()
+	return fileInfo.Size()
+}
+
+func TestCompressInPlace(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Date(2024, 9, 13, 12, 0, 0, 0, time.Local)
+
+	files := map[string]time.Duration{
+		"app.2024-09-10.log.gz": 72 * time.Hour, // compressed by an earlier run
+		"app.2024-09-11.log":    48 * time.Hour,
+		"app.2024-09-12.log":    36 * time.Hour,
+		"app.2024-09-13.log":    30 * time.Hour, // newest, so still written to
+	}
+	for name, age := range files {
+		path := filepath.Join(tempDir, name)
+		_ = os.WriteFile(path, []byte(name+"\n"), 0644)
+		_ = os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+
+	engine := New(Config{}, Options{Clock: &fakeClock{now: now}})
+	logEntry := LogEntry{
+		Name:      "app",
+		Path:      Paths{filepath.Join(tempDir, "app.*")},
+		Type:      "compress",
+		Condition: &Condition{Age: stringPtr("1d"), Checksum: boolPtr(true)},
+	}
+	for i := 0; i < 2; i++ {
+		if err := engine.task(logEntry)(context.Background()); err != nil {
+			t.Fatalf("task() error: %v", err)
+		}
+	}
+
+	remaining, _ := filepath.Glob(filepath.Join(tempDir, "*"))
+	want := []string{
+		filepath.Join(tempDir, "app.2024-09-10.log.gz"),
+		filepath.Join(tempDir, "app.2024-09-11.log.gz"),
+		filepath.Join(tempDir, "app.2024-09-11.log.gz.sha256"),
+		filepath.Join(tempDir, "app.2024-09-12.log.gz"),
+		filepath.Join(tempDir, "app.2024-09-12.log.gz.sha256"),
+		filepath.Join(tempDir, "app.2024-09-13.log"),
+	}
+	if !reflect.DeepEqual(remaining, want) {
+		t.Errorf("Expected %v, got %v", want, remaining)
+	}
+}
@@ -0,0 +1,144 @@
+package logrotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Paths []string
+
+type LogEntry struct {
+	Name      string     `yaml:"name,omitempty"`
+	Path      Paths      `yaml:"path"`
+	Type      string     `yaml:"type"` // rotate, delete, copy, move, compress, recompress, truncate, archive, compact or pipeline
+	Condition *Condition `yaml:"condition,omitempty"`
+	Steps     []Step     `yaml:"steps,omitempty"`    // steps of a pipeline entry, see Step
+	Priority  int        `yaml:"priority,omitempty"` // files of entries with a lower priority are deleted first under disk pressure
+}
+
+type Condition struct {
+	Age               *string      `yaml:"age,omitempty"` //since last modified
+	MaxKeep           *int         `yaml:"max_keep,omitempty"`
+	Size              *string      `yaml:"size,omitempty"`
+	TimeInterval      *string      `yaml:"time_interval,omitempty"`
+	Compress          *bool        `yaml:"compress,omitempty"`
+	CompressionFormat *string      `yaml:"compression_format,omitempty"` // gzip, zip or zstd
+	CompressionLevel  *int         `yaml:"compression_level,omitempty"`  // 1 to 9, or 1 to 22 for zstd, defaults to the default of the format
+	Verify            *bool        `yaml:"verify,omitempty"`             // check the archive against the original before removing it
+	Checksum          *bool        `yaml:"checksum,omitempty"`           // write a .sha256 sidecar for every archive
+	EncryptTo         []string     `yaml:"encrypt_to,omitempty"`         // X25519 public keys, as PEM or PEM file paths, archives are encrypted to
+	SignWith          *string      `yaml:"sign_with,omitempty"`          // Ed25519 private key file archives are signed with
+	Redact            []RedactRule `yaml:"redact,omitempty"`             // rules masking or dropping lines of rotated files before compression
+	Upload            *Upload      `yaml:"upload,omitempty"`             // remote storage archives are shipped to
+	Destination       *string      `yaml:"destination,omitempty"`        // directory files are copied or moved to, keeping their path below the wildcards
+	Conflict          *string      `yaml:"conflict,omitempty"`           // overwrite, skip or rename files present at the destination, defaults to skip for copy and rename for move
+	KeepBytes         *string      `yaml:"keep_bytes,omitempty"`         // size of the tail truncate keeps, cut at a line start
+	KeepLines         *int         `yaml:"keep_lines,omitempty"`         // lines at the end truncate keeps
+	ChunkSize         *string      `yaml:"chunk_size,omitempty"`         // split rotated files into line-aligned chunks of this size
+	ArchiveName       *string      `yaml:"archive_name,omitempty"`       // name template of the bundle archive writes, see bundleName
+	ArchiveFormat     *string      `yaml:"archive_format,omitempty"`     // tar, tar.gz, tar.zst or zip, defaults to tar.gz, or tar for compact
+	Period            *string      `yaml:"period,omitempty"`             // day or month compact groups archives by
+	FreeSpace         *string      `yaml:"free_space,omitempty"`         // free space of the volume, as a size or a percentage, below which rotate and delete entries clean up
+	FreeSpaceTarget   *string      `yaml:"free_space_target,omitempty"`  // free space cleaning up goes on to, defaults to free_space
+}
+
+// Config is the part of the configuration file describing what to rotate and
+// when. Programs embedding the engine can inline it into their own config.
+type Config struct {
+	Logs     []LogEntry `yaml:"logs"`
+	Schedule string     `yaml:"schedule"`
+	Workers  int        `yaml:"workers,omitempty"`   // files processed in parallel across all entries, defaults to the number of CPUs
+	StateDir string     `yaml:"state_dir,omitempty"` // directory for the instance lock, the compression journal and the upload spool
+
+	FreeSpaceSchedule string `yaml:"free_space_schedule,omitempty"` // how often entries with free_space check it, defaults to every minute
+}
+
+func (entry *LogEntry) setDefaults() {
+	if entry.Type == "" && len(entry.Steps) > 0 {
+		entry.Type = "pipeline"
+	}
+	if entry.Type == "rotate" && entry.Condition == nil {
+		entry.Condition = &Condition{}
+
+		if entry.Condition.Compress == nil {
+			defaultCompress := true
+			entry.Condition.Compress = &defaultCompress
+		}
+
+		if entry.Condition.CompressionFormat == nil {
+			defaultFormat := "gzip"
+			entry.Condition.CompressionFormat = &defaultFormat
+		}
+	}
+}
+
+// ApplyDefaults names unnamed entries, fills in default conditions and
+// checks that entry names are unique, redaction rules compile and pipeline
+// steps and free space conditions are valid.
+func (config *Config) ApplyDefaults() error {
+	names := make(map[string]bool)
+	for i := range config.Logs {
+		if config.Logs[i].Name == "" {
+			config.Logs[i].Name = fmt.Sprintf("entry-%d", i+1)
+		}
+		if names[config.Logs[i].Name] {
+			return fmt.Errorf("duplicate log entry name: %s", config.Logs[i].Name)
+		}
+		names[config.Logs[i].Name] = true
+		config.Logs[i].setDefaults()
+
+		if condition := config.Logs[i].Condition; condition != nil {
+			if _, err := compileRules(condition.Redact); err != nil {
+				return fmt.Errorf("entry %s: %v", config.Logs[i].Name, err)
+			}
+		}
+		if err := config.Logs[i].validateSteps(); err != nil {
+			return fmt.Errorf("entry %s: %v", config.Logs[i].Name, err)
+		}
+		if err := config.Logs[i].validateFreeSpace(); err != nil {
+			return fmt.Errorf("entry %s: %v", config.Logs[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// ParseConfig parses a YAML configuration and applies its defaults.
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("failed to parse YAML file: %v", err)
+	}
+
+	if err := config.ApplyDefaults(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// LoadConfig reads and parses the YAML configuration file at filePath.
+func LoadConfig(filePath string) (Config, error) {
+	yamlFile, err := os.ReadFile(filepath.Clean(filePath))
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read YAML file: %v", err)
+	}
+	return ParseConfig(yamlFile)
+}
+
+func (p *Paths) UnmarshalYAML(value *yaml.Node) error {
+	var singlePath string
+	if err := value.Decode(&singlePath); err == nil {
+		*p = Paths{singlePath}
+		return nil
+	}
+
+	var multiplePaths []string
+	if err := value.Decode(&multiplePaths); err == nil {
+		*p = Paths(multiplePaths)
+		return nil
+	}
+
+	return fmt.Errorf("failed to unmarshal path, expected a string or a list of strings")
+}
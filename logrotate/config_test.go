@@ -0,0 +1,72 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	yamlContent := `
+logs:
+  - path: "/path/to/log/*.log"
+    type: delete
+  - name: app
+    path:
+      - "/path/to/log1/*.log"
+      - "/path/to/log2/*.log"
+    type: rotate
+schedule: "*/5 * * * *"
+`
+
+	tempFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(tempFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create temp config file: %v", err)
+	}
+
+	config, err := LoadConfig(tempFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(config.Logs) != 2 {
+		t.Fatalf("Expected 2 log entries, got %d", len(config.Logs))
+	}
+
+	if config.Logs[0].Name != "entry-1" || config.Logs[1].Name != "app" {
+		t.Errorf("Expected entry names entry-1 and app, got %s and %s", config.Logs[0].Name, config.Logs[1].Name)
+	}
+
+	if len(config.Logs[1].Path) != 2 {
+		t.Errorf("Expected 2 paths, got %d", len(config.Logs[1].Path))
+	}
+
+	condition := config.Logs[1].Condition
+	if condition == nil || condition.Compress == nil || !*condition.Compress || compressionFormat(condition) != "gzip" {
+		t.Errorf("Expected default rotate condition, got %+v", condition)
+	}
+
+	if config.Schedule != "*/5 * * * *" {
+		t.Errorf("Expected schedule '*/5 * * * *', got %s", config.Schedule)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{"duplicate names", "logs:\n  - name: app\n    type: delete\n  - name: app\n    type: delete\n"},
+		{"invalid path", "logs:\n  - path: {a: b}\n    type: delete\n"},
+		{"invalid yaml", "logs: [\n"},
+		{"free space without path", "logs:\n  - type: delete\n    condition:\n      free_space: 10%\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseConfig([]byte(tt.yaml)); err == nil {
+				t.Errorf("ParseConfig() expected error, got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultFreeSpaceSchedule is how often free space is checked without
+// Config.FreeSpaceSchedule.
+const defaultFreeSpaceSchedule = "@every 1m"
+
+// freeSpaceTask is the name runs relieving disk pressure are reported under.
+const freeSpaceTask = "free_space"
+
+// freeSpace is an amount of free space, in bytes or as a percentage of the
+// size of the volume.
+type freeSpace struct {
+	bytes   uint64
+	percent float64
+}
+
+// parseFreeSpace parses a free space such as "5GB" or "10%".
+func parseFreeSpace(s string) (freeSpace, error) {
+	if value, ok := strings.CutSuffix(strings.TrimSpace(s), "%"); ok {
+		percent, err := strconv.ParseFloat(value, 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return freeSpace{}, fmt.Errorf("invalid free space percentage: %s", s)
+		}
+		return freeSpace{percent: percent}, nil
+	}
+	size, err := ParseSize(s)
+	if err != nil || size <= 0 {
+		return freeSpace{}, fmt.Errorf("invalid free space: %s", s)
+	}
+	return freeSpace{bytes: uint64(size)}, nil
+}
+
+// of returns the free space in bytes on a volume of total bytes.
+func (f freeSpace) of(total uint64) uint64 {
+	if f.percent > 0 {
+		return uint64(float64(total) * f.percent / 100)
+	}
+	return f.bytes
+}
+
+// freeSpaceLimits returns the free space below which the disk of entry is
+// under pressure and the free space cleaning up goes on to.
+func (entry *LogEntry) freeSpaceLimits() (threshold, target freeSpace, err error) {
+	threshold, err = parseFreeSpace(*entry.Condition.FreeSpace)
+	if err != nil {
+		return freeSpace{}, freeSpace{}, err
+	}
+	target = threshold
+	if entry.Condition.FreeSpaceTarget != nil {
+		if target, err = parseFreeSpace(*entry.Condition.FreeSpaceTarget); err != nil {
+			return freeSpace{}, freeSpace{}, err
+		}
+	}
+	return threshold, target, nil
+}
+
+func (entry *LogEntry) watchesFreeSpace() bool {
+	return entry.Condition != nil && entry.Condition.FreeSpace != nil
+}
+
+// validateFreeSpace checks the free_space and free_space_target of entry,
+// which only rotate and delete entries with a path take, not their steps.
+func (entry *LogEntry) validateFreeSpace() error {
+	for i, step := range entry.Steps {
+		if step.FreeSpace != nil || step.FreeSpaceTarget != nil {
+			return fmt.Errorf("step %d: free_space is a condition of the entry", i+1)
+		}
+	}
+	if !entry.watchesFreeSpace() {
+		if entry.Condition != nil && entry.Condition.FreeSpaceTarget != nil {
+			return fmt.Errorf("free_space_target needs free_space")
+		}
+		return nil
+	}
+	if entry.Type != "rotate" && entry.Type != "delete" {
+		return fmt.Errorf("free_space needs type rotate or delete, not %s", entry.Type)
+	}
+	if len(entry.Path) == 0 {
+		return fmt.Errorf("free_space needs a path")
+	}
+	_, _, err := entry.freeSpaceLimits()
+	return err
+}
+
+// volumeInfo returns how the free space and volumes of fsys are found, or
+// functions failing with errors.ErrUnsupported unless it is a spaceFS.
+func volumeInfo(fsys FS) (func(string) (uint64, uint64, error), func(string) (string, error)) {
+	if space, ok := fsys.(spaceFS); ok {
+		return space.DiskSpace, space.VolumeOf
+	}
+	return func(string) (uint64, uint64, error) { return 0, 0, errors.ErrUnsupported },
+		func(string) (string, error) { return "", errors.ErrUnsupported }
+}
+
+// pressureEntry is an entry watching free space on a volume.
+type pressureEntry struct {
+	entry     LogEntry
+	root      string // glob root of its first path, on the volume
+	threshold freeSpace
+	target    freeSpace
+}
+
+// relieveDiskPressure checks the free space of the volumes holding the files
+// of entries with a free_space condition. On a volume with less free space
+// than the threshold of some of its entries, the rotate entries among those
+// rotate their files right away, whatever their size and age. If that leaves
+// less free space than the highest target of those entries, their files are
+// deleted oldest first until it is reached: the matched files of delete
+// entries, whatever their age, and the rotated files and archives of rotate
+// entries. Files of entries with a lower priority go before any of a higher
+// one. Paused entries are left alone.
+func (e *Engine) relieveDiskPressure(ctx context.Context) error {
+	e.mu.Lock()
+	entries := e.entries
+	e.mu.Unlock()
+
+	volumes := make(map[string][]pressureEntry)
+	var names []string
+	var errs []error
+	for _, logEntry := range entries {
+		if !logEntry.watchesFreeSpace() || e.isPaused(logEntry.Name) {
+			continue
+		}
+		threshold, target, err := logEntry.freeSpaceLimits()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", logEntry.Name, err))
+			continue
+		}
+		root := globRoot(logEntry.Path[0])
+		volume, err := e.volumeOf(root)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", logEntry.Name, err))
+			continue
+		}
+		if _, ok := volumes[volume]; !ok {
+			names = append(names, volume)
+		}
+		volumes[volume] = append(volumes[volume], pressureEntry{entry: logEntry, root: root, threshold: threshold, target: target})
+	}
+
+	for _, volume := range names {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		if err := e.relieveVolume(ctx, volume, volumes[volume]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// relieveVolume relieves the pressure on a single volume, see
+// relieveDiskPressure.
+func (e *Engine) relieveVolume(ctx context.Context, volume string, entries []pressureEntry) error {
+	root := entries[0].root
+	free, total, err := e.diskSpace(root)
+	if err != nil {
+		return err
+	}
+
+	var pressured []pressureEntry
+	var target uint64
+	for _, entry := range entries {
+		if free < entry.threshold.of(total) {
+			pressured = append(pressured, entry)
+			target = max(target, entry.target.of(total))
+		}
+	}
+	if len(pressured) == 0 {
+		return nil
+	}
+	logger := e.log().With("action", freeSpaceTask, "volume", volume)
+	logger.Warn("Free space below threshold", "free_bytes", free, "target_bytes", target)
+
+	var errs []error
+	for _, entry := range pressured {
+		if entry.entry.Type != "rotate" {
+			continue
+		}
+		forced := entry.entry.forcedRotation()
+		err := e.runTask(entry.entry.Name, func(ctx context.Context) error {
+			return e.runSteps(ctx, forced, freeSpaceTask, forced.pipeline())
+		})
+		if errors.Is(err, ErrEntryRunning) {
+			logger.Warn("Skipping rotation of entry still running", "entry", entry.entry.Name)
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.entry.Name, err))
+		}
+	}
+
+	if free, _, err = e.diskSpace(root); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if free >= target {
+		logger.Info("Free space reached target", "free_bytes", free)
+		return errors.Join(errs...)
+	}
+
+	free, err = e.deleteUntilFree(ctx, root, pressured, target)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if free < target {
+		logger.Warn("Free space still below target, nothing left to delete", "free_bytes", free, "target_bytes", target)
+	} else {
+		logger.Info("Free space reached target", "free_bytes", free)
+	}
+	return errors.Join(errs...)
+}
+
+// forcedRotation returns entry with its rotate conditions replaced by one
+// every non-empty file meets.
+func (entry *LogEntry) forcedRotation() LogEntry {
+	forced := *entry
+	condition := *entry.Condition
+	anySize := "1"
+	condition.Size = &anySize
+	condition.Age = nil
+	forced.Condition = &condition
+	return forced
+}
+
+// deleteCandidate is a file deleteUntilFree may delete.
+type deleteCandidate struct {
+	path     string
+	entry    LogEntry
+	modTime  time.Time
+	priority int
+}
+
+// deleteUntilFree deletes the files of entries by priority, oldest first,
+// along with their sidecars, until the volume holding root has target bytes
+// free. Files another task is processing are skipped, and so is the active
+// file of a directory matched by a delete entry, like by compress. It returns
+// the free space left.
+func (e *Engine) deleteUntilFree(ctx context.Context, root string, entries []pressureEntry, target uint64) (uint64, error) {
+	var candidates []deleteCandidate
+	runs := make(map[string]*taskRun)
+	for _, entry := range entries {
+		run := e.newTaskRun(entry.entry, freeSpaceTask)
+		runs[entry.entry.Name] = run
+
+		var files []string
+		var active map[string]bool
+		if entry.entry.Type == "rotate" {
+			for _, path := range entry.entry.Path {
+				matches, err := e.fs.Glob(filepath.Clean(path) + ".*")
+				if err != nil {
+					run.fail("glob", "Failed to expand wildcard", path, err)
+					continue
+				}
+				files = append(files, matches...)
+			}
+		} else {
+			files = e.matchFiles(run, entry.entry)
+			active = e.activeFiles(files)
+		}
+
+		for _, file := range files {
+			name := filepath.Base(file)
+			if isSidecar(name) || strings.HasSuffix(name, tempName("")) || active[file] {
+				continue
+			}
+			fileInfo, err := e.fs.Stat(file)
+			if err != nil || fileInfo.IsDir() {
+				continue
+			}
+			candidates = append(candidates, deleteCandidate{path: file, entry: entry.entry, modTime: fileInfo.ModTime(), priority: entry.entry.Priority})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b deleteCandidate) int {
+		if a.priority != b.priority {
+			return a.priority - b.priority
+		}
+		return a.modTime.Compare(b.modTime)
+	})
+
+	free, _, err := e.diskSpace(root)
+	for _, candidate := range candidates {
+		if err != nil || free >= target {
+			break
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		e.freeFile(runs[candidate.entry.Name], candidate.path)
+		free, _, err = e.diskSpace(root)
+	}
+
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, entry := range entries {
+		if runErr := runs[entry.entry.Name].err(); runErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.entry.Name, runErr))
+		}
+	}
+	return free, errors.Join(errs...)
+}
+
+// freeFile deletes file and its sidecars unless another task is processing
+// it.
+func (e *Engine) freeFile(run *taskRun, file string) {
+	if !e.lockFile(file) {
+		run.logger.Info("Skipping file processed by another task", "path", file)
+		return
+	}
+	defer e.unlockFile(file)
+
+	fileInfo, err := e.fs.Stat(file)
+	if err != nil {
+		// Removed since it was listed, e.g. by a prune step.
+		return
+	}
+	if err := e.fs.Remove(file); err != nil {
+		run.fail("delete", "Failed to delete file", file, err)
+		return
+	}
+	for _, suffix := range sidecarSuffixes {
+		if err := e.fs.Remove(file + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			run.fail("delete", "Failed to delete sidecar", file+suffix, err)
+		}
+	}
+	run.logger.Info("Deleted file to free space", "path", file, "bytes", fileInfo.Size())
+	run.done(FileEvent{Action: "deleted", Path: file, Size: fileInfo.Size()})
+}
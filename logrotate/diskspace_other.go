@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package logrotate
+
+import "errors"
+
+// diskSpace cannot tell on this platform, so free_space never fires.
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
+
+func volumeOf(path string) (string, error) {
+	return "", errors.ErrUnsupported
+}
@@ -0,0 +1,201 @@
+package logrotate
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// volumeFS is the OS filesystem on a volume of capacity bytes holding
+// nothing but the files below root.
+type volumeFS struct {
+	OSFS
+	root     string
+	capacity uint64
+}
+
+func (v volumeFS) DiskSpace(string) (free, total uint64, err error) {
+	var used uint64
+	err = filepath.WalkDir(v.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fileInfo, err := d.Info()
+		used += uint64(fileInfo.Size())
+		return err
+	})
+	return v.capacity - used, v.capacity, err
+}
+
+func (volumeFS) VolumeOf(string) (string, error) {
+	return "disk", nil
+}
+
+func TestParseFreeSpace(t *testing.T) {
+	tests := []struct {
+		input   string
+		total   uint64
+		want    uint64
+		wantErr bool
+	}{
+		{input: "5GB", total: 100 << 30, want: 5 << 30},
+		{input: "500", total: 1000, want: 500},
+		{input: "10%", total: 1000, want: 100},
+		{input: "2.5%", total: 1000, want: 25},
+		{input: "0%", wantErr: true},
+		{input: "100%", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "lots", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFreeSpace(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFreeSpace(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got.of(tt.total) != tt.want {
+			t.Errorf("parseFreeSpace(%q) of %d = %d, want %d", tt.input, tt.total, got.of(tt.total), tt.want)
+		}
+	}
+}
+
+func TestValidateFreeSpace(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   LogEntry
+		wantErr bool
+	}{
+		{name: "rotate", entry: LogEntry{Type: "rotate", Path: Paths{"app.log"}, Condition: &Condition{FreeSpace: stringPtr("10%"), FreeSpaceTarget: stringPtr("20%")}}},
+		{name: "delete", entry: LogEntry{Type: "delete", Path: Paths{"*.dmp"}, Condition: &Condition{FreeSpace: stringPtr("1GB")}}},
+		{name: "no path", entry: LogEntry{Type: "delete", Condition: &Condition{FreeSpace: stringPtr("1GB")}}, wantErr: true},
+		{name: "other type", entry: LogEntry{Type: "compress", Condition: &Condition{FreeSpace: stringPtr("1GB")}}, wantErr: true},
+		{name: "invalid target", entry: LogEntry{Type: "delete", Condition: &Condition{FreeSpace: stringPtr("1GB"), FreeSpaceTarget: stringPtr("x")}}, wantErr: true},
+		{name: "target alone", entry: LogEntry{Type: "delete", Condition: &Condition{FreeSpaceTarget: stringPtr("1GB")}}, wantErr: true},
+		{name: "step", entry: LogEntry{Type: "pipeline", Steps: []Step{{Action: actionDelete, Condition: Condition{FreeSpace: stringPtr("1GB")}}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.entry.validateFreeSpace(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateFreeSpace() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRelieveDiskPressure(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Now()
+	write := func(name string, age time.Duration) string {
+		path := filepath.Join(tempDir, name)
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		_ = os.WriteFile(path, []byte(strings.Repeat("x", 100)), 0644)
+		_ = os.Chtimes(path, now.Add(-age), now.Add(-age))
+		return path
+	}
+	oldestCache := write("cache/a.dat", 2*time.Hour)
+	newestCache := write("cache/b.dat", time.Hour)
+	oldestArchive := write("app/app.log.1.gz", 3*time.Hour)
+	write("app/app.log.2.gz", 2*time.Hour)
+	active := write("app/app.log", 0)
+
+	config := Config{Schedule: "@every 1h", Logs: []LogEntry{
+		{
+			Name:      "app",
+			Path:      Paths{filepath.Join(tempDir, "app", "*.log")},
+			Type:      "rotate",
+			Condition: &Condition{FreeSpace: stringPtr("60%"), FreeSpaceTarget: stringPtr("65%")},
+			Priority:  1,
+		},
+		{
+			Name:      "cache",
+			Path:      Paths{filepath.Join(tempDir, "cache", "*.dat")},
+			Type:      "delete",
+			Condition: &Condition{FreeSpace: stringPtr("60%")},
+		},
+	}}
+	if err := config.ApplyDefaults(); err != nil {
+		t.Fatalf("ApplyDefaults() error: %v", err)
+	}
+
+	var actions []string
+	engine := New(config, Options{Clock: &fakeClock{now: now}, FS: volumeFS{root: tempDir, capacity: 1000}, Hooks: Hooks{OnFile: func(event FileEvent) {
+		actions = append(actions, event.Entry+" "+event.Action)
+	}}})
+
+	if err := engine.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error: %v", err)
+	}
+
+	// Rotating and compressing the active file is not enough, the oldest
+	// file of the entry with the lowest priority goes next.
+	wantActions := []string{"app rotated", "app compressed", "cache deleted"}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Errorf("Expected actions %v, got %v", wantActions, actions)
+	}
+	if _, err := os.Stat(active); !os.IsNotExist(err) {
+		t.Errorf("Expected active file to be rotated, got %v", err)
+	}
+	if _, err := os.Stat(oldestCache); !os.IsNotExist(err) {
+		t.Errorf("Expected oldest cache file to be deleted, got %v", err)
+	}
+	for _, kept := range []string{newestCache, oldestArchive} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", kept, err)
+		}
+	}
+	if free, _, _ := engine.diskSpace(tempDir); free < 650 {
+		t.Errorf("Expected free space to reach the target, got %d", free)
+	}
+
+	// Without pressure, the delete entry without an age deletes nothing.
+	actions = nil
+	if err := engine.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error: %v", err)
+	}
+	if len(actions) != 0 {
+		t.Errorf("Expected nothing to happen without disk pressure, got %v", actions)
+	}
+}
+
+func TestRelieveDiskPressureKeepsActiveFile(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Now()
+	var files []string
+	for i, name := range []string{"a.log", "b.log", "c.log"} {
+		path := filepath.Join(tempDir, name)
+		_ = os.WriteFile(path, []byte(strings.Repeat("x", 300)), 0644)
+		age := time.Duration(3-i) * time.Hour
+		_ = os.Chtimes(path, now.Add(-age), now.Add(-age))
+		files = append(files, path)
+	}
+
+	config := Config{Schedule: "@every 1h", Logs: []LogEntry{{
+		Name:      "app",
+		Path:      Paths{filepath.Join(tempDir, "*.log")},
+		Type:      "delete",
+		Condition: &Condition{FreeSpace: stringPtr("50%"), FreeSpaceTarget: stringPtr("90%")},
+	}}}
+	if err := config.ApplyDefaults(); err != nil {
+		t.Fatalf("ApplyDefaults() error: %v", err)
+	}
+	engine := New(config, Options{Clock: &fakeClock{now: now}, FS: volumeFS{root: tempDir, capacity: 1000}})
+
+	if err := engine.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error: %v", err)
+	}
+
+	// Even with the target out of reach, the file still written to stays.
+	for _, deleted := range files[:2] {
+		if _, err := os.Stat(deleted); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted, got %v", deleted, err)
+		}
+	}
+	if _, err := os.Stat(files[2]); err != nil {
+		t.Errorf("Expected newest file to be kept, got %v", err)
+	}
+}
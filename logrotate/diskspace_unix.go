@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd
+
+package logrotate
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func diskSpace(path string) (free, total uint64, err error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, 0, fmt.Errorf("failed to get free space of %s: %v", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize), nil
+}
+
+// volumeOf identifies the filesystem holding path by its device number.
+func volumeOf(path string) (string, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return "", fmt.Errorf("failed to get volume of %s: %v", path, err)
+	}
+	return fmt.Sprint(stat.Dev), nil
+}
@@ -0,0 +1,30 @@
+package logrotate
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+func diskSpace(path string) (free, total uint64, err error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, &total, nil); err != nil {
+		return 0, 0, fmt.Errorf("failed to get free space of %s: %v", path, err)
+	}
+	return free, total, nil
+}
+
+func volumeOf(path string) (string, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	volume := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(name, &volume[0], uint32(len(volume))); err != nil {
+		return "", fmt.Errorf("failed to get volume of %s: %v", path, err)
+	}
+	return windows.UTF16ToString(volume), nil
+}
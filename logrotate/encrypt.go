@@ -0,0 +1,492 @@
+package logrotate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Archives are encrypted to X25519 public keys in a format modelled on age: a
+// random file key is wrapped for every recipient with a key derived from an
+// ephemeral X25519 exchange, and the payload is sealed with AES-256-GCM in
+// 64 KiB chunks whose nonces count the chunks and mark the last one, so
+// truncated or reordered archives fail to decrypt.
+//
+//	wingologrotate-encrypted/v1
+//	-> X25519 <ephemeral public key> <wrapped file key>
+//	--- <payload salt>
+//	<payload chunks>
+//
+// Keys are PKIX (public) and PKCS #8 (private) PEM, as written by GenerateKey
+// or `openssl genpkey -algorithm X25519`. Only public keys are needed on the
+// host that encrypts.
+
+const (
+	encryptedSuffix  = ".enc"
+	encryptionHeader = "wingologrotate-encrypted/v1"
+	recipientPrefix  = "-> X25519 "
+	payloadPrefix    = "--- "
+	fileKeySize      = 32
+	payloadSaltSize  = 16
+	chunkSize        = 64 * 1024
+
+	// maxHeaderLine and maxHeaderSize bound the encryption header read
+	// before an archive is rejected, far above what a header for hundreds
+	// of recipients takes.
+	maxHeaderLine = 1024
+	maxHeaderSize = 64 * 1024
+)
+
+// ErrNoIdentity is returned by Decrypt when the archive was not encrypted to
+// the given private key.
+var ErrNoIdentity = errors.New("archive is not encrypted to this key")
+
+// GenerateKey returns a new X25519 key pair as PEM. The private key should be
+// generated, and kept, away from the hosts that rotate logs.
+func GenerateKey() (privatePEM, publicPEM []byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.PublicKey())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	return privatePEM, publicPEM, nil
+}
+
+// ParseIdentity parses a PEM encoded X25519 private key.
+func ParseIdentity(data []byte) (*ecdh.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	identity, ok := key.(*ecdh.PrivateKey)
+	if !ok || identity.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("private key is not an X25519 key")
+	}
+	return identity, nil
+}
+
+// parseRecipient parses a PEM encoded X25519 public key, given inline or as
+// the path of a PEM file on fsys.
+func parseRecipient(fsys FS, recipient string) (*ecdh.PublicKey, error) {
+	data := []byte(recipient)
+	if !strings.HasPrefix(strings.TrimSpace(recipient), "-----BEGIN") {
+		var err error
+		if data, err = readFile(fsys, recipient); err != nil {
+			return nil, fmt.Errorf("failed to read public key: %v", err)
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in public key %s", recipient)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	publicKey, ok := key.(*ecdh.PublicKey)
+	if !ok || publicKey.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("public key is not an X25519 key")
+	}
+	return publicKey, nil
+}
+
+func parseRecipients(fsys FS, recipients []string) ([]*ecdh.PublicKey, error) {
+	keys := make([]*ecdh.PublicKey, 0, len(recipients))
+	for _, recipient := range recipients {
+		key, err := parseRecipient(fsys, recipient)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Encrypt encrypts src to every recipient and writes the result to dst.
+func Encrypt(dst io.Writer, src io.Reader, recipients []*ecdh.PublicKey) error {
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients to encrypt to")
+	}
+
+	fileKey := make([]byte, fileKeySize)
+	salt := make([]byte, payloadSaltSize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return fmt.Errorf("failed to generate file key: %v", err)
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate payload salt: %v", err)
+	}
+
+	header := new(bytes.Buffer)
+	header.WriteString(encryptionHeader + "\n")
+	for _, recipient := range recipients {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate ephemeral key: %v", err)
+		}
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return fmt.Errorf("failed to exchange keys: %v", err)
+		}
+		wrapped, err := sealOnce(wrapKey(shared, ephemeral.PublicKey(), recipient), fileKey)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(header, "%s%s %s\n", recipientPrefix, encode(ephemeral.PublicKey().Bytes()), encode(wrapped))
+	}
+	fmt.Fprintf(header, "%s%s\n", payloadPrefix, encode(salt))
+
+	if _, err := dst.Write(header.Bytes()); err != nil {
+		return fmt.Errorf("failed to write encryption header: %v", err)
+	}
+
+	aead, err := newAEAD(hkdf(fileKey, salt, "wingologrotate/payload"))
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(src, chunkSize)
+	chunk := make([]byte, chunkSize)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read plaintext: %v", err)
+		}
+		last := n < chunkSize
+		if !last {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+
+		sealed := aead.Seal(nil, chunkNonce(counter, last), chunk[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			return fmt.Errorf("failed to write ciphertext: %v", err)
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Decrypt decrypts an archive written by Encrypt with the private key of one
+// of its recipients and writes the plaintext to dst.
+func Decrypt(dst io.Writer, src io.Reader, identity *ecdh.PrivateKey) error {
+	reader := bufio.NewReaderSize(src, chunkSize)
+	headerSize := 0
+
+	line, err := readHeaderLine(reader, &headerSize)
+	if err != nil || line != encryptionHeader {
+		return fmt.Errorf("not a wingologrotate encrypted archive")
+	}
+
+	var fileKey, salt []byte
+	for salt == nil {
+		line, err := readHeaderLine(reader, &headerSize)
+		if err != nil {
+			return fmt.Errorf("failed to read encryption header: %v", err)
+		}
+
+		switch {
+		case strings.HasPrefix(line, recipientPrefix):
+			if fileKey != nil {
+				continue
+			}
+			fields := strings.Fields(strings.TrimPrefix(line, recipientPrefix))
+			if len(fields) != 2 {
+				return fmt.Errorf("invalid recipient in encryption header")
+			}
+			fileKey, err = unwrapFileKey(identity, fields[0], fields[1])
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, payloadPrefix):
+			salt, err = decode(strings.TrimPrefix(line, payloadPrefix))
+			if err != nil || len(salt) != payloadSaltSize {
+				return fmt.Errorf("invalid payload salt in encryption header")
+			}
+		default:
+			return fmt.Errorf("invalid line in encryption header")
+		}
+	}
+	if fileKey == nil {
+		return ErrNoIdentity
+	}
+
+	aead, err := newAEAD(hkdf(fileKey, salt, "wingologrotate/payload"))
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, chunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read ciphertext: %v", err)
+		}
+		last := n < len(chunk)
+		if !last {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+
+		plaintext, err := aead.Open(nil, chunkNonce(counter, last), chunk[:n], nil)
+		if err != nil {
+			return fmt.Errorf("archive is truncated or corrupt")
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return fmt.Errorf("failed to write plaintext: %v", err)
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// readHeaderLine reads a line of the encryption header without its newline,
+// adding its length to headerSize. Lines longer than maxHeaderLine and
+// headers longer than maxHeaderSize are rejected before being read any
+// further, so a corrupt archive is not read into memory.
+func readHeaderLine(reader *bufio.Reader, headerSize *int) (string, error) {
+	line, err := reader.ReadSlice('\n')
+	*headerSize += len(line)
+	switch {
+	case len(line) > maxHeaderLine || errors.Is(err, bufio.ErrBufferFull):
+		return "", fmt.Errorf("invalid encryption header: line longer than %d bytes", maxHeaderLine)
+	case *headerSize > maxHeaderSize:
+		return "", fmt.Errorf("invalid encryption header: longer than %d bytes", maxHeaderSize)
+	case err != nil:
+		return "", err
+	}
+	return strings.TrimSuffix(string(line), "\n"), nil
+}
+
+// DecryptFile decrypts the archive at path next to it, dropping the .enc
+// extension, and returns the name of the decrypted file. The encrypted
+// archive is kept.
+func DecryptFile(path string, identity *ecdh.PrivateKey) (string, error) {
+	if !strings.HasSuffix(path, encryptedSuffix) {
+		return "", fmt.Errorf("%s does not have the %s extension", path, encryptedSuffix)
+	}
+	outputPath := strings.TrimSuffix(path, encryptedSuffix)
+
+	input, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer input.Close()
+
+	output, err := os.OpenFile(tempName(outputPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create decrypted file: %v", err)
+	}
+
+	err = Decrypt(output, input, identity)
+	if closeErr := output.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close decrypted file: %v", closeErr)
+	}
+	if err == nil {
+		err = os.Rename(tempName(outputPath), outputPath)
+	}
+	if err != nil {
+		os.Remove(tempName(outputPath))
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// encryptFile encrypts inputPath to recipients into a synced outputPath,
+// writing the ciphertext to digest as well. On error the caller removes
+// outputPath.
+func encryptFile(fsys FS, inputPath, outputPath string, recipients []string, digest io.Writer) error {
+	keys, err := parseRecipients(fsys, recipients)
+	if err != nil {
+		return err
+	}
+
+	input, err := fsys.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive for encryption: %v", err)
+	}
+	defer input.Close()
+
+	output, err := fsys.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create encrypted file: %v", err)
+	}
+
+	err = Encrypt(io.MultiWriter(output, digest), input, keys)
+	if err == nil {
+		if syncErr := output.Sync(); syncErr != nil {
+			err = fmt.Errorf("failed to sync encrypted file: %v", syncErr)
+		}
+	}
+	if closeErr := output.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close encrypted file: %v", closeErr)
+	}
+	return err
+}
+
+func unwrapFileKey(identity *ecdh.PrivateKey, ephemeralKey, wrappedKey string) ([]byte, error) {
+	ephemeralBytes, err := decode(ephemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key in encryption header")
+	}
+	wrapped, err := decode(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key in encryption header")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key in encryption header")
+	}
+
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange keys: %v", err)
+	}
+	aead, err := newAEAD(wrapKey(shared, ephemeral, identity.PublicKey()))
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+	if err != nil {
+		// Wrapped for another recipient.
+		return nil, nil
+	}
+	return fileKey, nil
+}
+
+// wrapKey derives the key wrapping the file key for recipient from the
+// shared secret of an X25519 exchange with the ephemeral key.
+func wrapKey(shared []byte, ephemeral, recipient *ecdh.PublicKey) []byte {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	return hkdf(shared, salt, "wingologrotate/x25519")
+}
+
+// sealOnce encrypts plaintext with a key used for nothing else, so a zero
+// nonce is safe.
+func sealOnce(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return aead, nil
+}
+
+// chunkNonce is the big endian chunk counter followed by a byte set on the
+// last chunk.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// hkdf is HKDF-SHA256 (RFC 5869) producing a single 32 byte key.
+func hkdf(secret, salt []byte, info string) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(info))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func encode(data []byte) string {
+	return base64.RawStdEncoding.EncodeToString(data)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// encryptStep encrypts the file to the encrypt_to keys of step, replacing it
+// and its sidecars, which describe the plaintext, with the encrypted file and
+// the sidecars step asks for.
+func (e *Engine) encryptStep(run *taskRun, step *Step, file *pipelineFile) error {
+	fileInfo, err := e.fs.Stat(file.path)
+	if err != nil {
+		return stepFailed("stat", "Failed to get file info", file.path, err)
+	}
+
+	encryptedPath := file.path + encryptedSuffix
+	tempPath := tempName(encryptedPath)
+	sum := newDigest()
+	err = encryptFile(e.fs, file.path, tempPath, step.EncryptTo, sum)
+	if err == nil {
+		err = e.fs.Rename(tempPath, encryptedPath)
+	}
+	if err != nil {
+		e.fs.Remove(tempPath)
+		return stepFailed("encrypt", "Failed to encrypt file", file.path, err)
+	}
+
+	for _, path := range []string{file.path + checksumSuffix, file.path + signatureSuffix, file.path} {
+		if err := e.fs.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stepFailed("encrypt", "Failed to remove file after encryption", path, err)
+		}
+	}
+	plainPath := file.path
+	file.path = encryptedPath
+
+	if step.Checksum != nil && *step.Checksum {
+		if err := writeChecksum(e.fs, encryptedPath, sum.Sum()); err != nil {
+			return stepFailed("encrypt", "Failed to write checksum of encrypted file", encryptedPath, err)
+		}
+	}
+	if step.SignWith != nil {
+		if err := writeSignature(e.fs, encryptedPath, sum.Sum(), *step.SignWith); err != nil {
+			return stepFailed("encrypt", "Failed to sign encrypted file", encryptedPath, err)
+		}
+	}
+
+	run.logger.Info("Encrypted file", "path", plainPath, "encrypted", encryptedPath, "recipients", len(step.EncryptTo), "bytes", sum.size)
+	run.done(FileEvent{Action: "encrypted", Path: plainPath, NewPath: encryptedPath, Size: fileInfo.Size(), NewSize: sum.size})
+	return nil
+}
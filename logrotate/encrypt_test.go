@@ -0,0 +1,184 @@
+package logrotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func generateTestKey(t *testing.T) (*ecdh.PrivateKey, string) {
+	t.Helper()
+
+	privatePEM, publicPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+	identity, err := ParseIdentity(privatePEM)
+	if err != nil {
+		t.Fatalf("ParseIdentity() error: %v", err)
+	}
+	return identity, string(publicPEM)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	alice, alicePEM := generateTestKey(t)
+	bob, bobPEM := generateTestKey(t)
+	other, _ := generateTestKey(t)
+
+	// Bob's key is given as a file, Alice's inline.
+	bobPath := filepath.Join(t.TempDir(), "bob.pub")
+	_ = os.WriteFile(bobPath, []byte(bobPEM), 0644)
+	recipients, err := parseRecipients(OSFS{}, []string{alicePEM, bobPath})
+	if err != nil {
+		t.Fatalf("parseRecipients() error: %v", err)
+	}
+
+	for _, size := range []int{0, 100, chunkSize, 3*chunkSize + 17} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		ciphertext := new(bytes.Buffer)
+		if err := Encrypt(ciphertext, bytes.NewReader(plaintext), recipients); err != nil {
+			t.Fatalf("Encrypt() error: %v", err)
+		}
+		if size > 0 && bytes.Contains(ciphertext.Bytes(), plaintext) {
+			t.Fatalf("Expected ciphertext not to contain the plaintext")
+		}
+
+		for _, identity := range []*ecdh.PrivateKey{alice, bob} {
+			decrypted := new(bytes.Buffer)
+			if err := Decrypt(decrypted, bytes.NewReader(ciphertext.Bytes()), identity); err != nil {
+				t.Fatalf("Decrypt() of %d bytes error: %v", size, err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plaintext) {
+				t.Errorf("Decrypted %d bytes differ from plaintext", size)
+			}
+		}
+
+		if err := Decrypt(io.Discard, bytes.NewReader(ciphertext.Bytes()), other); !errors.Is(err, ErrNoIdentity) {
+			t.Errorf("Expected ErrNoIdentity for another key, got %v", err)
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	identity, publicPEM := generateTestKey(t)
+	recipients, _ := parseRecipients(OSFS{}, []string{publicPEM})
+
+	ciphertext := new(bytes.Buffer)
+	if err := Encrypt(ciphertext, bytes.NewReader(make([]byte, 2*chunkSize+10)), recipients); err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	data := ciphertext.Bytes()
+	headerSize := bytes.Index(data, []byte("\n"+payloadPrefix))
+	headerSize += bytes.IndexByte(data[headerSize+1:], '\n') + 2
+
+	truncated := data[:headerSize+chunkSize+16]
+	if err := Decrypt(io.Discard, bytes.NewReader(truncated), identity); err == nil {
+		t.Errorf("Expected error for archive truncated at a chunk boundary")
+	}
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)-1] ^= 1
+	if err := Decrypt(io.Discard, bytes.NewReader(flipped), identity); err == nil {
+		t.Errorf("Expected error for modified archive")
+	}
+}
+
+func TestDecryptHeaderTooLong(t *testing.T) {
+	identity, _ := generateTestKey(t)
+	_, otherPEM := generateTestKey(t)
+	other, _ := parseRecipients(OSFS{}, []string{otherPEM})
+	ciphertext := new(bytes.Buffer)
+	if err := Encrypt(ciphertext, strings.NewReader("log line\n"), other); err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	// A recipient line of another key, skipped while looking for ours.
+	recipientLine := strings.SplitAfter(ciphertext.String(), "\n")[1]
+
+	tests := map[string]io.Reader{
+		"line without newline": io.MultiReader(strings.NewReader(encryptionHeader+"\n"+recipientPrefix), neverEnding('A')),
+		"endless recipients":   strings.NewReader(encryptionHeader + "\n" + strings.Repeat(recipientLine, maxHeaderSize/len(recipientLine)+1)),
+	}
+	for name, src := range tests {
+		err := Decrypt(io.Discard, src, identity)
+		if err == nil || !strings.Contains(err.Error(), "invalid encryption header") {
+			t.Errorf("%s: expected header format error, got %v", name, err)
+		}
+	}
+}
+
+// neverEnding is an endless stream of one byte.
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestCompressFileEncrypted(t *testing.T) {
+	identity, publicPEM := generateTestKey(t)
+
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log.1")
+	content := strings.Repeat("customer data\n", 1000)
+	_ = os.WriteFile(file, []byte(content), 0644)
+
+	options := compressOptions{Verify: true, Checksum: true, EncryptTo: []string{publicPEM}}
+	result, err := compressFile(context.Background(), OSFS{}, file, "gzip", options)
+	if err != nil {
+		t.Fatalf("compressFile() error: %v", err)
+	}
+	archive := result.Path
+	if archive != file+".gz.enc" {
+		t.Errorf("Expected archive %s, got %s", file+".gz.enc", archive)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(tempDir, "*.tmp"))
+	if _, err := os.Stat(file + ".gz"); !os.IsNotExist(err) || len(leftovers) > 0 {
+		t.Errorf("Expected only the encrypted archive to be kept, found %v", leftovers)
+	}
+	if err := verifyChecksum(context.Background(), OSFS{}, archive); err != nil {
+		t.Errorf("Expected sidecar to match the encrypted archive: %v", err)
+	}
+
+	decrypted, err := DecryptFile(archive, identity)
+	if err != nil {
+		t.Fatalf("DecryptFile() error: %v", err)
+	}
+	gzipFile, _ := os.Open(decrypted)
+	defer gzipFile.Close()
+	gzipReader, err := gzip.NewReader(gzipFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted archive: %v", err)
+	}
+	if got, _ := io.ReadAll(gzipReader); string(got) != content {
+		t.Errorf("Expected decrypted archive to hold the original content")
+	}
+}
+
+func TestCompressFileInvalidRecipient(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log.1")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+
+	options := compressOptions{EncryptTo: []string{filepath.Join(t.TempDir(), "missing.pub")}}
+	if _, err := compressFile(context.Background(), OSFS{}, file, "gzip", options); err == nil {
+		t.Fatalf("Expected error for missing public key")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("Expected original to be kept when encryption fails: %v", err)
+	}
+	leftovers, _ := filepath.Glob(file + ".*")
+	if len(leftovers) > 0 {
+		t.Errorf("Expected no partial archives, found %v", leftovers)
+	}
+}
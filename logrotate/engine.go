@@ -0,0 +1,600 @@
+// Package logrotate implements the log rotation engine of wingologrotate: the
+// configuration types, the delete and rotate tasks and a cron based Engine
+// running them, so other programs can embed it.
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const defaultUnhealthyAfter = 3
+
+// ErrUnknownEntry is returned for operations on an entry name that is not in
+// the configuration.
+var ErrUnknownEntry = errors.New("unknown entry")
+
+// ErrEntryRunning is returned by Trigger when the entry is still running.
+// Scheduled runs of such an entry are skipped.
+var ErrEntryRunning = errors.New("entry is already running")
+
+// ErrEngineStopped is returned by Trigger and RunOnce once the engine is
+// stopping, as their runs would be cancelled right away.
+var ErrEngineStopped = errors.New("engine stopped")
+
+// Options customise an Engine, and the Writer and Lock. The zero value uses
+// the system clock, the OS filesystem, slog.Default() and no hooks.
+type Options struct {
+	Clock          Clock
+	FS             FS
+	Hooks          Hooks
+	Logger         *slog.Logger
+	UnhealthyAfter int // consecutive failed runs of an entry before Status reports unhealthy
+}
+
+// clock returns the Clock of options, the system clock unless one was given.
+func (options Options) clock() Clock {
+	if options.Clock == nil {
+		return systemClock{}
+	}
+	return options.Clock
+}
+
+// filesystem returns the FS of options, OSFS unless one was given.
+func (options Options) filesystem() FS {
+	if options.FS == nil {
+		return OSFS{}
+	}
+	return options.FS
+}
+
+// entryResult is the outcome of the most recent runs of a log entry.
+type entryResult struct {
+	lastRun             time.Time
+	lastDuration        time.Duration
+	lastErr             error
+	consecutiveFailures int
+}
+
+// Engine runs the configured log entries on their cron schedule and keeps
+// track of how each of them fared.
+//
+// Its lifecycle is Start, Pause, Resume and Stop. Pausing the engine stops
+// the cron schedule from firing, while pausing a single entry skips its runs.
+// Tasks run with a context that is cancelled when Stop gives up waiting for
+// them.
+type Engine struct {
+	clock          Clock
+	fs             FS
+	hooks          Hooks
+	logger         *slog.Logger
+	unhealthyAfter int
+	journal        *journal
+	spool          *spool
+	retryBackoff   time.Duration // before retrying a failed upload request
+	diskSpace      func(path string) (free, total uint64, err error)
+	volumeOf       func(path string) (string, error)
+
+	mu            sync.Mutex
+	cron          *cron.Cron
+	running       bool
+	stopping      bool // from Stop until the next Start
+	paused        bool
+	entries       []LogEntry
+	tasks         map[string]func(context.Context) error
+	cronIDs       map[string]cron.EntryID
+	results       map[string]*entryResult
+	pausedEntries map[string]bool
+	newTask       func(LogEntry) func(context.Context) error
+
+	runningEntries map[string]bool
+	workers        chan struct{}       // one slot per file being processed
+	lockedFiles    map[string]struct{} // files being processed, by fileKey
+
+	parent   context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inFlight int
+	idle     chan struct{} // closed when no task is running
+}
+
+// New creates an engine for config. It does not run anything before Start or
+// Trigger is called.
+func New(config Config, options Options) *Engine {
+	e := &Engine{
+		clock:          options.clock(),
+		fs:             options.filesystem(),
+		hooks:          options.Hooks,
+		logger:         options.Logger,
+		unhealthyAfter: options.UnhealthyAfter,
+		results:        make(map[string]*entryResult),
+		pausedEntries:  make(map[string]bool),
+		runningEntries: make(map[string]bool),
+		lockedFiles:    make(map[string]struct{}),
+		parent:         context.Background(),
+		ctx:            context.Background(),
+		cancel:         func() {},
+		idle:           make(chan struct{}),
+		retryBackoff:   time.Second,
+	}
+	close(e.idle)
+
+	e.diskSpace, e.volumeOf = volumeInfo(e.fs)
+	if e.unhealthyAfter <= 0 {
+		e.unhealthyAfter = defaultUnhealthyAfter
+	}
+	e.journal = newJournal(e.fs, config.StateDir)
+	e.spool = newSpool(e.fs, config.StateDir)
+	e.newTask = e.task
+
+	e.load(config)
+	return e
+}
+
+func (e *Engine) log() *slog.Logger {
+	if e.logger != nil {
+		return e.logger
+	}
+	return slog.Default()
+}
+
+// load replaces the scheduled entries with those of config. Results and pause
+// state are kept for entries whose name did not change. It must be called
+// with the cron scheduler stopped.
+func (e *Engine) load(config Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.cron = cron.New()
+	e.workers = make(chan struct{}, workerCount(config))
+	e.entries = nil
+	e.tasks = make(map[string]func(context.Context) error)
+	e.cronIDs = make(map[string]cron.EntryID)
+
+	results := make(map[string]*entryResult)
+	pausedEntries := make(map[string]bool)
+
+	for _, logEntry := range config.Logs {
+		schedule := config.Schedule
+		if err := e.schedule(schedule, logEntry); err != nil {
+			e.log().Error("Failed to schedule task", "entry", logEntry.Name, "path", logEntry.Path, "error", err)
+			continue
+		}
+
+		results[logEntry.Name] = &entryResult{}
+		if result, ok := e.results[logEntry.Name]; ok {
+			results[logEntry.Name] = result
+		}
+		pausedEntries[logEntry.Name] = e.pausedEntries[logEntry.Name]
+
+		if e.hooks.OnScheduled != nil {
+			e.hooks.OnScheduled(logEntry.Name)
+		}
+		e.log().Info("Scheduled task", "entry", logEntry.Name, "path", logEntry.Path, "schedule", schedule)
+	}
+	if e.watchesFreeSpace() {
+		schedule := config.FreeSpaceSchedule
+		if schedule == "" {
+			schedule = defaultFreeSpaceSchedule
+		}
+		if _, err := e.cron.AddFunc(schedule, e.checkFreeSpace); err != nil {
+			e.log().Error("Failed to schedule free space check", "schedule", schedule, "error", err)
+		} else {
+			e.log().Info("Scheduled free space check", "schedule", schedule)
+		}
+	}
+
+	e.results = results
+	e.pausedEntries = pausedEntries
+}
+
+// schedule must be called with e.mu held.
+func (e *Engine) schedule(schedule string, logEntry LogEntry) error {
+	task := e.newTask(logEntry)
+	id, err := e.cron.AddFunc(schedule, func() {
+		if e.isPaused(logEntry.Name) {
+			e.log().Info("Skipping paused entry", "entry", logEntry.Name)
+			return
+		}
+		if err := e.runTask(logEntry.Name, task); errors.Is(err, ErrEntryRunning) {
+			e.log().Warn("Skipping run of entry still running", "entry", logEntry.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	e.entries = append(e.entries, logEntry)
+	e.tasks[logEntry.Name] = task
+	e.cronIDs[logEntry.Name] = id
+	return nil
+}
+
+// watchesFreeSpace reports whether a scheduled entry has a free_space
+// condition. It must be called with e.mu held.
+func (e *Engine) watchesFreeSpace() bool {
+	for _, logEntry := range e.entries {
+		if logEntry.watchesFreeSpace() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFreeSpace relieves disk pressure unless the previous check is still
+// going on.
+func (e *Engine) checkFreeSpace() {
+	if err := e.runTask(freeSpaceTask, e.relieveDiskPressure); errors.Is(err, ErrEntryRunning) {
+		e.log().Warn("Skipping free space check still running")
+	}
+}
+
+// runTask runs task for the entry name unless a run of it is still going on,
+// in which case it returns ErrEntryRunning.
+func (e *Engine) runTask(name string, task func(context.Context) error) error {
+	return e.run(name, task, false)
+}
+
+// triggerTask is runTask for runs asked for outside of the schedule. Unlike
+// jobs the schedule fired before Stop, they are refused with
+// ErrEngineStopped once the engine is stopping.
+func (e *Engine) triggerTask(name string, task func(context.Context) error) error {
+	return e.run(name, task, true)
+}
+
+func (e *Engine) run(name string, task func(context.Context) error, triggered bool) error {
+	e.mu.Lock()
+	if triggered && e.stopping {
+		e.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrEngineStopped, name)
+	}
+	if e.runningEntries[name] {
+		e.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrEntryRunning, name)
+	}
+	e.runningEntries[name] = true
+	ctx := e.ctx
+	if e.inFlight == 0 {
+		e.idle = make(chan struct{})
+	}
+	e.inFlight++
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		delete(e.runningEntries, name)
+		e.inFlight--
+		if e.inFlight == 0 {
+			close(e.idle)
+		}
+		e.mu.Unlock()
+	}()
+
+	start := e.clock.Now()
+	err := task(ctx)
+	duration := e.clock.Now().Sub(start)
+	e.recordResult(name, start, duration, err)
+
+	if e.hooks.OnTaskDone != nil {
+		e.hooks.OnTaskDone(TaskEvent{Entry: name, Start: start, Duration: duration, Err: err})
+	}
+	return err
+}
+
+func workerCount(config Config) int {
+	if config.Workers > 0 {
+		return config.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// fileKey identifies a file for locking, whatever path or glob it was
+// matched through.
+func fileKey(file string) string {
+	key, err := filepath.Abs(file)
+	if err != nil {
+		key = filepath.Clean(file)
+	}
+	if runtime.GOOS == "windows" {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// lockFile reserves file for the calling task. It returns false if another
+// task, usually of an entry with an overlapping glob, is processing it.
+func (e *Engine) lockFile(file string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := fileKey(file)
+	if _, locked := e.lockedFiles[key]; locked {
+		return false
+	}
+	e.lockedFiles[key] = struct{}{}
+	return true
+}
+
+func (e *Engine) unlockFile(file string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.lockedFiles, fileKey(file))
+}
+
+func (e *Engine) recordResult(name string, start time.Time, duration time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	result, ok := e.results[name]
+	if !ok {
+		result = &entryResult{}
+		e.results[name] = result
+	}
+	result.lastRun = start
+	result.lastDuration = duration
+	result.lastErr = err
+	if err != nil {
+		result.consecutiveFailures++
+	} else {
+		result.consecutiveFailures = 0
+	}
+}
+
+func (e *Engine) isPaused(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.paused || e.pausedEntries[name]
+}
+
+// Trigger runs an entry right away, regardless of whether it is paused, and
+// returns the result of the run. Once the engine is stopping it returns
+// ErrEngineStopped instead.
+func (e *Engine) Trigger(name string) error {
+	e.mu.Lock()
+	task, ok := e.tasks[name]
+	e.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownEntry, name)
+	}
+
+	e.log().Info("Triggered entry", "entry", name)
+	return e.triggerTask(name, task)
+}
+
+// RunOnce runs every entry once, one after the other, then relieves disk
+// pressure if an entry has a free_space condition, and returns the errors of
+// all runs. It is meant for engines that are not started, and returns
+// ErrEngineStopped once Stop was called.
+func (e *Engine) RunOnce(ctx context.Context) error {
+	e.mu.Lock()
+	if e.stopping {
+		e.mu.Unlock()
+		return ErrEngineStopped
+	}
+	e.ctx, e.cancel = context.WithCancel(ctx)
+	cancel := e.cancel
+	entries := e.entries
+	watchesFreeSpace := e.watchesFreeSpace()
+	e.mu.Unlock()
+	defer cancel()
+
+	var errs []error
+	for _, logEntry := range entries {
+		e.mu.Lock()
+		task := e.tasks[logEntry.Name]
+		e.mu.Unlock()
+
+		if err := e.triggerTask(logEntry.Name, task); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", logEntry.Name, err))
+		}
+	}
+	if watchesFreeSpace {
+		if err := e.triggerTask(freeSpaceTask, e.relieveDiskPressure); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", freeSpaceTask, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PauseEntry skips scheduled runs of a single entry until ResumeEntry.
+func (e *Engine) PauseEntry(name string) error {
+	return e.setEntryPaused(name, true)
+}
+
+// ResumeEntry lets scheduled runs of a paused entry happen again.
+func (e *Engine) ResumeEntry(name string) error {
+	return e.setEntryPaused(name, false)
+}
+
+func (e *Engine) setEntryPaused(name string, paused bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.tasks[name]; !ok {
+		return fmt.Errorf("%w %s", ErrUnknownEntry, name)
+	}
+	e.pausedEntries[name] = paused
+	e.log().Info("Changed entry state", "entry", name, "paused", paused)
+	return nil
+}
+
+// Pause stops the cron schedule. Tasks that are already running are left to
+// finish.
+func (e *Engine) Pause() {
+	e.setPaused(true)
+}
+
+// Resume starts the cron schedule again after Pause.
+func (e *Engine) Resume() {
+	e.setPaused(false)
+}
+
+func (e *Engine) setPaused(paused bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.running && paused && !e.paused {
+		e.cron.Stop()
+	}
+	if e.running && !paused && e.paused {
+		e.cron.Start()
+	}
+	e.paused = paused
+	e.log().Info("Changed scheduler state", "paused", paused)
+}
+
+// Reload stops the engine, waiting for running tasks until ctx is done and
+// cancelling them then, and starts it again with the entries of config.
+func (e *Engine) Reload(ctx context.Context, config Config) {
+	e.mu.Lock()
+	running := e.running
+	parent := e.parent
+	e.mu.Unlock()
+
+	if running {
+		e.stop(ctx)
+	}
+	e.load(config)
+	if running {
+		e.Start(parent)
+	}
+	e.log().Info("Reloaded configuration", "entries", len(config.Logs))
+}
+
+// Start starts the cron schedule unless the engine is paused. Tasks run with
+// a context derived from ctx.
+func (e *Engine) Start(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.parent = ctx
+	e.ctx, e.cancel = context.WithCancel(ctx)
+	e.stopping = false
+	if !e.paused {
+		e.cron.Start()
+	}
+	e.running = true
+}
+
+// Stop stops the cron schedule and waits for running tasks, including those
+// the schedule fired just before. With a positive timeout, tasks still
+// running after it are cancelled, and Stop returns once they have cleaned up.
+func (e *Engine) Stop(timeout time.Duration) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	e.stop(ctx)
+}
+
+// stop stops the cron schedule and waits for running tasks, cancelling those
+// still running once ctx is done.
+func (e *Engine) stop(ctx context.Context) {
+	e.mu.Lock()
+	e.running = false
+	e.stopping = true
+	stopped := e.cron.Stop()
+	cancel := e.cancel
+	e.mu.Unlock()
+
+	expired := ctx.Done()
+	wait := func(done <-chan struct{}) {
+		select {
+		case <-done:
+		case <-expired:
+			e.log().Warn("Cancelling running tasks", "reason", ctx.Err())
+			cancel()
+			expired = nil
+			<-done
+		}
+	}
+
+	// A job the schedule fired may not have started its task yet, so the
+	// jobs are waited for before the tasks.
+	wait(stopped.Done())
+	e.mu.Lock()
+	done := e.idle
+	e.mu.Unlock()
+	wait(done)
+	cancel()
+}
+
+// EntryStatus is the state of a single entry as reported by Status.
+type EntryStatus struct {
+	Name                string     `json:"name"`
+	Type                string     `json:"type"`
+	Paused              bool       `json:"paused"`
+	NextRun             *time.Time `json:"next_run,omitempty"`
+	LastRun             *time.Time `json:"last_run,omitempty"`
+	LastDuration        string     `json:"last_duration,omitempty"`
+	LastResult          string     `json:"last_result"` // never, success or failure
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// Status is the state of the engine. It is unhealthy when the engine is not
+// running or an entry failed too many consecutive times, as listed in
+// Reasons.
+type Status struct {
+	Running bool          `json:"running"`
+	Paused  bool          `json:"paused"`
+	Healthy bool          `json:"healthy"`
+	Reasons []string      `json:"reasons,omitempty"`
+	Entries []EntryStatus `json:"entries"`
+}
+
+func (e *Engine) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := Status{Running: e.running, Paused: e.paused, Entries: []EntryStatus{}}
+	if !e.running {
+		status.Reasons = append(status.Reasons, "scheduler is not running")
+	}
+
+	for _, logEntry := range e.entries {
+		entry := EntryStatus{
+			Name:       logEntry.Name,
+			Type:       logEntry.Type,
+			Paused:     e.pausedEntries[logEntry.Name],
+			LastResult: "never",
+		}
+
+		// A paused engine or entry does not run at the next time of the
+		// schedule.
+		if next := e.cron.Entry(e.cronIDs[logEntry.Name]).Next; e.running && !e.paused && !entry.Paused && !next.IsZero() {
+			entry.NextRun = &next
+		}
+
+		if result := e.results[logEntry.Name]; result != nil && !result.lastRun.IsZero() {
+			lastRun := result.lastRun
+			entry.LastRun = &lastRun
+			entry.LastDuration = result.lastDuration.String()
+			entry.LastResult = "success"
+			entry.ConsecutiveFailures = result.consecutiveFailures
+			if result.lastErr != nil {
+				entry.LastResult = "failure"
+				entry.LastError = result.lastErr.Error()
+			}
+			if result.consecutiveFailures >= e.unhealthyAfter {
+				status.Reasons = append(status.Reasons, fmt.Sprintf("entry %s failed %d consecutive times", logEntry.Name, result.consecutiveFailures))
+			}
+		}
+
+		status.Entries = append(status.Entries, entry)
+	}
+
+	status.Healthy = len(status.Reasons) == 0
+	return status
+}
@@ -0,0 +1,328 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEngineStatus(t *testing.T) {
+	engine := New(Config{
+		Schedule: "@every 1h",
+		Logs: []LogEntry{
+			{Name: "app", Type: "delete", Path: Paths{filepath.Join(t.TempDir(), "*.log")}},
+		},
+	}, Options{UnhealthyAfter: 2})
+
+	status := engine.Status()
+	if status.Running || status.Healthy {
+		t.Errorf("Expected stopped engine to be unhealthy, got %+v", status)
+	}
+
+	engine.Start(context.Background())
+	defer engine.Stop(time.Second)
+
+	status = engine.Status()
+	if !status.Running || !status.Healthy {
+		t.Fatalf("Expected running engine to be healthy, got %+v", status)
+	}
+	if len(status.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(status.Entries))
+	}
+	entry := status.Entries[0]
+	if entry.LastResult != "never" {
+		t.Errorf("Expected last result 'never', got %s", entry.LastResult)
+	}
+	if entry.NextRun == nil || time.Until(*entry.NextRun) <= 0 {
+		t.Errorf("Expected next run in the future, got %v", entry.NextRun)
+	}
+
+	_ = engine.PauseEntry("app")
+	if next := engine.Status().Entries[0].NextRun; next != nil {
+		t.Errorf("Expected no next run of a paused entry, got %v", next)
+	}
+	_ = engine.ResumeEntry("app")
+	engine.Pause()
+	if next := engine.Status().Entries[0].NextRun; next != nil {
+		t.Errorf("Expected no next run while the engine is paused, got %v", next)
+	}
+	engine.Resume()
+
+	engine.recordResult("app", time.Now(), time.Second, errors.New("access denied"))
+	status = engine.Status()
+	if !status.Healthy || status.Entries[0].LastResult != "failure" || status.Entries[0].LastError != "access denied" {
+		t.Errorf("Expected a single failure to be reported but healthy, got %+v", status)
+	}
+
+	engine.recordResult("app", time.Now(), time.Second, errors.New("access denied"))
+	status = engine.Status()
+	if status.Healthy || status.Entries[0].ConsecutiveFailures != 2 {
+		t.Errorf("Expected unhealthy after 2 consecutive failures, got %+v", status)
+	}
+
+	engine.recordResult("app", time.Now(), time.Second, nil)
+	status = engine.Status()
+	if !status.Healthy || status.Entries[0].LastResult != "success" || status.Entries[0].ConsecutiveFailures != 0 {
+		t.Errorf("Expected success to reset failures, got %+v", status)
+	}
+}
+
+func TestEngineInvalidSchedule(t *testing.T) {
+	engine := New(Config{
+		Schedule: "not a engineule",
+		Logs:     []LogEntry{{Name: "app", Type: "delete"}},
+	}, Options{})
+
+	if status := engine.Status(); len(status.Entries) != 0 {
+		t.Errorf("Expected entry with invalid engineule to be skipped, got %+v", status.Entries)
+	}
+}
+
+func newTestEngine(config Config, task func(context.Context) error) *Engine {
+	engine := New(Config{}, Options{})
+	engine.newTask = func(LogEntry) func(context.Context) error { return task }
+	engine.load(config)
+	return engine
+}
+
+func TestEnginePauseResume(t *testing.T) {
+	var runs atomic.Int32
+	engine := newTestEngine(Config{
+		Schedule: "@every 1s",
+		Logs:     []LogEntry{{Name: "app", Type: "delete"}},
+	}, func(context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+
+	engine.Start(context.Background())
+	defer engine.Stop(time.Second)
+
+	engine.Pause()
+	time.Sleep(1500 * time.Millisecond)
+	if got := runs.Load(); got != 0 {
+		t.Errorf("Expected no runs while paused, got %d", got)
+	}
+	if status := engine.Status(); !status.Paused {
+		t.Errorf("Expected status to report the engine as paused")
+	}
+
+	engine.Resume()
+	time.Sleep(1500 * time.Millisecond)
+	if got := runs.Load(); got == 0 {
+		t.Errorf("Expected runs after resuming, got none")
+	}
+}
+
+func TestEngineStopWaitsForTasks(t *testing.T) {
+	var cancelled atomic.Bool
+	engine := newTestEngine(Config{
+		Schedule: "@every 1h",
+		Logs:     []LogEntry{{Name: "app", Type: "delete"}},
+	}, func(ctx context.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		cancelled.Store(ctx.Err() != nil)
+		return nil
+	})
+	engine.Start(context.Background())
+
+	go engine.Trigger("app")
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	engine.Stop(5 * time.Second)
+
+	if time.Since(start) < 100*time.Millisecond {
+		t.Errorf("Expected stop to wait for the running task")
+	}
+	if cancelled.Load() {
+		t.Errorf("Expected task finishing within the timeout not to be cancelled")
+	}
+	if status := engine.Status(); status.Running || status.Entries[0].LastResult != "success" {
+		t.Errorf("Unexpected status after stop: %+v", status)
+	}
+}
+
+func TestEngineStopWaitsForFiredJobs(t *testing.T) {
+	engine := newTestEngine(Config{Schedule: "@every 1h"}, nil)
+	fired := make(chan struct{})
+	var ran atomic.Bool
+	engine.cron.AddFunc("@every 1s", func() {
+		close(fired)
+		// The job has not started its task yet when Stop is called.
+		time.Sleep(200 * time.Millisecond)
+		engine.runTask("late", func(context.Context) error {
+			ran.Store(true)
+			return nil
+		})
+	})
+	engine.Start(context.Background())
+
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the job to fire")
+	}
+	engine.Stop(5 * time.Second)
+
+	if !ran.Load() {
+		t.Errorf("Expected stop to wait for the task of a fired job")
+	}
+}
+
+func TestEngineTriggerWhileStopping(t *testing.T) {
+	release := make(chan struct{})
+	var cancelled atomic.Bool
+	engine := newTestEngine(Config{
+		Schedule: "@every 1h",
+		Logs:     []LogEntry{{Name: "app", Type: "delete"}, {Name: "web", Type: "delete"}},
+	}, func(ctx context.Context) error {
+		<-release
+		cancelled.Store(ctx.Err() != nil)
+		return nil
+	})
+	engine.Start(context.Background())
+
+	go engine.Trigger("app")
+	time.Sleep(50 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		engine.Stop(5 * time.Second)
+		close(stopped)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Trigger("web"); !errors.Is(err, ErrEngineStopped) {
+		t.Errorf("Expected ErrEngineStopped while stopping, got %v", err)
+	}
+	if err := engine.RunOnce(context.Background()); !errors.Is(err, ErrEngineStopped) {
+		t.Errorf("Expected RunOnce to fail with ErrEngineStopped while stopping, got %v", err)
+	}
+	close(release)
+	<-stopped
+	if cancelled.Load() {
+		t.Errorf("Expected no task to run on a cancelled context")
+	}
+
+	engine.Start(context.Background())
+	defer engine.Stop(time.Second)
+	if err := engine.Trigger("web"); err != nil {
+		t.Errorf("Expected trigger after restarting to run, got %v", err)
+	}
+}
+
+func TestEngineStopCancelsTasks(t *testing.T) {
+	engine := newTestEngine(Config{
+		Schedule: "@every 1h",
+		Logs:     []LogEntry{{Name: "app", Type: "delete"}},
+	}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	engine.Start(context.Background())
+
+	go engine.Trigger("app")
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		engine.Stop(100 * time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected stop to cancel the running task")
+	}
+
+	status := engine.Status()
+	if status.Entries[0].LastResult != "failure" || status.Entries[0].LastError != context.Canceled.Error() {
+		t.Errorf("Expected cancelled run to be recorded, got %+v", status.Entries[0])
+	}
+}
+
+func TestEngineReloadCancelsTasksWhenContextIsDone(t *testing.T) {
+	config := Config{
+		Schedule: "@every 1h",
+		Logs:     []LogEntry{{Name: "app", Type: "delete"}},
+	}
+	engine := newTestEngine(config, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	engine.Start(context.Background())
+	defer engine.Stop(time.Second)
+
+	go engine.Trigger("app")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		engine.Reload(ctx, config)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected reload to cancel the running task once its context is done")
+	}
+	if status := engine.Status(); !status.Running {
+		t.Errorf("Expected engine to run again after reloading")
+	}
+}
+
+func TestEngineSkipsOverlappingRuns(t *testing.T) {
+	release := make(chan struct{})
+	engine := newTestEngine(Config{
+		Schedule: "@every 1h",
+		Logs:     []LogEntry{{Name: "app", Type: "rotate"}},
+	}, func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	first := make(chan error)
+	go func() { first <- engine.Trigger("app") }()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Trigger("app"); !errors.Is(err, ErrEntryRunning) {
+		t.Errorf("Expected ErrEntryRunning for overlapping run, got %v", err)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Errorf("Expected first run to succeed, got %v", err)
+	}
+
+	if status := engine.Status(); status.Entries[0].LastResult != "success" {
+		t.Errorf("Expected skipped run not to be recorded as a failure, got %+v", status.Entries[0])
+	}
+}
+
+func TestEngineRunOnce(t *testing.T) {
+	var runs atomic.Int32
+	engine := newTestEngine(Config{
+		Schedule: "@every 1h",
+		Logs:     []LogEntry{{Name: "app"}, {Name: "broken"}, {Name: "other"}},
+	}, func(context.Context) error {
+		if runs.Add(1) == 2 {
+			return errors.New("access denied")
+		}
+		return nil
+	})
+
+	err := engine.RunOnce(context.Background())
+	if got := runs.Load(); got != 3 {
+		t.Errorf("Expected every entry to run once, got %d runs", got)
+	}
+	if err == nil || err.Error() != "broken: access denied" {
+		t.Errorf("Expected error of the failed entry, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package logrotate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// FS is the filesystem the engine works on. OSFS is used unless another one is
+// given in Options, e.g. to inject failures in tests.
+type FS interface {
+	Open(name string) (File, error)
+	Create(name string) (File, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	MkdirAll(path string, perm fs.FileMode) error
+	Glob(pattern string) ([]string, error)
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// File is an open file of an FS. *os.File implements it.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OSFS is the FS of the operating system.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (OSFS) Create(name string) (File, error) {
+	return os.Create(name)
+}
+
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (OSFS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// DiskSpace returns the free and total bytes of the volume holding path.
+func (OSFS) DiskSpace(path string) (free, total uint64, err error) {
+	return diskSpace(path)
+}
+
+// VolumeOf identifies the volume holding path.
+func (OSFS) VolumeOf(path string) (string, error) {
+	return volumeOf(path)
+}
+
+// spaceFS is an FS telling the free space of its volumes, which free_space
+// conditions need. OSFS implements it.
+type spaceFS interface {
+	DiskSpace(path string) (free, total uint64, err error)
+	VolumeOf(path string) (string, error)
+}
+
+// readFile returns the content of the file at path.
+func readFile(fsys FS, path string) ([]byte, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// syncDir makes the files renamed into dir durable, so a file they replace,
+// e.g. the original of an archive, can be removed safely. Windows cannot
+// sync a directory, NTFS journals renames instead.
+func syncDir(fsys FS, dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	file, err := fsys.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync directory: %v", err)
+	}
+	return nil
+}
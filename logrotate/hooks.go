@@ -0,0 +1,58 @@
+package logrotate
+
+import "time"
+
+// FileEvent describes something the engine did to a file.
+type FileEvent struct {
+	Entry string
+	// rotated, split, deleted, copied, moved, compressed, recompressed,
+	// archived, compacted, encrypted, truncated, pruned, uploaded or spooled
+	Action  string
+	Path    string
+	NewPath string // name after rotation, compression, copying or moving, destination of uploads
+	Size    int64  // size of the file before the action
+	NewSize int64  // size of the compressed or truncated file
+
+	Redacted int64 // lines masked by redaction rules while compressing
+	Dropped  int64 // lines dropped by redaction rules while compressing
+}
+
+// Reclaimed is the disk space freed by the action.
+func (e FileEvent) Reclaimed() int64 {
+	switch e.Action {
+	case "deleted", "pruned":
+		return e.Size
+	case "compressed", "recompressed", "truncated":
+		return e.Size - e.NewSize
+	default:
+		return 0
+	}
+}
+
+// ErrorEvent describes a failure while processing an entry. Kind groups
+// failures, e.g. glob, stat, config, rename, compress, recompress, archive, compact,
+// verify, encrypt, sign, upload, delete or prune.
+type ErrorEvent struct {
+	Entry string
+	Kind  string
+	Path  string
+	Err   error
+}
+
+// TaskEvent describes a finished run of an entry.
+type TaskEvent struct {
+	Entry    string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// Hooks are callbacks the engine invokes as it works. Any of them may be nil.
+// They are called from the goroutines processing files, possibly
+// concurrently, and must not block.
+type Hooks struct {
+	OnFile      func(FileEvent)
+	OnError     func(ErrorEvent)
+	OnTaskDone  func(TaskEvent)
+	OnScheduled func(entry string)
+}
@@ -0,0 +1,201 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const journalFileName = "journal.json"
+
+// journalRecord is a compression in progress. Until its record is removed,
+// Source may still exist next to a partial temporary file or a complete
+// Archive.
+type journalRecord struct {
+	Entry   string          `json:"entry"`
+	Source  string          `json:"source"`
+	Archive string          `json:"archive"`
+	Format  string          `json:"format"`
+	Options compressOptions `json:"options"`
+	Started time.Time       `json:"started"`
+}
+
+// journal records compressions in progress in the state directory, so those
+// interrupted by a crash can be finished by Recover. Without a state
+// directory nothing is recorded.
+type journal struct {
+	fs   FS
+	path string
+
+	mu sync.Mutex
+}
+
+func newJournal(fsys FS, stateDir string) *journal {
+	j := &journal{fs: fsys}
+	if stateDir != "" {
+		j.path = filepath.Join(stateDir, journalFileName)
+	}
+	return j
+}
+
+func (j *journal) begin(record journalRecord) error {
+	return j.update(func(records []journalRecord) []journalRecord {
+		return append(records, record)
+	})
+}
+
+func (j *journal) end(source string) error {
+	return j.update(func(records []journalRecord) []journalRecord {
+		kept := records[:0]
+		for _, record := range records {
+			if record.Source != source {
+				kept = append(kept, record)
+			}
+		}
+		return kept
+	})
+}
+
+func (j *journal) records() ([]journalRecord, error) {
+	if j.path == "" {
+		return nil, nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.read()
+}
+
+func (j *journal) update(change func([]journalRecord) []journalRecord) error {
+	if j.path == "" {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	records, err := j.read()
+	if err != nil {
+		return err
+	}
+	return j.write(change(records))
+}
+
+// read must be called with j.mu held.
+func (j *journal) read() ([]journalRecord, error) {
+	var records []journalRecord
+	if err := readStateFile(j.fs, j.path, &records); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %v", err)
+	}
+	return records, nil
+}
+
+// write replaces the journal atomically, removing it once no compression is
+// in progress. It must be called with j.mu held.
+func (j *journal) write(records []journalRecord) error {
+	if err := writeStateFile(j.fs, j.path, records, len(records) == 0); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	return nil
+}
+
+// compress is compressFile recorded in the journal while it runs.
+func (e *Engine) compress(ctx context.Context, run *taskRun, filePath string, format string, options compressOptions) (compressResult, error) {
+	archive, err := archiveName(filePath, format, options)
+	if err != nil {
+		return compressResult{}, err
+	}
+
+	record := journalRecord{Entry: run.entry, Source: filePath, Archive: archive, Format: format, Options: options, Started: e.clock.Now()}
+	if err := e.journal.begin(record); err != nil {
+		run.logger.Warn("Failed to record compression in journal", "path", filePath, "error", err)
+	}
+
+	result, err := compressFile(ctx, e.fs, filePath, format, options)
+
+	if ctx.Err() != nil && err != nil {
+		// Keep the record, the compression is resumed on the next start.
+		return compressResult{}, err
+	}
+	if endErr := e.journal.end(filePath); endErr != nil {
+		run.logger.Warn("Failed to remove compression from journal", "path", filePath, "error", endErr)
+	}
+	return result, err
+}
+
+// Recover finishes compressions that were interrupted by a crash or a
+// cancelled run, as recorded in the journal of the state directory. An
+// archive that was renamed into place is complete, so only the original is
+// removed; otherwise the partial archive is removed and the original
+// compressed again. It should be called before Start or RunOnce.
+func (e *Engine) Recover(ctx context.Context) error {
+	records, err := e.journal.records()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, record := range records {
+		if err := e.recoverCompression(ctx, record); err != nil {
+			e.log().Error("Failed to recover interrupted compression", "entry", record.Entry, "path", record.Source, "error", err)
+			if e.hooks.OnError != nil {
+				e.hooks.OnError(ErrorEvent{Entry: record.Entry, Kind: "recover", Path: record.Source, Err: err})
+			}
+			errs = append(errs, fmt.Errorf("%s: %v", record.Source, err))
+			continue
+		}
+		if err := e.journal.end(record.Source); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Engine) recoverCompression(ctx context.Context, record journalRecord) error {
+	logger := e.log().With("entry", record.Entry, "action", "recover")
+
+	partials := []string{
+		tempName(record.Archive),
+		tempName(strings.TrimSuffix(record.Archive, encryptedSuffix)),
+		tempName(record.Archive + checksumSuffix),
+		tempName(record.Archive + signatureSuffix),
+	}
+	for _, partial := range partials {
+		if err := e.fs.Remove(partial); err == nil {
+			logger.Info("Removed partial file", "path", partial)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove partial file: %v", err)
+		}
+	}
+
+	sourceInfo, err := e.fs.Stat(record.Source)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	if _, err := e.fs.Stat(record.Archive); err == nil {
+		if err := e.fs.Remove(record.Source); err != nil {
+			return fmt.Errorf("failed to remove original file after compression: %v", err)
+		}
+		logger.Info("Removed original of completed archive", "path", record.Source, "archive", record.Archive)
+		return nil
+	}
+
+	result, err := compressFile(ctx, e.fs, record.Source, record.Format, record.Options)
+	if err != nil {
+		return err
+	}
+	logger.Info("Compressed log file", "path", record.Source, "format", record.Format, "bytes", result.Size)
+	if e.hooks.OnFile != nil {
+		e.hooks.OnFile(FileEvent{Entry: record.Entry, Action: "compressed", Path: record.Source, NewPath: result.Path, Size: sourceInfo.Size(), NewSize: result.Size, Redacted: result.Redacted, Dropped: result.Dropped})
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateFileJournal(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(tempDir, "state")
+	file := filepath.Join(tempDir, "app.log")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+
+	engine := New(Config{StateDir: stateDir}, Options{})
+	run := engine.newTaskRun(LogEntry{Name: "app"}, "rotate")
+
+	result, err := engine.compress(context.Background(), run, file, "gzip", compressOptions{})
+	if err != nil {
+		t.Fatalf("compress() error: %v", err)
+	}
+	archive := result.Path
+	if archive != file+".gz" {
+		t.Errorf("Expected archive %s, got %s", file+".gz", archive)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, journalFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected journal to be removed once no compression is in progress")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+	if _, err := engine.compress(ctx, run, file, "zip", compressOptions{}); err == nil {
+		t.Fatalf("Expected error for cancelled compression")
+	}
+
+	records, err := engine.journal.records()
+	if err != nil {
+		t.Fatalf("records() error: %v", err)
+	}
+	if len(records) != 1 || records[0].Source != file || records[0].Archive != file+".zip" || records[0].Entry != "app" {
+		t.Errorf("Expected cancelled compression to stay in the journal, got %+v", records)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(tempDir, "state")
+
+	// Crashed while writing the archive.
+	partial := filepath.Join(tempDir, "partial.log.1")
+	_ = os.WriteFile(partial, []byte("partial\n"), 0644)
+	_ = os.WriteFile(partial+".gz.tmp", []byte("truncated"), 0644)
+
+	// Crashed after the archive was renamed into place.
+	renamed := filepath.Join(tempDir, "renamed.log.1")
+	_ = os.WriteFile(renamed, []byte("renamed\n"), 0644)
+	_ = os.WriteFile(renamed+".gz", []byte("complete"), 0644)
+
+	// Finished, but the journal was not updated.
+	finished := filepath.Join(tempDir, "finished.log.1")
+	_ = os.WriteFile(finished+".gz", []byte("complete"), 0644)
+
+	var events []FileEvent
+	engine := New(Config{StateDir: stateDir}, Options{Hooks: Hooks{OnFile: func(event FileEvent) {
+		events = append(events, event)
+	}}})
+	for _, source := range []string{partial, renamed, finished} {
+		record := journalRecord{Entry: "app", Source: source, Archive: source + ".gz", Format: "gzip", Started: time.Now()}
+		if err := engine.journal.begin(record); err != nil {
+			t.Fatalf("begin() error: %v", err)
+		}
+	}
+
+	if err := engine.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover() error: %v", err)
+	}
+
+	if _, err := os.Stat(partial + ".gz.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected partial archive to be removed")
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("Expected interrupted file to be compressed again")
+	}
+	gzipFile, err := os.Open(partial + ".gz")
+	if err != nil {
+		t.Fatalf("Expected archive of interrupted file: %v", err)
+	}
+	defer gzipFile.Close()
+	gzipReader, err := gzip.NewReader(gzipFile)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if content, _ := io.ReadAll(gzipReader); string(content) != "partial\n" {
+		t.Errorf("Expected archive to contain the original, got %q", content)
+	}
+
+	if _, err := os.Stat(renamed); !os.IsNotExist(err) {
+		t.Errorf("Expected original of completed archive to be removed")
+	}
+	if content, _ := os.ReadFile(renamed + ".gz"); string(content) != "complete" {
+		t.Errorf("Expected completed archive to be kept, got %q", content)
+	}
+	if _, err := os.Stat(finished + ".gz"); err != nil {
+		t.Errorf("Expected finished archive to be kept: %v", err)
+	}
+
+	if len(events) != 1 || events[0].Action != "compressed" || events[0].Path != partial || events[0].Entry != "app" {
+		t.Errorf("Expected a compressed event for the interrupted file, got %+v", events)
+	}
+	if records, _ := engine.journal.records(); len(records) != 0 {
+		t.Errorf("Expected journal to be empty after recovery, got %+v", records)
+	}
+}
+
+func TestRecoverWithoutStateDir(t *testing.T) {
+	engine := New(Config{}, Options{})
+	if err := engine.Recover(context.Background()); err != nil {
+		t.Errorf("Recover() without state dir error: %v", err)
+	}
+}
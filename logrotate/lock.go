@@ -0,0 +1,160 @@
+package logrotate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned by AcquireLock when another process holds the lock.
+var ErrLocked = errors.New("lock is held by another instance")
+
+type lockOwner struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+	Token    string    `json:"token"`
+}
+
+// Lock is an exclusive lock on a file held by a single process at a time.
+// The operating system holds the lock on the open file, LockFileEx on Windows
+// and flock elsewhere, so it is released when the process exits, however it
+// does. The file records its owner for the error of processes finding the
+// lock held.
+type Lock struct {
+	fs    FS
+	path  string
+	file  *os.File
+	owner lockOwner
+}
+
+// AcquireLock locks the file at path, creating it if needed. If another
+// process holds the lock, an error wrapping ErrLocked is returned. The lock
+// is taken on the file of the operating system; its directory, owner and
+// removal go through the FS of options, and its start time is that of their
+// Clock.
+func AcquireLock(path string, options Options) (*Lock, error) {
+	fsys := options.filesystem()
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := openLockFile(path)
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%w (lock file %s is being released)", ErrLocked, path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %v", err)
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			if !errors.Is(err, ErrLocked) {
+				return nil, fmt.Errorf("failed to lock file: %v", err)
+			}
+			var owner lockOwner
+			if data, err := readFile(fsys, path); err != nil || json.Unmarshal(data, &owner) != nil {
+				return nil, fmt.Errorf("%w (lock file %s)", ErrLocked, path)
+			}
+			return nil, fmt.Errorf("%w (pid %d on %s since %s)", ErrLocked, owner.PID, owner.Hostname, owner.Started.Format(time.RFC3339))
+		}
+
+		// The owner releasing the lock may have removed the file after it
+		// was opened here, so the lock is only held on what path is now.
+		if held, err := file.Stat(); err == nil {
+			if current, err := fsys.Stat(path); err == nil && os.SameFile(held, current) {
+				return startLock(fsys, options.clock(), path, file)
+			}
+		}
+		file.Close()
+	}
+	return nil, fmt.Errorf("%w: lock file %s keeps being replaced", ErrLocked, path)
+}
+
+// WaitLock is AcquireLock retrying every interval while the lock is held,
+// until ctx is done.
+func WaitLock(ctx context.Context, path string, interval time.Duration, options Options) (*Lock, error) {
+	for {
+		lock, err := AcquireLock(path, options)
+		if !errors.Is(err, ErrLocked) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(interval):
+		}
+	}
+}
+
+func startLock(fsys FS, clock Clock, path string, file *os.File) (*Lock, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to generate lock token: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	owner := lockOwner{PID: os.Getpid(), Hostname: hostname, Started: clock.Now(), Token: hex.EncodeToString(token)}
+
+	data, err := json.Marshal(owner)
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		_, err = file.WriteAt(append(data, '\n'), 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write lock file: %v", err)
+	}
+	return &Lock{fs: fsys, path: path, file: file, owner: owner}, nil
+}
+
+// Release removes the lock file, unless it no longer records this lock or
+// the platform keeps it, see removeLockOnRelease, and releases the lock.
+func (l *Lock) Release() error {
+	var errs []error
+	if removeLockOnRelease && l.owned() {
+		if err := l.fs.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to remove lock file: %v", err))
+		}
+	}
+	if err := l.file.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to release lock: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// owned reports whether the file at the path of l is still the locked one
+// and records l as its owner.
+func (l *Lock) owned() bool {
+	held, err := l.file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := l.fs.Stat(l.path)
+	if err != nil || !os.SameFile(held, current) {
+		return false
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(l.file, 0, held.Size()))
+	if err != nil {
+		return false
+	}
+	var owner lockOwner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return false
+	}
+	return owner.PID == l.owner.PID && owner.Token == l.owner.Token
+}
@@ -0,0 +1,22 @@
+//go:build aix || (!unix && !windows)
+
+package logrotate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// removeLockOnRelease is true as the lock file can be removed while open
+// without getting in the way of other processes opening the path.
+const removeLockOnRelease = true
+
+func openLockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// lockFile fails as this platform has no file locks the lock could rely on.
+func lockFile(file *os.File) error {
+	return fmt.Errorf("file locks are not supported on this platform: %w", errors.ErrUnsupported)
+}
@@ -0,0 +1,130 @@
+package logrotate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeLockFile(t *testing.T, path string, owner lockOwner) {
+	t.Helper()
+
+	data, _ := json.Marshal(owner)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write lock file: %v", err)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "wingologrotate.lock")
+
+	lock, err := AcquireLock(path, Options{})
+	if err != nil {
+		t.Fatalf("AcquireLock() error: %v", err)
+	}
+
+	if _, err := AcquireLock(path, Options{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked for held lock, got %v", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() error: %v", err)
+	}
+	if _, err := os.Stat(path); removeLockOnRelease && !os.IsNotExist(err) {
+		t.Errorf("Expected lock file to be removed on release")
+	}
+
+	lock, err = AcquireLock(path, Options{})
+	if err != nil {
+		t.Fatalf("AcquireLock() after release error: %v", err)
+	}
+	lock.Release()
+}
+
+func TestAcquireLockLeftBehind(t *testing.T) {
+	// The file of a crashed instance is not locked any more.
+	path := filepath.Join(t.TempDir(), "wingologrotate.lock")
+	writeLockFile(t, path, lockOwner{PID: 1 << 30, Hostname: "crashed", Started: time.Now(), Token: "old"})
+
+	lock, err := AcquireLock(path, Options{})
+	if err != nil {
+		t.Fatalf("Expected lock left behind to be taken over, got %v", err)
+	}
+	defer lock.Release()
+
+	data, _ := os.ReadFile(path)
+	var owner lockOwner
+	if err := json.Unmarshal(data, &owner); err != nil || owner.PID != os.Getpid() || owner.Token != lock.owner.Token {
+		t.Errorf("Expected lock file to record this process, got %q", data)
+	}
+}
+
+func TestReleaseKeepsLockOfAnotherOwner(t *testing.T) {
+	t.Run("rewritten", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "wingologrotate.lock")
+		lock, err := AcquireLock(path, Options{})
+		if err != nil {
+			t.Fatalf("AcquireLock() error: %v", err)
+		}
+		writeLockFile(t, path, lockOwner{PID: os.Getpid(), Token: "other"})
+
+		if err := lock.Release(); err != nil {
+			t.Fatalf("Release() error: %v", err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected lock file of another owner to be kept, got %v", err)
+		}
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "wingologrotate.lock")
+		lock, err := AcquireLock(path, Options{})
+		if err != nil {
+			t.Fatalf("AcquireLock() error: %v", err)
+		}
+		data, _ := os.ReadFile(path)
+		// Removing the file of an open lock fails on Windows.
+		if err := os.Remove(path); err != nil {
+			lock.Release()
+			t.Skipf("Cannot replace a locked file here: %v", err)
+		}
+		_ = os.WriteFile(path, data, 0644)
+
+		if err := lock.Release(); err != nil {
+			t.Fatalf("Release() error: %v", err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected lock file replacing the locked one to be kept, got %v", err)
+		}
+	})
+}
+
+func TestWaitLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wingologrotate.lock")
+
+	held, err := AcquireLock(path, Options{})
+	if err != nil {
+		t.Fatalf("AcquireLock() error: %v", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		held.Release()
+	}()
+
+	lock, err := WaitLock(context.Background(), path, 20*time.Millisecond, Options{})
+	if err != nil {
+		t.Fatalf("WaitLock() error: %v", err)
+	}
+	defer lock.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := WaitLock(ctx, path, 20*time.Millisecond, Options{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked once the context is done, got %v", err)
+	}
+}
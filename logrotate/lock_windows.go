@@ -0,0 +1,47 @@
+package logrotate
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffsetHigh is the upper half of the offset of the byte locked, 1<<62,
+// past any content of the lock file so other processes can still read the
+// owner it records.
+const lockOffsetHigh = 1 << 30
+
+// removeLockOnRelease is false as a file deleted while open stays pending
+// deletion until closed, which makes opening it fail for other processes in
+// the meantime. The byte range lock alone tells whether the lock is held.
+const removeLockOnRelease = false
+
+// openLockFile opens the lock file allowing it to be deleted while open, e.g.
+// by hand. A file pending deletion counts as locked, as it is until closed.
+func openLockFile(path string) (*os.File, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateFile(name,
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if errors.Is(err, windows.ERROR_DELETE_PENDING) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+func lockFile(file *os.File) error {
+	overlapped := windows.Overlapped{OffsetHigh: lockOffsetHigh}
+	err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package logrotate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Conflict policies of copy and move steps, for files already present at the
+// destination.
+const (
+	conflictOverwrite = "overwrite" // replace the file at the destination
+	conflictSkip      = "skip"      // leave both files alone
+	conflictRename    = "rename"    // store the file as 1/name, 2/name, ... next to the one present
+)
+
+// globRoot returns the directory of pattern above its first wildcard. Files
+// matched by pattern are mirrored relative to it.
+func globRoot(pattern string) string {
+	pattern = filepath.Clean(pattern)
+	dir := filepath.Dir(pattern)
+	for dir != filepath.Dir(dir) && strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// matchRoot returns the glob root of the first of paths matching file.
+func matchRoot(paths Paths, file string) string {
+	for _, pattern := range paths {
+		if matched, _ := filepath.Match(filepath.Clean(pattern), file); matched {
+			return globRoot(pattern)
+		}
+	}
+	return filepath.Dir(file)
+}
+
+// mirrorPath returns where file is copied or moved to by step, keeping its
+// path relative to the glob root, and whether it should be.
+func (e *Engine) mirrorPath(step *Step, file *pipelineFile) (string, bool, error) {
+	relative, err := filepath.Rel(file.root, file.path)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		relative = filepath.Base(file.path)
+	}
+	target := filepath.Join(*step.Destination, relative)
+
+	if _, err := e.fs.Stat(target); errors.Is(err, os.ErrNotExist) {
+		return target, true, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to get destination file info: %v", err)
+	}
+
+	switch conflictPolicy(step) {
+	case conflictOverwrite:
+		return target, true, nil
+	case conflictSkip:
+		return target, false, nil
+	default:
+		// The name is kept, as signatures and checksum sidecars name the
+		// file they belong to.
+		dir, name := filepath.Split(target)
+		for i := 1; ; i++ {
+			candidate := filepath.Join(dir, strconv.Itoa(i), name)
+			if _, err := e.fs.Stat(candidate); errors.Is(err, os.ErrNotExist) {
+				return candidate, true, nil
+			}
+		}
+	}
+}
+
+// conflictPolicy returns the conflict policy of step. Copies skip by default,
+// so files past their age are not copied again on every run; moves rename,
+// so nothing is lost.
+func conflictPolicy(step *Step) string {
+	if step.Conflict != nil {
+		return *step.Conflict
+	}
+	if step.Action == actionMove {
+		return conflictRename
+	}
+	return conflictSkip
+}
+
+// copyStep copies the file below the destination of step, along with its
+// sidecars, if it is older than the age of step. Sidecars matched themselves
+// are left to their archive. The remaining steps work on the original.
+func (e *Engine) copyStep(run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	if isSidecar(filepath.Base(file.path)) {
+		return false, nil
+	}
+	fileInfo, due, err := e.dueByAge(step, file)
+	if err != nil || !due {
+		return false, err
+	}
+
+	target, ok, err := e.mirrorPath(step, file)
+	if err != nil {
+		return false, stepFailed("copy", "Failed to resolve copy destination", file.path, err)
+	}
+	if !ok {
+		run.logger.Info("Skipping file present at destination", "path", file.path, "destination", target)
+		return true, nil
+	}
+
+	if err := e.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return false, stepFailed("copy", "Failed to create destination directory", target, err)
+	}
+	if err := copyFile(e.fs, file.path, target); err != nil {
+		return false, stepFailed("copy", "Failed to copy file", file.path, err)
+	}
+	if err := e.mirrorSidecars(file.path, target, false); err != nil {
+		return false, stepFailed("copy", "Failed to copy sidecar", file.path, err)
+	}
+	run.logger.Info("Copied file", "path", file.path, "destination", target, "bytes", fileInfo.Size())
+	run.done(FileEvent{Action: "copied", Path: file.path, NewPath: target, Size: fileInfo.Size()})
+	return true, nil
+}
+
+// moveStep moves the file below the destination of step, along with its
+// sidecars, if it is older than the age of step, copying and deleting it if
+// it cannot be renamed there, e.g. on another volume. Sidecars matched
+// themselves are left to their archive.
+func (e *Engine) moveStep(run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	if isSidecar(filepath.Base(file.path)) {
+		return false, nil
+	}
+	fileInfo, due, err := e.dueByAge(step, file)
+	if err != nil || !due {
+		return false, err
+	}
+
+	target, ok, err := e.mirrorPath(step, file)
+	if err != nil {
+		return false, stepFailed("move", "Failed to resolve move destination", file.path, err)
+	}
+	if !ok {
+		run.logger.Info("Skipping file present at destination", "path", file.path, "destination", target)
+		return false, nil
+	}
+
+	if err := e.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return false, stepFailed("move", "Failed to create destination directory", target, err)
+	}
+	if renameErr := e.fs.Rename(file.path, target); renameErr != nil {
+		run.logger.Debug("Renaming failed, copying instead", "path", file.path, "destination", target, "error", renameErr)
+		if err := copyFile(e.fs, file.path, target); err != nil {
+			return false, stepFailed("move", "Failed to move file", file.path, errors.Join(renameErr, err))
+		}
+		if err := e.fs.Remove(file.path); err != nil {
+			return false, stepFailed("move", "Failed to remove moved file", file.path, err)
+		}
+	}
+	run.logger.Info("Moved file", "path", file.path, "destination", target, "bytes", fileInfo.Size())
+	run.done(FileEvent{Action: "moved", Path: file.path, NewPath: target, Size: fileInfo.Size()})
+	sidecarErr := e.mirrorSidecars(file.path, target, true)
+	file.path = target
+	if sidecarErr != nil {
+		return false, stepFailed("move", "Failed to move sidecar", target, sidecarErr)
+	}
+	return true, nil
+}
+
+// mirrorSidecars copies or moves the sidecars of path next to target, so the
+// archive can still be verified there.
+func (e *Engine) mirrorSidecars(path, target string, move bool) error {
+	var errs []error
+	for _, suffix := range sidecarSuffixes {
+		if _, err := e.fs.Stat(path + suffix); err != nil {
+			continue
+		}
+		if move && e.fs.Rename(path+suffix, target+suffix) == nil {
+			continue
+		}
+		if err := copyFile(e.fs, path+suffix, target+suffix); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if move {
+			if err := e.fs.Remove(path + suffix); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,180 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// renameFailingFS is the OS filesystem unable to rename, like across volumes.
+type renameFailingFS struct {
+	OSFS
+}
+
+func (renameFailingFS) Rename(oldpath, newpath string) error {
+	if filepath.Ext(oldpath) == ".tmp" {
+		return os.Rename(oldpath, newpath)
+	}
+	return errors.New("the system cannot move the file to a different disk drive")
+}
+
+func TestGlobRoot(t *testing.T) {
+	tests := map[string]string{
+		filepath.Join("logs", "*.log"):              "logs",
+		filepath.Join("logs", "app*", "*", "x.log"): "logs",
+		filepath.Join("logs", "app", "x.log"):       filepath.Join("logs", "app"),
+	}
+	for pattern, want := range tests {
+		if got := globRoot(pattern); got != want {
+			t.Errorf("globRoot(%s) = %s, want %s", pattern, got, want)
+		}
+	}
+}
+
+func TestCopyFiles(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+	now := time.Date(2024, 9, 13, 12, 0, 0, 0, time.Local)
+
+	for _, name := range []string{filepath.Join("a", "old.log"), filepath.Join("b", "new.log")} {
+		path := filepath.Join(source, name)
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		_ = os.WriteFile(path, []byte(name), 0644)
+	}
+	_ = os.Chtimes(filepath.Join(source, "a", "old.log"), now.Add(-48*time.Hour), now.Add(-48*time.Hour))
+	_ = os.Chtimes(filepath.Join(source, "b", "new.log"), now, now)
+
+	engine := New(Config{}, Options{Clock: &fakeClock{now: now}})
+	copyEntry := func(conflict string) LogEntry {
+		return LogEntry{
+			Name:      "backup",
+			Path:      Paths{filepath.Join(source, "*", "*.log")},
+			Type:      "copy",
+			Condition: &Condition{Age: stringPtr("1d"), Destination: stringPtr(destination), Conflict: stringPtr(conflict)},
+		}
+	}
+
+	for _, conflict := range []string{conflictOverwrite, conflictSkip, conflictRename} {
+		if err := engine.task(copyEntry(conflict))(context.Background()); err != nil {
+			t.Fatalf("copy with conflict %s error: %v", conflict, err)
+		}
+	}
+
+	copies, _ := filepath.Glob(filepath.Join(destination, "*", "*"))
+	want := []string{filepath.Join(destination, "a", "1"), filepath.Join(destination, "a", "old.log")}
+	if len(copies) != len(want) || copies[0] != want[0] || copies[1] != want[1] {
+		t.Errorf("Expected copies %v, got %v", want, copies)
+	}
+	if _, err := os.Stat(filepath.Join(destination, "a", "1", "old.log")); err != nil {
+		t.Errorf("Expected renamed copy in a numbered directory, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(source, "a", "old.log")); err != nil {
+		t.Errorf("Expected original to be kept, got %v", err)
+	}
+}
+
+func TestCopyFilesOnce(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+	_ = os.WriteFile(filepath.Join(source, "old.log"), []byte("original"), 0644)
+
+	var events []FileEvent
+	engine := New(Config{}, Options{Hooks: Hooks{OnFile: func(event FileEvent) { events = append(events, event) }}})
+	entry := LogEntry{
+		Name:      "backup",
+		Path:      Paths{filepath.Join(source, "*.log")},
+		Type:      "copy",
+		Condition: &Condition{Destination: stringPtr(destination)},
+	}
+	for i := 0; i < 2; i++ {
+		if err := engine.task(entry)(context.Background()); err != nil {
+			t.Fatalf("copy error: %v", err)
+		}
+	}
+
+	if len(events) != 1 || events[0].Action != "copied" {
+		t.Errorf("Expected the file to be copied once, got %+v", events)
+	}
+	if copies, _ := filepath.Glob(filepath.Join(destination, "*")); len(copies) != 1 {
+		t.Errorf("Expected a single copy, got %v", copies)
+	}
+}
+
+func TestMoveSignedArchiveOntoTakenName(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+	keyDir := t.TempDir()
+	privatePEM, publicPEM, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error: %v", err)
+	}
+	keyPath := filepath.Join(keyDir, "signing.pem")
+	_ = os.WriteFile(keyPath, privatePEM, 0600)
+	publicKey, _ := ParseVerifyKey(publicPEM)
+
+	file := filepath.Join(source, "app.log.1")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+	if _, err := compressFile(context.Background(), OSFS{}, file, "gzip", compressOptions{Checksum: true, SignWith: keyPath}); err != nil {
+		t.Fatalf("compressFile() error: %v", err)
+	}
+	_ = os.WriteFile(filepath.Join(destination, "app.log.1.gz"), []byte("taken"), 0644)
+
+	moveEntry := LogEntry{Name: "app", Path: Paths{filepath.Join(source, "app.log.*")}, Type: "move", Condition: &Condition{Destination: stringPtr(destination)}}
+	engine := New(Config{Schedule: "@every 1h", Logs: []LogEntry{moveEntry}}, Options{})
+	if err := engine.task(moveEntry)(context.Background()); err != nil {
+		t.Fatalf("move error: %v", err)
+	}
+
+	checks, err := VerifySignatures(context.Background(), filepath.Join(destination, "1"), publicKey)
+	if err != nil || len(checks) != 1 || checks[0].Err != nil {
+		t.Errorf("Expected moved archive to keep a valid signature, got %+v, %v", checks, err)
+	}
+	sidecar, _ := os.ReadFile(filepath.Join(destination, "1", "app.log.1.gz"+checksumSuffix))
+	if !strings.HasSuffix(strings.TrimSpace(string(sidecar)), "  app.log.1.gz") {
+		t.Errorf("Expected checksum sidecar to name the moved archive, got %q", sidecar)
+	}
+	verified := engine.VerifyArchives(context.Background())
+	if len(verified) != 1 || verified[0].Err != nil || verified[0].Path != filepath.Join(destination, "1", "app.log.1.gz") {
+		t.Errorf("Expected moved archive to match its checksum, got %+v", verified)
+	}
+}
+
+func TestMoveFilesAcrossVolumes(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(source, "app"), 0755)
+	_ = os.WriteFile(filepath.Join(source, "app", "x.log"), []byte("moved"), 0644)
+	_ = os.MkdirAll(filepath.Join(destination, "app"), 0755)
+	_ = os.WriteFile(filepath.Join(destination, "app", "x.log"), []byte("existing"), 0644)
+
+	var events []FileEvent
+	engine := New(Config{}, Options{FS: renameFailingFS{}, Hooks: Hooks{OnFile: func(event FileEvent) {
+		events = append(events, event)
+	}}})
+	err := engine.task(LogEntry{
+		Name:      "archive",
+		Path:      Paths{filepath.Join(source, "*", "*.log")},
+		Type:      "move",
+		Condition: &Condition{Destination: stringPtr(destination)},
+	})(context.Background())
+	if err != nil {
+		t.Fatalf("move error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(source, "app", "x.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected moved file to be removed, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destination, "app", "1", "x.log")); string(data) != "moved" {
+		t.Errorf("Expected moved file in a numbered directory next to the existing one, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destination, "app", "x.log")); string(data) != "existing" {
+		t.Errorf("Expected existing file to be kept, got %q", data)
+	}
+	if len(events) != 1 || events[0].Action != "moved" {
+		t.Errorf("Expected a moved event, got %+v", events)
+	}
+}
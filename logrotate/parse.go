@@ -0,0 +1,61 @@
+package logrotate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration parses an age such as "30m", "12h" or "7d".
+func ParseDuration(ageStr string) (time.Duration, error) {
+	if len(ageStr) < 2 {
+		return 0, fmt.Errorf("invalid age format: %s", ageStr)
+	}
+
+	unit := ageStr[len(ageStr)-1]
+	value := ageStr[:len(ageStr)-1]
+
+	ageValue, err := strconv.Atoi(value)
+	if err != nil || ageValue < 0 {
+		return 0, fmt.Errorf("invalid age value: %s", value)
+	}
+
+	switch unit {
+	case 'd':
+		return time.Duration(ageValue) * time.Hour * 24, nil
+	case 'h':
+		return time.Duration(ageValue) * time.Hour, nil
+	case 'm':
+		return time.Duration(ageValue) * time.Minute, nil
+	case 's':
+		return time.Duration(ageValue) * time.Second, nil
+	default:
+		return 0, fmt.Errorf("invalid age unit: %c", unit)
+	}
+}
+
+// ParseSize parses a size such as "500", "10KB", "5MB" or "1GB" into bytes.
+func ParseSize(sizeStr string) (int64, error) {
+	sizeStr = strings.ToUpper(strings.TrimSpace(sizeStr))
+	var multiplier int64 = 1
+
+	switch {
+	case strings.HasSuffix(sizeStr, "KB"):
+		multiplier = 1024
+		sizeStr = strings.TrimSuffix(sizeStr, "KB")
+	case strings.HasSuffix(sizeStr, "MB"):
+		multiplier = 1024 * 1024
+		sizeStr = strings.TrimSuffix(sizeStr, "MB")
+	case strings.HasSuffix(sizeStr, "GB"):
+		multiplier = 1024 * 1024 * 1024
+		sizeStr = strings.TrimSuffix(sizeStr, "GB")
+	}
+
+	sizeValue, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", sizeStr)
+	}
+
+	return sizeValue * multiplier, nil
+}
@@ -0,0 +1,73 @@
+package logrotate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		hasError bool
+	}{
+		{"1d", 24 * time.Hour, false},
+		{"1h", time.Hour, false},
+		{"30m", 30 * time.Minute, false},
+		{"15s", 15 * time.Second, false},
+		{"2h", 2 * time.Hour, false},
+		{"0m", 0, false},
+		{"invalid", 0, true}, // Invalid format
+		{"10x", 0, true},     // Invalid unit
+		{"", 0, true},        // Empty input
+		{"-5m", 0, true},     // Negative value
+		{"1h30m", 0, true},   // Unsupported combined format
+		{"1.5h", 0, true},    // Unsupported float format
+	}
+
+	for _, tt := range tests {
+		result, err := ParseDuration(tt.input)
+
+		if tt.hasError && err == nil {
+			t.Errorf("ParseDuration(%s) expected error, got none", tt.input)
+		}
+		if !tt.hasError && err != nil {
+			t.Errorf("ParseDuration(%s) unexpected error: %v", tt.input, err)
+		}
+		if result != tt.expected {
+			t.Errorf("ParseDuration(%s) = %v, expected %v", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"10KB", 10 * 1024, false},             // 10 KB
+		{"20MB", 20 * 1024 * 1024, false},      // 20 MB
+		{"5GB", 5 * 1024 * 1024 * 1024, false}, // 5 GB
+		{"100", 100, false},                    // 100 bytes (default to bytes if no suffix)
+		{"15kb", 15 * 1024, false},             // 15 kb (case insensitive)
+		{"2mb", 2 * 1024 * 1024, false},        // 2 mb (case insensitive)
+		{"1gb", 1024 * 1024 * 1024, false},     // 1 gb (case insensitive)
+		{"", 0, true},                          // Invalid: Empty input
+		{"10XB", 0, true},                      // Invalid: Unrecognized suffix
+		{"abc", 0, true},                       // Invalid: Non-numeric input
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+				return
+			}
+			if got != tt.expected {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
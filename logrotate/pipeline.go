@@ -0,0 +1,367 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Step actions.
+const (
+	actionRotate     = "rotate"
+	actionCompress   = "compress"
+	actionEncrypt    = "encrypt"
+	actionSign       = "sign"
+	actionUpload     = "upload"
+	actionPrune      = "prune"
+	actionDelete     = "delete"
+	actionCopy       = "copy"
+	actionMove       = "move"
+	actionTruncate   = "truncate"
+	actionArchive    = "archive"
+	actionCompact    = "compact"
+	actionRecompress = "recompress"
+)
+
+// Failure policies of a step.
+const (
+	onFailureAbort    = "abort"    // fail the run and stop processing the file
+	onFailureContinue = "continue" // fail the run but go on with the next step
+	onFailureSkip     = "skip"     // log a warning and go on with the next step
+)
+
+// Step is one action of the pipeline of a log entry. Every matching file goes
+// through the steps in order, each working on the file the previous one left,
+// e.g. the archive written by compress. A rotate, delete, copy, move,
+// truncate or in-place compress step whose conditions are not met ends the
+// pipeline for the file.
+//
+// The options of a step are the condition fields it uses: size, age and
+// chunk_size for rotate, age for delete, compression_format, verify,
+// checksum, redact, encrypt_to and sign_with for compress, plus size and age
+// in place, encrypt_to, checksum and sign_with for encrypt, sign_with for
+// sign, upload for upload, max_keep for prune, age, destination and conflict
+// for copy and move, size, age, keep_bytes and keep_lines for truncate, age,
+// archive_name, archive_format and verify for archive and period, age,
+// archive_name and archive_format for compact and age, compression_format,
+// compression_level, checksum and sign_with for recompress.
+//
+// A rotate step with a chunk_size splits the rotated file into chunks, which
+// go through the remaining steps one by one, prune last. An archive step
+// collects the files reaching it into one bundle per run, which goes through
+// the remaining steps once every file was processed; a compact step writes a
+// bundle per period, each going through the remaining steps.
+type Step struct {
+	Action    string `yaml:"action"`
+	OnFailure string `yaml:"on_failure,omitempty"` // abort, continue or skip, defaults to abort
+	Condition `yaml:",inline"`
+}
+
+// pipeline returns the steps of entry, the default ones of its type unless
+// it lists its own.
+func (entry *LogEntry) pipeline() []Step {
+	if len(entry.Steps) > 0 {
+		return entry.Steps
+	}
+
+	condition := Condition{}
+	if entry.Condition != nil {
+		condition = *entry.Condition
+	}
+
+	switch entry.Type {
+	case "delete", "copy", "move", "compress", "truncate", "recompress":
+		return []Step{{Action: entry.Type, Condition: condition}}
+	case "rotate", "archive", "compact":
+		steps := []Step{{Action: entry.Type, Condition: condition}}
+		if entry.Type == "rotate" && (condition.Compress == nil || *condition.Compress) {
+			steps = append(steps, Step{Action: actionCompress, Condition: condition})
+		}
+		if condition.Upload != nil {
+			steps = append(steps, Step{Action: actionUpload, OnFailure: onFailureContinue, Condition: condition})
+		}
+		if condition.MaxKeep != nil {
+			steps = append(steps, Step{Action: actionPrune, Condition: condition})
+		}
+		return steps
+	default:
+		return nil
+	}
+}
+
+// validateSteps checks the actions, failure policies and required options of
+// the steps of entry.
+func (entry *LogEntry) validateSteps() error {
+	if len(entry.Steps) > 0 && entry.Type != "pipeline" {
+		return fmt.Errorf("steps need type pipeline, not %s", entry.Type)
+	}
+
+	bundling := 0
+	for i, step := range entry.pipeline() {
+		switch step.OnFailure {
+		case "", onFailureAbort, onFailureContinue, onFailureSkip:
+		default:
+			return fmt.Errorf("step %d: unknown failure policy: %s", i+1, step.OnFailure)
+		}
+
+		var missing string
+		switch step.Action {
+		case actionDelete:
+		case actionRotate:
+			if step.ChunkSize != nil {
+				chunkSize, err := ParseSize(*step.ChunkSize)
+				if err != nil {
+					return fmt.Errorf("step %d: invalid chunk_size: %v", i+1, err)
+				}
+				if chunkSize <= 0 {
+					return fmt.Errorf("step %d: chunk_size must be positive", i+1)
+				}
+			}
+		case actionTruncate:
+			if step.KeepBytes != nil && step.KeepLines != nil {
+				return fmt.Errorf("step %d: truncate step takes keep_bytes or keep_lines, not both", i+1)
+			}
+			if step.KeepBytes != nil {
+				if _, err := ParseSize(*step.KeepBytes); err != nil {
+					return fmt.Errorf("step %d: invalid keep_bytes: %v", i+1, err)
+				}
+			}
+			if step.KeepLines != nil && *step.KeepLines < 0 {
+				return fmt.Errorf("step %d: keep_lines must not be negative", i+1)
+			}
+		case actionCompress, actionRecompress:
+			if _, err := compileRules(step.Redact); err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+			if err := validCompression(compressionFormat(&step.Condition), compressOptionsOf(&step.Condition).Level); err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+		case actionEncrypt:
+			if len(step.EncryptTo) == 0 {
+				missing = "encrypt_to"
+			}
+		case actionSign:
+			if step.SignWith == nil {
+				missing = "sign_with"
+			}
+		case actionUpload:
+			if step.Upload == nil {
+				missing = "upload"
+			}
+		case actionPrune:
+			if step.MaxKeep == nil {
+				missing = "max_keep"
+			}
+		case actionArchive, actionCompact:
+			if bundling++; bundling > 1 {
+				return fmt.Errorf("step %d: only one archive or compact step per pipeline", i+1)
+			}
+			if format := bundleFormat(&step); !validBundleFormat(format) {
+				return fmt.Errorf("step %d: unknown archive format: %s", i+1, format)
+			}
+			if step.Action == actionCompact {
+				if step.Period == nil {
+					missing = "period"
+				} else if *step.Period != periodDay && *step.Period != periodMonth {
+					return fmt.Errorf("step %d: unknown period: %s", i+1, *step.Period)
+				}
+			}
+		case actionCopy, actionMove:
+			if step.Destination == nil {
+				missing = "destination"
+			}
+			if step.Conflict != nil && *step.Conflict != conflictOverwrite && *step.Conflict != conflictSkip && *step.Conflict != conflictRename {
+				return fmt.Errorf("step %d: unknown conflict policy: %s", i+1, *step.Conflict)
+			}
+		default:
+			return fmt.Errorf("step %d: unknown action: %s", i+1, step.Action)
+		}
+		if missing != "" {
+			return fmt.Errorf("step %d: %s step needs %s", i+1, step.Action, missing)
+		}
+	}
+	return nil
+}
+
+// pipelineFile is a file going through the steps of a pipeline.
+type pipelineFile struct {
+	original string   // path the file was matched by
+	path     string   // current path, e.g. of the archive once compressed
+	root     string   // directory of the path pattern above its wildcards
+	active   bool     // the newest matching file of its directory, see activeFiles
+	chunks   []string // chunks the file was split into, which replace it
+	siblings string   // glob of the files pruned along with it, if not its rotated copies
+}
+
+// stepError is a failed step, reported according to its failure policy.
+type stepError struct {
+	kind string
+	msg  string
+	path string
+	err  error
+}
+
+func (e *stepError) Error() string {
+	return fmt.Sprintf("%s: %v", e.msg, e.err)
+}
+
+func stepFailed(kind, msg, path string, err error) error {
+	return &stepError{kind: kind, msg: msg, path: path, err: err}
+}
+
+// runSteps runs steps on every file matched by logEntry. The steps after an
+// archive or compact step run on the bundles of the files collected by it.
+func (e *Engine) runSteps(ctx context.Context, logEntry LogEntry, action string, steps []Step) error {
+	run := e.newTaskRun(logEntry, action)
+
+	var bundleSteps []Step
+	for i := range steps {
+		if steps[i].Action == actionArchive || steps[i].Action == actionCompact {
+			steps, bundleSteps = steps[:i+1], steps[i+1:]
+			break
+		}
+	}
+
+	files := e.matchFiles(run, logEntry)
+	var active map[string]bool
+	if len(steps) > 0 && (steps[0].Action == actionCompress || steps[0].Action == actionArchive) {
+		active = e.activeFiles(files)
+	}
+	e.processFiles(ctx, run, files, func(file string) {
+		e.runFileSteps(ctx, run, steps, &pipelineFile{original: file, path: file, root: matchRoot(logEntry.Path, file), active: active[file]})
+	})
+
+	if len(run.bundled) > 0 && ctx.Err() == nil {
+		e.runBundleSteps(ctx, run, logEntry, &steps[len(steps)-1], bundleSteps)
+	}
+	return run.err()
+}
+
+// runBundleSteps archives or compacts the files collected by step and runs
+// steps on the bundles. Files are bundled in name order, with their paths
+// relative to the glob root of the first path of logEntry.
+func (e *Engine) runBundleSteps(ctx context.Context, run *taskRun, logEntry LogEntry, step *Step, steps []Step) {
+	files := run.bundled
+	slices.Sort(files)
+	root := globRoot(logEntry.Path[0])
+
+	var bundles []*pipelineFile
+	var err error
+	if step.Action == actionCompact {
+		bundles, err = e.compactFiles(ctx, run, step, root, files)
+	} else {
+		var bundled *pipelineFile
+		if bundled, err = e.archiveFiles(ctx, run, step, root, files); bundled != nil {
+			bundles = append(bundles, bundled)
+		}
+	}
+
+	// Bundles written before a non-aborting failure, or failing to remove
+	// some of their files, are in place and still go through the steps.
+	if err != nil && !reportStepError(run, step, root, err) {
+		return
+	}
+	for _, bundled := range bundles {
+		e.runFileSteps(ctx, run, steps, bundled)
+	}
+}
+
+func (e *Engine) runFileSteps(ctx context.Context, run *taskRun, steps []Step, file *pipelineFile) {
+	for i := range steps {
+		step := &steps[i]
+		proceed, err := e.runStep(ctx, run, step, file)
+		if err != nil {
+			if reportStepError(run, step, file.path, err) {
+				continue
+			}
+			return
+		}
+		if !proceed {
+			return
+		}
+		if len(file.chunks) > 0 {
+			e.runChunkSteps(ctx, run, steps[i+1:], file)
+			return
+		}
+	}
+}
+
+// reportStepError reports err of step on path according to its failure
+// policy and returns whether the remaining steps should run.
+func reportStepError(run *taskRun, step *Step, path string, err error) bool {
+	var failure *stepError
+	if !errors.As(err, &failure) {
+		failure = &stepError{kind: step.Action, msg: "Step failed", path: path, err: err}
+	}
+
+	switch step.OnFailure {
+	case onFailureSkip:
+		run.logger.Warn(failure.msg, "path", failure.path, "kind", failure.kind, "step", step.Action, "error", failure.err)
+		return true
+	case onFailureContinue:
+		run.fail(failure.kind, failure.msg, failure.path, failure.err)
+		return true
+	default:
+		run.fail(failure.kind, failure.msg, failure.path, failure.err)
+		return false
+	}
+}
+
+// runChunkSteps runs steps on every chunk of file. Prune steps, which work on
+// the directory rather than the file, run once after every chunk went through
+// the others, so they cannot remove chunks yet to be processed.
+func (e *Engine) runChunkSteps(ctx context.Context, run *taskRun, steps []Step, file *pipelineFile) {
+	var chunkSteps, pruneSteps []Step
+	for _, step := range steps {
+		if step.Action == actionPrune {
+			pruneSteps = append(pruneSteps, step)
+		} else {
+			chunkSteps = append(chunkSteps, step)
+		}
+	}
+
+	for _, chunk := range file.chunks {
+		if ctx.Err() != nil {
+			run.fail("cancelled", "Task cancelled", chunk, ctx.Err())
+			return
+		}
+		e.runFileSteps(ctx, run, chunkSteps, &pipelineFile{original: file.original, path: chunk, root: file.root})
+	}
+	e.runFileSteps(ctx, run, pruneSteps, &pipelineFile{original: file.original, path: file.path, root: file.root})
+}
+
+// runStep runs step on file. It returns false if the remaining steps should
+// not run as the file did not meet the conditions of the step.
+func (e *Engine) runStep(ctx context.Context, run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	switch step.Action {
+	case actionRotate:
+		return e.rotateStep(ctx, run, step, file)
+	case actionCompress:
+		return e.compressStep(ctx, run, step, file)
+	case actionEncrypt:
+		return true, e.encryptStep(run, step, file)
+	case actionSign:
+		return true, e.signStep(ctx, run, step, file)
+	case actionUpload:
+		return true, e.uploadArchive(ctx, run, step.Upload, file.path)
+	case actionPrune:
+		return true, e.pruneStep(run, step, file)
+	case actionDelete:
+		return e.deleteStep(run, step, file)
+	case actionCopy:
+		return e.copyStep(run, step, file)
+	case actionMove:
+		return e.moveStep(run, step, file)
+	case actionTruncate:
+		return e.truncateStep(run, step, file)
+	case actionArchive:
+		return e.archiveStep(run, step, file)
+	case actionCompact:
+		return e.compactStep(run, file)
+	case actionRecompress:
+		return e.recompressStep(ctx, run, step, file)
+	default:
+		return false, stepFailed("config", "Unknown step", file.path, fmt.Errorf("unknown action: %s", step.Action))
+	}
+}
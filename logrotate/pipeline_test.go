@@ -0,0 +1,278 @@
+package logrotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunPipeline(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log")
+	identity, recipient := generateTestKey(t)
+
+	var mu sync.Mutex
+	var actions []string
+	clock := &fakeClock{now: time.Date(2024, 9, 13, 12, 0, 0, 0, time.UTC)}
+	engine := New(Config{}, Options{Clock: clock, Hooks: Hooks{OnFile: func(event FileEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		actions = append(actions, event.Action)
+	}}})
+
+	config, err := ParseConfig([]byte(`
+schedule: "@daily"
+logs:
+  - name: app
+    path: ` + filepath.Join(tempDir, "*.log") + `
+    steps:
+      - action: rotate
+        size: 1
+      - action: compress
+        checksum: true
+      - action: encrypt
+        checksum: true
+        encrypt_to:
+          - |
+` + indent(recipient, "            ") + `
+      - action: prune
+        max_keep: 1
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error: %v", err)
+	}
+	logEntry := config.Logs[0]
+	if logEntry.Type != "pipeline" {
+		t.Errorf("Expected entry with steps to default to type pipeline, got %s", logEntry.Type)
+	}
+
+	for i, content := range []string{"first\n", "second\n"} {
+		_ = os.WriteFile(file, []byte(content), 0644)
+		clock.now = clock.now.Add(time.Duration(i) * time.Hour)
+		if err := engine.task(logEntry)(context.Background()); err != nil {
+			t.Fatalf("task() error: %v", err)
+		}
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(tempDir, "app.log.*"))
+	want := []string{
+		filepath.Join(tempDir, "app.log.20240913-130000.gz.enc"),
+		filepath.Join(tempDir, "app.log.20240913-130000.gz.enc.sha256"),
+	}
+	if !reflect.DeepEqual(archives, want) {
+		t.Fatalf("Expected %v after pruning, got %v", want, archives)
+	}
+	if err := verifyChecksum(context.Background(), OSFS{}, want[0]); err != nil {
+		t.Errorf("Expected checksum of the encrypted archive, got %v", err)
+	}
+
+	decrypted, err := DecryptFile(want[0], identity)
+	if err != nil {
+		t.Fatalf("DecryptFile() error: %v", err)
+	}
+	gzipFile, _ := os.Open(decrypted)
+	defer gzipFile.Close()
+	gzipReader, err := gzip.NewReader(gzipFile)
+	if err != nil {
+		t.Fatalf("Expected gzip archive inside encryption, got %v", err)
+	}
+	if content, _ := io.ReadAll(gzipReader); string(content) != "second\n" {
+		t.Errorf("Expected archived content second, got %q", content)
+	}
+
+	wantActions := []string{"rotated", "compressed", "encrypted", "rotated", "compressed", "encrypted", "pruned"}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Errorf("Expected actions %v, got %v", wantActions, actions)
+	}
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix)
+}
+
+func TestRunPipelineFailurePolicies(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantErr     bool
+		wantArchive bool
+	}{
+		{policy: "", wantErr: true, wantArchive: false},
+		{policy: onFailureAbort, wantErr: true, wantArchive: false},
+		{policy: onFailureContinue, wantErr: true, wantArchive: true},
+		{policy: onFailureSkip, wantErr: false, wantArchive: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			tempDir := t.TempDir()
+			_ = os.WriteFile(filepath.Join(tempDir, "app.log"), []byte("log line\n"), 0644)
+
+			var logs bytes.Buffer
+			engine := New(Config{}, Options{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+			err := engine.runPipeline(context.Background(), LogEntry{
+				Name: "app",
+				Path: Paths{filepath.Join(tempDir, "*.log")},
+				Type: "pipeline",
+				Steps: []Step{
+					{Action: actionRotate, Condition: Condition{Size: stringPtr("1")}},
+					{Action: actionSign, OnFailure: tt.policy, Condition: Condition{SignWith: stringPtr(filepath.Join(tempDir, "missing.key"))}},
+					{Action: actionCompress},
+				},
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			archives, _ := filepath.Glob(filepath.Join(tempDir, "*.gz"))
+			if (len(archives) == 1) != tt.wantArchive {
+				t.Errorf("Expected archive %v, found %v", tt.wantArchive, archives)
+			}
+			if tt.policy == onFailureSkip && !strings.Contains(logs.String(), "level=WARN") {
+				t.Errorf("Expected skipped failure to be logged as a warning, got %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   LogEntry
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			entry: LogEntry{Type: "pipeline", Steps: []Step{{Action: actionRotate}, {Action: actionCompress, OnFailure: onFailureContinue}, {Action: actionPrune, Condition: Condition{MaxKeep: intPtr(3)}}}},
+		},
+		{
+			name:    "steps of rotate entry",
+			entry:   LogEntry{Type: "rotate", Steps: []Step{{Action: actionRotate}}},
+			wantErr: "steps need type pipeline",
+		},
+		{
+			name:    "unknown action",
+			entry:   LogEntry{Type: "pipeline", Steps: []Step{{Action: "shred"}}},
+			wantErr: "step 1: unknown action: shred",
+		},
+		{
+			name:    "unknown policy",
+			entry:   LogEntry{Type: "pipeline", Steps: []Step{{Action: actionRotate, OnFailure: "retry"}}},
+			wantErr: "step 1: unknown failure policy: retry",
+		},
+		{
+			name:    "missing option",
+			entry:   LogEntry{Type: "pipeline", Steps: []Step{{Action: actionRotate}, {Action: actionUpload}}},
+			wantErr: "step 2: upload step needs upload",
+		},
+		{
+			name:    "copy without destination",
+			entry:   LogEntry{Type: "copy", Condition: &Condition{Age: stringPtr("1d")}},
+			wantErr: "step 1: copy step needs destination",
+		},
+		{
+			name:    "unknown conflict policy",
+			entry:   LogEntry{Type: "move", Condition: &Condition{Destination: stringPtr("D:\\logs"), Conflict: stringPtr("merge")}},
+			wantErr: "step 1: unknown conflict policy: merge",
+		},
+		{
+			name:    "truncate keeping bytes and lines",
+			entry:   LogEntry{Type: "truncate", Condition: &Condition{KeepBytes: stringPtr("1MB"), KeepLines: intPtr(10)}},
+			wantErr: "step 1: truncate step takes keep_bytes or keep_lines, not both",
+		},
+		{
+			name:    "invalid chunk size",
+			entry:   LogEntry{Type: "rotate", Condition: &Condition{ChunkSize: stringPtr("lots")}},
+			wantErr: "step 1: invalid chunk_size",
+		},
+		{
+			name:    "zero chunk size",
+			entry:   LogEntry{Type: "rotate", Condition: &Condition{ChunkSize: stringPtr("0")}},
+			wantErr: "step 1: chunk_size must be positive",
+		},
+		{
+			name:    "negative chunk size",
+			entry:   LogEntry{Type: "rotate", Condition: &Condition{ChunkSize: stringPtr("-1MB")}},
+			wantErr: "step 1: chunk_size must be positive",
+		},
+		{
+			name:    "unknown archive format",
+			entry:   LogEntry{Type: "archive", Condition: &Condition{ArchiveFormat: stringPtr("rar")}},
+			wantErr: "step 1: unknown archive format: rar",
+		},
+		{
+			name:    "two archive steps",
+			entry:   LogEntry{Type: "pipeline", Steps: []Step{{Action: actionArchive}, {Action: actionArchive}}},
+			wantErr: "step 2: only one archive or compact step per pipeline",
+		},
+		{
+			name:    "compact without period",
+			entry:   LogEntry{Type: "compact"},
+			wantErr: "step 1: compact step needs period",
+		},
+		{
+			name:    "unknown period",
+			entry:   LogEntry{Type: "compact", Condition: &Condition{Period: stringPtr("week")}},
+			wantErr: "step 1: unknown period: week",
+		},
+		{
+			name:    "invalid compression level",
+			entry:   LogEntry{Type: "recompress", Condition: &Condition{CompressionFormat: stringPtr("gzip"), CompressionLevel: intPtr(19)}},
+			wantErr: "step 1: compression level 19 of gzip not between 1 and 9",
+		},
+		{
+			name:    "invalid redact rule",
+			entry:   LogEntry{Type: "pipeline", Steps: []Step{{Action: actionCompress, Condition: Condition{Redact: []RedactRule{{Pattern: "("}}}}}},
+			wantErr: "step 1: invalid redact pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.entry.validateSteps()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDefaultPipelines(t *testing.T) {
+	actionsOf := func(entry LogEntry) []string {
+		var actions []string
+		for _, step := range entry.pipeline() {
+			actions = append(actions, step.Action+"/"+step.OnFailure)
+		}
+		return actions
+	}
+
+	tests := []struct {
+		entry LogEntry
+		want  []string
+	}{
+		{LogEntry{Type: "delete"}, []string{"delete/"}},
+		{LogEntry{Type: "rotate"}, []string{"rotate/", "compress/"}},
+		{LogEntry{Type: "rotate", Condition: &Condition{Compress: boolPtr(false), MaxKeep: intPtr(2)}}, []string{"rotate/", "prune/"}},
+		{LogEntry{Type: "rotate", Condition: &Condition{Upload: &Upload{}, MaxKeep: intPtr(2)}}, []string{"rotate/", "compress/", "upload/continue", "prune/"}},
+		{LogEntry{Type: "archive", Condition: &Condition{MaxKeep: intPtr(2)}}, []string{"archive/", "prune/"}},
+		{LogEntry{Type: "unknown"}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := actionsOf(tt.entry); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expected pipeline %v of %+v, got %v", tt.want, tt.entry, got)
+		}
+	}
+}
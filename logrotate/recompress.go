@@ -0,0 +1,302 @@
+package logrotate
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdSkippableMagic starts the skippable frame the compression level of a
+// zstd archive is recorded in, which decoders pass over.
+const zstdSkippableMagic = 0x184D2A50
+
+// validCompression checks that format is one archives can be compressed in
+// and level one of its levels, 0 meaning its default.
+func validCompression(format string, level int) error {
+	maxLevel := flate.BestCompression
+	switch format {
+	case "gzip", "zip":
+	case "zstd":
+		maxLevel = 22
+	default:
+		return fmt.Errorf("unsupported compression format: %s", format)
+	}
+	if level < 0 || level > maxLevel {
+		return fmt.Errorf("compression level %d of %s not between 1 and %d", level, format, maxLevel)
+	}
+	return nil
+}
+
+func deflateLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+func zstdLevel(level int) zstd.EncoderLevel {
+	if level == 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(level)
+}
+
+// levelComment is the comment of gzip and zip archives recording the level
+// they were compressed at.
+func levelComment(level int) string {
+	return fmt.Sprintf("wingologrotate level=%d", level)
+}
+
+func parseLevelComment(comment string) int {
+	var level int
+	if _, err := fmt.Sscanf(comment, "wingologrotate level=%d", &level); err != nil {
+		return 0
+	}
+	return level
+}
+
+// writeLevelFrame writes the skippable frame recording level at the start of
+// a zstd archive.
+func writeLevelFrame(w io.Writer, level int) error {
+	comment := levelComment(level)
+	frame := binary.LittleEndian.AppendUint32(nil, zstdSkippableMagic)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(comment)))
+	_, err := w.Write(append(frame, comment...))
+	return err
+}
+
+// archiveFormat returns the compression format of the single-file archive
+// name, or "" for anything else, including encrypted archives and bundles.
+func archiveFormat(name string) string {
+	if strings.Contains(name, ".tar.") {
+		return ""
+	}
+	switch filepath.Ext(name) {
+	case ".gz":
+		return "gzip"
+	case ".zip":
+		return "zip"
+	case ".zst":
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// archiveLevel returns the compression level recorded in the archive at path,
+// or 0 if none is.
+func archiveLevel(fsys FS, path, format string) (int, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read gzip archive: %v", err)
+		}
+		return parseLevelComment(gzipReader.Comment), nil
+	case "zip":
+		readerAt, ok := file.(io.ReaderAt)
+		if !ok {
+			return 0, fmt.Errorf("zip archive %s does not support random access", path)
+		}
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get archive info: %v", err)
+		}
+		archive, err := zip.NewReader(readerAt, fileInfo.Size())
+		if err != nil {
+			return 0, fmt.Errorf("failed to read zip archive: %v", err)
+		}
+		return parseLevelComment(archive.Comment), nil
+	case "zstd":
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(file, header); err != nil || binary.LittleEndian.Uint32(header) != zstdSkippableMagic {
+			return 0, nil
+		}
+		size := binary.LittleEndian.Uint32(header[4:])
+		if size > 64 {
+			return 0, nil
+		}
+		comment := make([]byte, size)
+		if _, err := io.ReadFull(file, comment); err != nil {
+			return 0, nil
+		}
+		return parseLevelComment(string(comment)), nil
+	default:
+		return 0, fmt.Errorf("unsupported compression format: %s", format)
+	}
+}
+
+// decompressArchive writes the content of the single-file archive at path to
+// target, under a temporary name renamed into place once synced.
+func decompressArchive(ctx context.Context, fsys FS, path, format, target string) error {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	content, closeContent, err := openArchive(file, path, format)
+	if err != nil {
+		return err
+	}
+	defer closeContent()
+
+	output, err := fsys.Create(tempName(target))
+	if err != nil {
+		return fmt.Errorf("failed to create decompressed file: %v", err)
+	}
+	_, err = io.Copy(output, &contextReader{ctx: ctx, r: content})
+	if err == nil {
+		err = output.Sync()
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Rename(tempName(target), target)
+	}
+	if err != nil {
+		fsys.Remove(tempName(target))
+		return fmt.Errorf("failed to decompress archive: %v", err)
+	}
+	return nil
+}
+
+// recompressStep transcodes the archive to the compression format and level
+// of step once it is older than the age of step. Archives in that format
+// already are left alone, unless step has a level and the archive records
+// another one. The archive is decompressed next to itself and compressed
+// again like a rotated file, verified, with a checksum if it had one and
+// signed if step signs, and keeps its modification time. Encrypted archives
+// and bundles are left alone.
+func (e *Engine) recompressStep(ctx context.Context, run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	format := archiveFormat(filepath.Base(file.path))
+	if format == "" {
+		return false, nil
+	}
+	fileInfo, due, err := e.dueByAge(step, file)
+	if err != nil || !due {
+		return false, err
+	}
+
+	target := compressionFormat(&step.Condition)
+	options := compressOptionsOf(&step.Condition)
+	if format == target {
+		level, err := archiveLevel(e.fs, file.path, format)
+		if err != nil {
+			return false, stepFailed("recompress", "Failed to read archive", file.path, err)
+		}
+		if step.CompressionLevel == nil || level == options.Level {
+			return false, nil
+		}
+	}
+
+	// Redaction was applied when the archive was written.
+	options.Redact = nil
+	options.Verify = true
+	sidecars := make(map[string][]byte)
+	for _, suffix := range sidecarSuffixes {
+		data, err := readFile(e.fs, file.path+suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, stepFailed("recompress", "Failed to read archive sidecar", file.path+suffix, err)
+		}
+		sidecars[suffix] = data
+	}
+	if _, ok := sidecars[checksumSuffix]; ok {
+		options.Checksum = true
+	}
+
+	plain := strings.TrimSuffix(file.path, filepath.Ext(file.path))
+	if _, err := e.fs.Stat(plain); err == nil {
+		return false, stepFailed("recompress", "Decompressed archive name is taken", plain, os.ErrExist)
+	}
+	if err := decompressArchive(ctx, e.fs, file.path, format, plain); err != nil {
+		return false, stepFailed("recompress", "Failed to decompress archive", file.path, err)
+	}
+
+	result, err := e.compress(ctx, run, plain, target, options)
+	if err != nil {
+		e.fs.Remove(plain)
+		// Compressing to the name of the archive removes its sidecars on
+		// failure, they still belong to the archive left in place.
+		restoreErr := restoreSidecars(e.fs, file.path, sidecars)
+		return false, stepFailed("recompress", "Failed to recompress archive", file.path, errors.Join(err, restoreErr))
+	}
+
+	if result.Path != file.path {
+		if err := e.fs.Remove(file.path); err != nil {
+			return false, stepFailed("recompress", "Failed to remove recompressed archive", file.path, err)
+		}
+	}
+	for suffix := range sidecars {
+		rewritten := suffix == checksumSuffix && options.Checksum || suffix == signatureSuffix && options.SignWith != ""
+		if rewritten && result.Path == file.path {
+			continue
+		}
+		if err := e.fs.Remove(file.path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, stepFailed("recompress", "Failed to remove stale sidecar", file.path+suffix, err)
+		}
+		if suffix == signatureSuffix && options.SignWith == "" {
+			run.logger.Warn("Dropped signature of recompressed archive, no key to sign it with", "path", result.Path)
+		}
+	}
+	if err := e.fs.Chtimes(result.Path, fileInfo.ModTime(), fileInfo.ModTime()); err != nil {
+		return false, stepFailed("recompress", "Failed to keep modification time of archive", result.Path, err)
+	}
+
+	run.logger.Info("Recompressed archive", "path", file.path, "archive", result.Path, "format", target, "level", options.Level, "bytes", fileInfo.Size(), "archive_bytes", result.Size)
+	run.done(FileEvent{Action: "recompressed", Path: file.path, NewPath: result.Path, Size: fileInfo.Size(), NewSize: result.Size})
+	file.path = result.Path
+	return true, nil
+}
+
+// restoreSidecars writes back the sidecars of archivePath missing after a
+// failed recompression.
+func restoreSidecars(fsys FS, archivePath string, sidecars map[string][]byte) error {
+	var errs []error
+	for suffix, data := range sidecars {
+		sidecar := archivePath + suffix
+		if _, err := fsys.Stat(sidecar); err == nil {
+			continue
+		}
+		file, err := fsys.Create(tempName(sidecar))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %v", sidecar, err))
+			continue
+		}
+		_, err = file.Write(data)
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = fsys.Rename(tempName(sidecar), sidecar)
+		}
+		if err != nil {
+			fsys.Remove(tempName(sidecar))
+			errs = append(errs, fmt.Errorf("failed to restore %s: %v", sidecar, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+package logrotate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchiveLevel(t *testing.T) {
+	for _, format := range []string{"gzip", "zip", "zstd"} {
+		for _, level := range []int{0, 1, 9} {
+			file := filepath.Join(t.TempDir(), "app.log")
+			_ = os.WriteFile(file, []byte("log line\n"), 0644)
+
+			result, err := compressFile(context.Background(), OSFS{}, file, format, compressOptions{Verify: true, Level: level})
+			if err != nil {
+				t.Fatalf("compressFile() with %s level %d error: %v", format, level, err)
+			}
+			if got, err := archiveLevel(OSFS{}, result.Path, format); err != nil || got != level {
+				t.Errorf("Expected %s archive to record level %d, got %d, %v", format, level, got, err)
+			}
+		}
+	}
+}
+
+func TestRecompressFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition Condition
+		want      string
+	}{
+		{name: "to another format", condition: Condition{CompressionFormat: stringPtr("zstd"), CompressionLevel: intPtr(19)}, want: "app.log.1.zst"},
+		{name: "to another level", condition: Condition{CompressionLevel: intPtr(9)}, want: "app.log.1.gz"},
+	}
+
+	now := time.Date(2024, 9, 13, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			content := []byte("log line\nanother log line\n")
+			compressed := func(name string, age time.Duration) string {
+				path := filepath.Join(tempDir, name)
+				_ = os.WriteFile(path, content, 0644)
+				result, err := compressFile(context.Background(), OSFS{}, path, "gzip", compressOptions{Checksum: true, Level: 1})
+				if err != nil {
+					t.Fatalf("compressFile() error: %v", err)
+				}
+				_ = os.Chtimes(result.Path, now.Add(-age), now.Add(-age))
+				return result.Path
+			}
+			old := compressed("app.log.1", 40*24*time.Hour)
+			fresh := compressed("app.log.2", time.Hour)
+
+			var events []FileEvent
+			engine := New(Config{}, Options{Clock: &fakeClock{now: now}, Hooks: Hooks{OnFile: func(event FileEvent) { events = append(events, event) }}})
+			condition := tt.condition
+			condition.Age = stringPtr("30d")
+			entry := LogEntry{Name: "app", Path: Paths{filepath.Join(tempDir, "app.log.*")}, Type: "recompress", Condition: &condition}
+			for i := 0; i < 2; i++ {
+				if err := engine.task(entry)(context.Background()); err != nil {
+					t.Fatalf("task() error: %v", err)
+				}
+			}
+
+			want := filepath.Join(tempDir, tt.want)
+			format := archiveFormat(want)
+			decompressed := filepath.Join(tempDir, "decompressed.log")
+			if err := decompressArchive(context.Background(), OSFS{}, want, format, decompressed); err != nil {
+				t.Fatalf("Expected recompressed archive, got %v", err)
+			}
+			if got, _ := os.ReadFile(decompressed); !reflect.DeepEqual(got, content) {
+				t.Errorf("Expected recompressed content %q, got %q", content, got)
+			}
+			if level, _ := archiveLevel(OSFS{}, want, format); level != *tt.condition.CompressionLevel {
+				t.Errorf("Expected level %d recorded, got %d", *tt.condition.CompressionLevel, level)
+			}
+			if err := verifyChecksum(context.Background(), OSFS{}, want); err != nil {
+				t.Errorf("Expected checksum of the recompressed archive, got %v", err)
+			}
+			if fileInfo, _ := os.Stat(want); !fileInfo.ModTime().Equal(now.Add(-40 * 24 * time.Hour)) {
+				t.Errorf("Expected modification time kept, got %v", fileInfo.ModTime())
+			}
+			if want != old {
+				for _, stale := range []string{old, old + checksumSuffix} {
+					if _, err := os.Stat(stale); !os.IsNotExist(err) {
+						t.Errorf("Expected %s to be removed, got %v", stale, err)
+					}
+				}
+			}
+			if level, _ := archiveLevel(OSFS{}, fresh, "gzip"); level != 1 {
+				t.Errorf("Expected fresh archive left alone, got level %d", level)
+			}
+
+			if len(events) != 1 || events[0].Action != "recompressed" || events[0].NewPath != want {
+				t.Errorf("Expected a single recompression to %s, got %+v", want, events)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package logrotate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// maxLineSize is the longest line redaction rules see at once. Longer lines
+// are processed in pieces of this size, so memory stays flat whatever the
+// input; a match spanning two pieces is missed.
+const maxLineSize = 1024 * 1024
+
+const defaultRedaction = "[REDACTED]"
+
+// RedactRule masks or drops the lines of rotated files matching Pattern
+// before they are compressed. Rules apply in order: a dropped line is not
+// seen by later rules, a masked line is seen with the earlier masks applied.
+type RedactRule struct {
+	Pattern string  `yaml:"pattern" json:"pattern"`                     // regular expression, RE2 syntax
+	Replace *string `yaml:"replace,omitempty" json:"replace,omitempty"` // replacement of every match, may refer to groups as ${1}; defaults to [REDACTED]
+	Drop    bool    `yaml:"drop,omitempty" json:"drop,omitempty"`       // drop lines matching the pattern instead of masking
+}
+
+type compiledRule struct {
+	re      *regexp.Regexp
+	replace []byte
+	drop    bool
+}
+
+func compileRules(rules []RedactRule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", rule.Pattern, err)
+		}
+		replace := defaultRedaction
+		if rule.Replace != nil {
+			replace = *rule.Replace
+		}
+		compiled = append(compiled, compiledRule{re: re, replace: []byte(replace), drop: rule.Drop})
+	}
+	return compiled, nil
+}
+
+// redactStats counts the lines redaction changed.
+type redactStats struct {
+	Redacted int64 // lines with at least one match masked
+	Dropped  int64 // lines dropped
+}
+
+// redactReader applies redaction rules line by line to the stream it reads.
+type redactReader struct {
+	src   *bufio.Reader
+	rules []compiledRule
+	stats *redactStats
+
+	out      []byte // transformed data not read yet
+	dropping bool   // the rest of a dropped long line is dropped too
+	err      error
+}
+
+func newRedactReader(src io.Reader, rules []compiledRule, stats *redactStats) *redactReader {
+	return &redactReader{src: bufio.NewReaderSize(src, maxLineSize), rules: rules, stats: stats}
+}
+
+func (r *redactReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		line, err := r.src.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+		r.err = err
+		if len(line) > 0 {
+			r.out = r.transform(line)
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// transform returns the redacted line, or nothing if it is dropped. line may
+// be a piece of a long line, which is complete once it ends with a newline.
+func (r *redactReader) transform(line []byte) []byte {
+	complete := bytes.HasSuffix(line, []byte("\n"))
+	if r.dropping {
+		r.dropping = !complete
+		return nil
+	}
+
+	content := bytes.TrimSuffix(line, []byte("\n"))
+	redacted := false
+	for _, rule := range r.rules {
+		if !rule.re.Match(content) {
+			continue
+		}
+		if rule.drop {
+			r.stats.Dropped++
+			r.dropping = !complete
+			return nil
+		}
+		content = rule.re.ReplaceAll(content, rule.replace)
+		redacted = true
+	}
+
+	if redacted {
+		r.stats.Redacted++
+	}
+	out := append(r.out[:0], content...)
+	if complete {
+		out = append(out, '\n')
+	}
+	return out
+}
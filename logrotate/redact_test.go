@@ -0,0 +1,104 @@
+package logrotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactReader(t *testing.T) {
+	rules, err := compileRules([]RedactRule{
+		{Pattern: `DEBUG`, Drop: true},
+		{Pattern: `[\w.+-]+@[\w-]+\.[\w.]+`},
+		{Pattern: `\b(\d{4})[ -]?\d{4}[ -]?\d{4}[ -]?(\d{4})\b`, Replace: stringPtr("$1-XXXX-XXXX-$2")},
+		{Pattern: `token=\S+`, Replace: stringPtr("token=***")},
+	})
+	if err != nil {
+		t.Fatalf("compileRules() error: %v", err)
+	}
+
+	input := "INFO login user=jane@example.com\n" +
+		"DEBUG cache miss for jane@example.com\n" +
+		"INFO paid with 4111 1111 1111 1234 token=abc123\n" +
+		"INFO nothing to hide\n" +
+		"INFO no trailing newline a@b.io"
+	want := "INFO login user=[REDACTED]\n" +
+		"INFO paid with 4111-XXXX-XXXX-1234 token=***\n" +
+		"INFO nothing to hide\n" +
+		"INFO no trailing newline [REDACTED]"
+
+	var stats redactStats
+	got, err := io.ReadAll(newRedactReader(strings.NewReader(input), rules, &stats))
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+	if stats.Redacted != 3 || stats.Dropped != 1 {
+		t.Errorf("Expected 3 redacted and 1 dropped lines, got %+v", stats)
+	}
+}
+
+func TestRedactReaderLongLines(t *testing.T) {
+	rules, _ := compileRules([]RedactRule{{Pattern: `^DEBUG`, Drop: true}, {Pattern: `secret`}})
+
+	long := strings.Repeat("x", 3*maxLineSize)
+	input := "DEBUG " + long + "\nINFO secret " + long + "\nINFO done\n"
+
+	var stats redactStats
+	got, err := io.ReadAll(newRedactReader(strings.NewReader(input), rules, &stats))
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+	if !bytes.HasPrefix(got, []byte("INFO [REDACTED] xxx")) || !bytes.HasSuffix(got, []byte("x\nINFO done\n")) {
+		t.Errorf("Expected the long DEBUG line to be dropped entirely and the secret masked")
+	}
+	if len(got) != len("INFO [REDACTED] ")+len(long)+len("\nINFO done\n") {
+		t.Errorf("Expected long line to be kept whole, got %d bytes", len(got))
+	}
+	if stats.Dropped != 1 {
+		t.Errorf("Expected 1 dropped line, got %+v", stats)
+	}
+}
+
+func TestCompressFileRedacted(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log.1")
+	_ = os.WriteFile(file, []byte("DEBUG noise\nINFO mail bob@example.com\n"), 0644)
+
+	options := compressOptions{Verify: true, Redact: []RedactRule{{Pattern: `^DEBUG`, Drop: true}, {Pattern: `\S+@\S+`}}}
+	result, err := compressFile(context.Background(), OSFS{}, file, "gzip", options)
+	if err != nil {
+		t.Fatalf("compressFile() error: %v", err)
+	}
+	if result.Redacted != 1 || result.Dropped != 1 {
+		t.Errorf("Expected 1 redacted and 1 dropped line, got %+v", result)
+	}
+
+	gzipFile, _ := os.Open(result.Path)
+	defer gzipFile.Close()
+	gzipReader, err := gzip.NewReader(gzipFile)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if got, _ := io.ReadAll(gzipReader); string(got) != "INFO mail [REDACTED]\n" {
+		t.Errorf("Expected redacted content in archive, got %q", got)
+	}
+}
+
+func TestApplyDefaultsInvalidRedactPattern(t *testing.T) {
+	config := Config{Logs: []LogEntry{{
+		Name:      "app",
+		Type:      "rotate",
+		Condition: &Condition{Redact: []RedactRule{{Pattern: `(unclosed`}}},
+	}}}
+	if err := config.ApplyDefaults(); err == nil || !strings.Contains(err.Error(), "entry app") {
+		t.Errorf("Expected error for invalid redact pattern, got %v", err)
+	}
+}
@@ -0,0 +1,455 @@
+package logrotate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	defaultPartSize = 16 * 1024 * 1024
+	minPartSize     = 5 * 1024 * 1024 // smallest part S3 accepts but for the last one
+)
+
+// S3Target is an S3-compatible bucket archives are uploaded to, e.g. AWS S3
+// or MinIO.
+//
+// Credentials are read from CredentialsFile, an AWS shared credentials file,
+// or else from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables; they are never part of the
+// configuration itself.
+type S3Target struct {
+	Endpoint        string `yaml:"endpoint"`                   // e.g. https://s3.eu-west-1.amazonaws.com or http://127.0.0.1:9000
+	Region          string `yaml:"region,omitempty"`           // defaults to us-east-1
+	Bucket          string `yaml:"bucket"`                     // addressed path style, as in <endpoint>/<bucket>/<key>
+	Prefix          string `yaml:"prefix,omitempty"`           // key prefix, see Upload
+	CredentialsFile string `yaml:"credentials_file,omitempty"` // AWS shared credentials file
+	Profile         string `yaml:"profile,omitempty"`          // profile of the credentials file, defaults to default
+	PartSize        string `yaml:"part_size,omitempty"`        // size of multipart upload parts, defaults to 16MB
+}
+
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// loadS3Credentials reads the credentials of target from its credentials
+// file or the environment.
+func loadS3Credentials(fsys FS, target *S3Target) (s3Credentials, error) {
+	if target.CredentialsFile == "" {
+		credentials := s3Credentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if credentials.accessKeyID == "" || credentials.secretAccessKey == "" {
+			return s3Credentials{}, fmt.Errorf("no S3 credentials: set credentials_file or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return credentials, nil
+	}
+
+	data, err := readFile(fsys, target.CredentialsFile)
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("failed to read S3 credentials file: %v", err)
+	}
+
+	profile := target.Profile
+	if profile == "" {
+		profile = "default"
+	}
+
+	var credentials s3Credentials
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			credentials.accessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			credentials.secretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			credentials.sessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return s3Credentials{}, fmt.Errorf("failed to read S3 credentials file: %v", err)
+	}
+	if credentials.accessKeyID == "" || credentials.secretAccessKey == "" {
+		return s3Credentials{}, fmt.Errorf("no credentials for profile %s in %s", profile, target.CredentialsFile)
+	}
+	return credentials, nil
+}
+
+// s3Client uploads objects to a single bucket with AWS signature version 4.
+type s3Client struct {
+	endpoint    *url.URL
+	region      string
+	bucket      string
+	partSize    int64
+	credentials s3Credentials
+	http        *http.Client
+	retry       retryPolicy
+	now         func() time.Time
+}
+
+func newS3Client(fsys FS, target *S3Target, retry retryPolicy) (*s3Client, error) {
+	endpoint, err := url.Parse(target.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", target.Endpoint)
+	}
+	if target.Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket configured")
+	}
+
+	credentials, err := loadS3Credentials(fsys, target)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &s3Client{
+		endpoint:    endpoint,
+		region:      target.Region,
+		bucket:      target.Bucket,
+		partSize:    defaultPartSize,
+		credentials: credentials,
+		http:        &http.Client{Timeout: 10 * time.Minute},
+		retry:       retry,
+		now:         time.Now,
+	}
+	if client.region == "" {
+		client.region = defaultS3Region
+	}
+	if target.PartSize != "" {
+		if client.partSize, err = ParseSize(target.PartSize); err != nil {
+			return nil, fmt.Errorf("invalid S3 part size: %v", err)
+		}
+		if client.partSize < minPartSize {
+			return nil, fmt.Errorf("S3 part size must be at least 5MB")
+		}
+	}
+	return client, nil
+}
+
+func (c *s3Client) String() string {
+	return "s3://" + c.bucket
+}
+
+// upload stores the file at localPath as key, in a single request if it fits
+// a part and as a multipart upload otherwise, and confirms the stored size.
+func (c *s3Client) upload(ctx context.Context, fsys FS, localPath, key string) error {
+	file, err := fsys.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file for upload: %v", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	if fileInfo.Size() <= c.partSize {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return fmt.Errorf("failed to read file for upload: %v", err)
+		}
+		if _, err := c.do(ctx, http.MethodPut, key, nil, data); err != nil {
+			return err
+		}
+	} else if err := c.multipartUpload(ctx, file, key); err != nil {
+		return err
+	}
+
+	return c.confirm(ctx, key, fileInfo.Size())
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *s3Client) multipartUpload(ctx context.Context, file io.Reader, key string) error {
+	response, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(response.body, &initiated); err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("invalid response to multipart upload request: %v", err)
+	}
+
+	err = c.uploadParts(ctx, file, key, initiated.UploadID)
+	if err != nil {
+		// Do not leave the parts uploaded so far behind to be billed.
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		c.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil)
+	}
+	return err
+}
+
+func (c *s3Client) uploadParts(ctx context.Context, file io.Reader, key, uploadID string) error {
+	var parts []completedPart
+	buf := make([]byte, c.partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(file, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read file for upload: %v", err)
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		response, err := c.do(ctx, http.MethodPut, key, query, buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %v", number, err)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: response.header.Get("ETag")})
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode parts: %v", err)
+	}
+
+	response, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, complete)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+	// S3 may report a failure of the completion with a 200 response.
+	if bytes.Contains(response.body, []byte("<Error>")) {
+		return fmt.Errorf("failed to complete multipart upload: %s", s3ErrorMessage(response.body))
+	}
+	return nil
+}
+
+// confirm checks that key is stored with the expected size.
+func (c *s3Client) confirm(ctx context.Context, key string, size int64) error {
+	response, err := c.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to confirm upload: %v", err)
+	}
+	if stored := response.header.Get("Content-Length"); stored != strconv.FormatInt(size, 10) {
+		return fmt.Errorf("uploaded object has %s bytes, expected %d", stored, size)
+	}
+	return nil
+}
+
+type s3Response struct {
+	header http.Header
+	body   []byte
+}
+
+// do sends a signed request, retrying network errors and responses the
+// server may answer differently next time.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, body []byte) (*s3Response, error) {
+	var response *s3Response
+	err := c.retry.do(ctx, func() (bool, error) {
+		var err error
+		var retryable bool
+		response, retryable, err = c.send(ctx, method, key, query, body)
+		return retryable, err
+	})
+	return response, err
+}
+
+func (c *s3Client) send(ctx context.Context, method, key string, query url.Values, body []byte) (*s3Response, bool, error) {
+	requestURL := *c.endpoint
+	requestURL.Path = strings.TrimSuffix(c.endpoint.Path, "/") + "/" + c.bucket + "/" + key
+	requestURL.RawPath = strings.TrimSuffix(c.endpoint.EscapedPath(), "/") + "/" + s3Escape(c.bucket) + "/" + s3Escape(key)
+	requestURL.RawQuery = canonicalQuery(query)
+
+	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create S3 request: %v", err)
+	}
+	request.ContentLength = int64(len(body))
+	if body != nil {
+		sum := md5.Sum(body)
+		request.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	c.sign(request, body)
+
+	httpResponse, err := c.http.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, false, fmt.Errorf("S3 request failed: %v", err)
+		}
+		return nil, true, fmt.Errorf("%w: S3 request failed: %v", errUnreachable, err)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(httpResponse.Body, 1024*1024))
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read S3 response: %v", err)
+	}
+	if httpResponse.StatusCode >= 300 {
+		retryable := httpResponse.StatusCode >= 500 || httpResponse.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, fmt.Errorf("S3 %s %s: %s: %s", method, key, httpResponse.Status, s3ErrorMessage(responseBody))
+	}
+	return &s3Response{header: httpResponse.Header, body: responseBody}, false, nil
+}
+
+func s3ErrorMessage(body []byte) string {
+	var s3Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(body, &s3Error); err != nil || s3Error.Code == "" {
+		return strings.TrimSpace(string(body))
+	}
+	return s3Error.Code + ": " + s3Error.Message
+}
+
+// sign adds an AWS signature version 4 Authorization header to request.
+func (c *s3Client) sign(request *http.Request, body []byte) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if c.credentials.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", c.credentials.sessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.credentials.secretAccessKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.credentials.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes a key as signature version 4 expects: everything but
+// unreserved characters, keeping the slashes.
+func s3Escape(key string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(key) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key)+"="+strings.ReplaceAll(s3Escape(value), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// retryPolicy retries failed operations with exponential backoff.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration // before the second attempt, doubling after every attempt
+}
+
+// do runs op until it succeeds, fails with a permanent error or runs out of
+// attempts.
+func (p retryPolicy) do(ctx context.Context, op func() (retryable bool, err error)) error {
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := op()
+		if err == nil || !retryable || attempt >= p.attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
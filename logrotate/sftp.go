@@ -0,0 +1,251 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const defaultSFTPPort = 22
+
+// sftpConnectTimeout bounds connecting to the host and the SSH handshake.
+const sftpConnectTimeout = 30 * time.Second
+
+// defaultIdentityFiles are the private keys tried without an identity_file,
+// in the .ssh directory of the home directory, like by OpenSSH.
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// errUnreachable marks upload errors caused by the remote host not being
+// reachable, as opposed to it refusing the upload.
+var errUnreachable = errors.New("host unreachable")
+
+// SFTPTarget is a host archives are copied to over SFTP, authenticating with
+// a key. Files are written under a temporary name and renamed once complete,
+// so the host never sees a partial archive.
+type SFTPTarget struct {
+	Host           string `yaml:"host"`
+	Port           int    `yaml:"port,omitempty"`             // defaults to 22
+	User           string `yaml:"user,omitempty"`             // defaults to the user running the service
+	IdentityFile   string `yaml:"identity_file,omitempty"`    // unencrypted private key, defaults to the first of ~/.ssh/id_ed25519, id_ecdsa and id_rsa
+	KnownHostsFile string `yaml:"known_hosts_file,omitempty"` // host keys to check against, defaults to ~/.ssh/known_hosts; unknown hosts are refused
+	Path           string `yaml:"path,omitempty"`             // remote directory, see Upload; relative to the home directory unless absolute
+}
+
+// sftpClient uploads files over a connection per upload.
+type sftpClient struct {
+	target *SFTPTarget
+	retry  retryPolicy
+}
+
+func newSFTPClient(target *SFTPTarget, retry retryPolicy) (*sftpClient, error) {
+	if target.Host == "" {
+		return nil, fmt.Errorf("no SFTP host configured")
+	}
+	if target.Port < 0 || target.Port > 65535 {
+		return nil, fmt.Errorf("invalid SFTP port: %d", target.Port)
+	}
+	return &sftpClient{target: target, retry: retry}, nil
+}
+
+func (c *sftpClient) String() string {
+	destination := "sftp://" + c.target.Host
+	if c.target.User != "" {
+		destination = "sftp://" + c.target.User + "@" + c.target.Host
+	}
+	if c.target.Port != 0 {
+		destination += ":" + strconv.Itoa(c.target.Port)
+	}
+	return destination
+}
+
+// upload copies the file at localPath to the remote path key, creating its
+// directories. A partial file left by a failed attempt is overwritten by the
+// next one. Only failures to reach the host or losing the connection are
+// retried and wrap errUnreachable; a host refusing the key, presenting an
+// unknown host key or refusing the file fails right away.
+func (c *sftpClient) upload(ctx context.Context, fsys FS, localPath, key string) error {
+	if _, err := fsys.Stat(localPath); err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	config, err := c.clientConfig(fsys)
+	if err != nil {
+		return err
+	}
+	return c.retry.do(ctx, func() (bool, error) {
+		err := c.put(ctx, fsys, config, localPath, key)
+		return errors.Is(err, errUnreachable) && ctx.Err() == nil, err
+	})
+}
+
+// clientConfig returns the SSH configuration authenticating with the identity
+// file of the target and checking the host against its known hosts file.
+func (c *sftpClient) clientConfig(fsys FS) (*ssh.ClientConfig, error) {
+	username := c.target.User
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current user: %v", err)
+		}
+		// Windows reports users as DOMAIN\name.
+		username = current.Username[strings.LastIndex(current.Username, `\`)+1:]
+	}
+
+	signer, err := c.signer(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	knownHostsFile := c.target.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("no known_hosts_file configured: %v", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts file: %v", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpConnectTimeout,
+	}, nil
+}
+
+// signer reads the private key of the target, or the first default one
+// present.
+func (c *sftpClient) signer(fsys FS) (ssh.Signer, error) {
+	identityFiles := []string{c.target.IdentityFile}
+	if c.target.IdentityFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("no identity_file configured: %v", err)
+		}
+		identityFiles = nil
+		for _, name := range defaultIdentityFiles {
+			identityFiles = append(identityFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	for _, identityFile := range identityFiles {
+		data, err := readFile(fsys, identityFile)
+		if errors.Is(err, os.ErrNotExist) && c.target.IdentityFile == "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse identity file %s: %v", identityFile, err)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("no identity_file configured and none of %s found in ~/.ssh", strings.Join(defaultIdentityFiles, ", "))
+}
+
+// put uploads localPath as key over a new connection.
+func (c *sftpClient) put(ctx context.Context, fsys FS, config *ssh.ClientConfig, localPath, key string) error {
+	client, err := c.dial(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := sftp.NewClient(client)
+	if err != nil {
+		return c.failed("failed to start SFTP session", err)
+	}
+	defer session.Close()
+
+	if dir := path.Dir(key); dir != "." && dir != "/" {
+		if err := session.MkdirAll(dir); err != nil {
+			return c.failed("failed to create remote directory", err)
+		}
+	}
+
+	local, err := fsys.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer local.Close()
+
+	tempPath := tempName(key)
+	remote, err := session.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return c.failed("failed to create remote file", err)
+	}
+	_, err = io.Copy(remote, &contextReader{ctx: ctx, r: local})
+	if closeErr := remote.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return c.failed("failed to write remote file", err)
+	}
+
+	if err := session.PosixRename(tempPath, key); err != nil {
+		return c.failed("failed to rename remote file", err)
+	}
+	return nil
+}
+
+// dial connects to the host and authenticates. The connection is closed once
+// ctx is done.
+func (c *sftpClient) dial(ctx context.Context, config *ssh.ClientConfig) (*ssh.Client, error) {
+	port := c.target.Port
+	if port == 0 {
+		port = defaultSFTPPort
+	}
+	address := net.JoinHostPort(c.target.Host, strconv.Itoa(port))
+
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, c.failed("failed to connect", err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	_ = conn.SetDeadline(time.Now().Add(config.Timeout))
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, c.failed("failed to log in", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	client := ssh.NewClient(sshConn, channels, requests)
+	go func() {
+		client.Wait()
+		stop()
+	}()
+	return client, nil
+}
+
+// failed returns err of the host with msg, wrapping errUnreachable if the
+// host could not be reached or the connection was lost.
+func (c *sftpClient) failed(msg string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("%w: sftp %s: %s: %v", errUnreachable, c.target.Host, msg, err)
+	}
+	return fmt.Errorf("sftp %s: %s: %v", c.target.Host, msg, err)
+}
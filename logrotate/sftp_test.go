@@ -0,0 +1,257 @@
+package logrotate
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpServer is an SFTP server on a loopback port serving its root
+// directory to the holder of one key.
+type sftpServer struct {
+	root       string
+	port       int
+	knownHosts string // known hosts file holding its host key
+	identity   string // private key file it accepts
+}
+
+// newSFTPServer starts an sftpServer stopped with the test.
+func newSFTPServer(t *testing.T) *sftpServer {
+	t.Helper()
+	keys := t.TempDir()
+	server := &sftpServer{root: t.TempDir(), knownHosts: filepath.Join(keys, "known_hosts"), identity: filepath.Join(keys, "id_ed25519")}
+
+	_, hostPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostPrivate)
+	clientPublic, clientPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	authorized, _ := ssh.NewPublicKey(clientPublic)
+	block, err := ssh.MarshalPrivateKey(clientPrivate, "")
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey() error: %v", err)
+	}
+	_ = os.WriteFile(server.identity, pem.EncodeToMemory(block), 0600)
+
+	config := &ssh.ServerConfig{PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if bytes.Equal(key.Marshal(), authorized.Marshal()) {
+			return nil, nil
+		}
+		return nil, errors.New("key not authorized")
+	}}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server.port = listener.Addr().(*net.TCPAddr).Port
+	line := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, hostSigner.PublicKey())
+	_ = os.WriteFile(server.knownHosts, []byte(line+"\n"), 0644)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+	return server
+}
+
+// serve runs the sftp subsystem on the sessions of conn.
+func (s *sftpServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for request := range channelRequests {
+				// The payload of a subsystem request is the length
+				// prefixed name of the subsystem.
+				ok := request.Type == "subsystem" && string(request.Payload[4:]) == "sftp"
+				request.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(s.root))
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+// target returns an SFTPTarget of the server.
+func (s *sftpServer) target() *SFTPTarget {
+	return &SFTPTarget{Host: "127.0.0.1", Port: s.port, User: "logs", IdentityFile: s.identity, KnownHostsFile: s.knownHosts, Path: "archive/{entry}"}
+}
+
+// unreachablePort returns a loopback port nothing listens on.
+func unreachablePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestSFTPUpload(t *testing.T) {
+	server := newSFTPServer(t)
+	client, err := newSFTPClient(server.target(), retryPolicy{attempts: 1})
+	if err != nil {
+		t.Fatalf("newSFTPClient() error: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), `app "1".log.gz`)
+	_ = os.WriteFile(file, []byte("archive"), 0644)
+	for range 2 {
+		if err := client.upload(context.Background(), OSFS{}, file, `archive/app/app "1".log.gz`); err != nil {
+			t.Fatalf("upload() error: %v", err)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(server.root, "archive", "app", `app "1".log.gz`)); err != nil || string(data) != "archive" {
+		t.Errorf("Expected uploaded archive, got %q, %v", data, err)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(server.root, "archive", "app", "*.tmp")); len(temps) != 0 {
+		t.Errorf("Expected temporary file to be renamed, found %v", temps)
+	}
+	if got, want := client.String(), "sftp://logs@127.0.0.1:"+strconv.Itoa(server.port); got != want {
+		t.Errorf("Expected destination %s, got %s", want, got)
+	}
+}
+
+func TestSFTPUploadUnreachable(t *testing.T) {
+	target := newSFTPServer(t).target()
+	target.Port = unreachablePort(t)
+	client, _ := newSFTPClient(target, retryPolicy{attempts: 2, backoff: time.Millisecond})
+
+	file := filepath.Join(t.TempDir(), "app.log.gz")
+	_ = os.WriteFile(file, []byte("archive"), 0644)
+	err := client.upload(context.Background(), OSFS{}, file, "app.log.gz")
+	if !errors.Is(err, errUnreachable) {
+		t.Errorf("Expected unreachable error, got %v", err)
+	}
+}
+
+func TestSFTPUploadRefused(t *testing.T) {
+	server := newSFTPServer(t)
+	other := newSFTPServer(t)
+	file := filepath.Join(t.TempDir(), "app.log.gz")
+	_ = os.WriteFile(file, []byte("archive"), 0644)
+
+	tests := map[string]func(*SFTPTarget){
+		"unauthorized key": func(target *SFTPTarget) { target.IdentityFile = other.identity },
+		"unknown host key": func(target *SFTPTarget) { target.KnownHostsFile = other.knownHosts },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			target := server.target()
+			change(target)
+			client, _ := newSFTPClient(target, retryPolicy{attempts: 2, backoff: time.Millisecond})
+			err := client.upload(context.Background(), OSFS{}, file, "app.log.gz")
+			if err == nil || errors.Is(err, errUnreachable) {
+				t.Errorf("Expected upload to be refused without being taken for unreachable, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateFilesUploadSpool(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log")
+
+	var mu sync.Mutex
+	actions := map[string]int{}
+	clock := &fakeClock{now: time.Date(2024, 9, 13, 12, 0, 0, 0, time.UTC)}
+	engine := New(Config{StateDir: stateDir}, Options{Clock: clock, Hooks: Hooks{OnFile: func(event FileEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		actions[event.Action]++
+	}}})
+	engine.retryBackoff = time.Millisecond
+
+	server := newSFTPServer(t)
+	target := server.target()
+	port := target.Port
+	target.Port = unreachablePort(t)
+	logEntry := LogEntry{
+		Name: "app",
+		Path: Paths{filepath.Join(tempDir, "*.log")},
+		Type: "rotate",
+		Condition: &Condition{
+			Size:     stringPtr("1"),
+			Checksum: boolPtr(true),
+			Upload:   &Upload{SFTP: target, DeleteLocal: true, Retries: intPtr(1)},
+		},
+	}
+
+	_ = os.WriteFile(file, []byte("first\n"), 0644)
+	if err := engine.rotateFiles(context.Background(), logEntry); err != nil {
+		t.Fatalf("rotateFiles() error while unreachable: %v", err)
+	}
+	spooled, _ := filepath.Glob(filepath.Join(stateDir, spoolDirName, "app", "*", "*"))
+	if len(spooled) != 2 {
+		t.Errorf("Expected archive and checksum to be spooled, found %v", spooled)
+	}
+	if remaining, _ := filepath.Glob(filepath.Join(tempDir, "*")); len(remaining) != 0 {
+		t.Errorf("Expected local archive to be deleted once spooled, found %v", remaining)
+	}
+	if actions["spooled"] != 1 {
+		t.Errorf("Expected spooled event, got %v", actions)
+	}
+
+	target.Port = port
+	clock.now = clock.now.Add(time.Hour)
+	_ = os.WriteFile(file, []byte("second\n"), 0644)
+	if err := engine.rotateFiles(context.Background(), logEntry); err != nil {
+		t.Fatalf("rotateFiles() error once reachable: %v", err)
+	}
+
+	uploaded, _ := filepath.Glob(filepath.Join(server.root, "archive", "app", "*"))
+	if len(uploaded) != 4 {
+		t.Errorf("Expected spooled and new archives with checksums to be uploaded, found %v", uploaded)
+	}
+	if spooled, _ := filepath.Glob(filepath.Join(stateDir, spoolDirName, "app", "*", "*")); len(spooled) != 0 {
+		t.Errorf("Expected spool to be empty, found %v", spooled)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, spoolFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected spool records to be removed, got %v", err)
+	}
+	if actions["uploaded"] != 2 {
+		t.Errorf("Expected 2 uploaded events, got %v", actions)
+	}
+}
@@ -0,0 +1,230 @@
+package logrotate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// signatureSuffix is appended to the name of an archive to get the name of
+// its detached Ed25519 signature.
+const signatureSuffix = ".sig"
+
+// signatureContext is prepended to what is signed, so signatures of archives
+// cannot be mistaken for signatures of anything else.
+const signatureContext = "wingologrotate-signature/v1"
+
+// ErrBadSignature is returned when an archive does not match its signature.
+var ErrBadSignature = errors.New("invalid signature")
+
+// GenerateSigningKey returns a new Ed25519 key pair as PEM. The private key
+// signs archives on the host, the public key verifies them elsewhere.
+func GenerateSigningKey() (privatePEM, publicPEM []byte, err error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	return privatePEM, publicPEM, nil
+}
+
+// ParseSigningKey parses a PEM encoded Ed25519 private key.
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in signing key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %v", err)
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is not an Ed25519 key")
+	}
+	return signingKey, nil
+}
+
+// ParseVerifyKey parses a PEM encoded Ed25519 public key.
+func ParseVerifyKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an Ed25519 key")
+	}
+	return publicKey, nil
+}
+
+// signedMessage is what the signature of an archive covers: its name and
+// sha256, so an archive cannot be passed off under another name either.
+func signedMessage(archivePath string, sum []byte) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n", signatureContext, filepath.Base(archivePath), hex.EncodeToString(sum)))
+}
+
+// writeSignature atomically writes the detached signature of archivePath with
+// the signing key in the PEM file at keyPath.
+func writeSignature(fsys FS, archivePath string, sum []byte, keyPath string) error {
+	keyData, err := readFile(fsys, keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read signing key: %v", err)
+	}
+	signingKey, err := ParseSigningKey(keyData)
+	if err != nil {
+		return err
+	}
+
+	signature := ed25519.Sign(signingKey, signedMessage(archivePath, sum))
+
+	sidecar := archivePath + signatureSuffix
+	tempPath := tempName(sidecar)
+	file, err := fsys.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create signature file: %v", err)
+	}
+
+	_, err = fmt.Fprintln(file, base64.StdEncoding.EncodeToString(signature))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Rename(tempPath, sidecar)
+	}
+	if err != nil {
+		fsys.Remove(tempPath)
+		return fmt.Errorf("failed to write signature file: %v", err)
+	}
+	return nil
+}
+
+// verifySignature checks archivePath against its detached signature.
+func verifySignature(ctx context.Context, fsys FS, archivePath string, publicKey ed25519.PublicKey) error {
+	sigFile, err := fsys.Open(archivePath + signatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: archive is not signed", ErrBadSignature)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open signature file: %v", err)
+	}
+	defer sigFile.Close()
+
+	data, err := io.ReadAll(io.LimitReader(sigFile, 4096))
+	if err != nil {
+		return fmt.Errorf("failed to read signature file: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed signature file", ErrBadSignature)
+	}
+
+	file, err := fsys.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	got := newDigest()
+	if _, err := io.Copy(got, &contextReader{ctx: ctx, r: file}); err != nil {
+		return fmt.Errorf("failed to read archive: %v", err)
+	}
+	if !ed25519.Verify(publicKey, signedMessage(archivePath, got.Sum()), signature) {
+		return fmt.Errorf("%w: archive was modified or signed by another key", ErrBadSignature)
+	}
+	return nil
+}
+
+// isArchive reports whether name is an archive written by compressFile.
+func isArchive(name string) bool {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	return strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".zst") || strings.HasSuffix(name, ".tar")
+}
+
+// VerifySignatures checks every archive in dir against its detached signature
+// and publicKey. Archives without a signature, and signatures whose archive
+// is missing, fail the check.
+func VerifySignatures(ctx context.Context, dir string, publicKey ed25519.PublicKey) ([]ArchiveCheck, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive directory: %v", err)
+	}
+
+	archives := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir():
+		case strings.HasSuffix(name, signatureSuffix):
+			archives[strings.TrimSuffix(name, signatureSuffix)] = true
+		case isArchive(name):
+			archives[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(archives))
+	for name := range archives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var checks []ArchiveCheck
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			checks = append(checks, ArchiveCheck{Path: path, Err: fmt.Errorf("archive of signature is missing")})
+			continue
+		}
+		checks = append(checks, ArchiveCheck{Path: path, Err: verifySignature(ctx, OSFS{}, path, publicKey)})
+	}
+	return checks, nil
+}
+
+// signStep writes the detached signature of the file with the sign_with key
+// of step.
+func (e *Engine) signStep(ctx context.Context, run *taskRun, step *Step, file *pipelineFile) error {
+	input, err := e.fs.Open(file.path)
+	if err != nil {
+		return stepFailed("sign", "Failed to open file for signing", file.path, err)
+	}
+	defer input.Close()
+
+	sum := newDigest()
+	if _, err := io.Copy(sum, &contextReader{ctx: ctx, r: input}); err != nil {
+		return stepFailed("sign", "Failed to read file for signing", file.path, err)
+	}
+	if err := writeSignature(e.fs, file.path, sum.Sum(), *step.SignWith); err != nil {
+		return stepFailed("sign", "Failed to sign file", file.path, err)
+	}
+	run.logger.Info("Signed file", "path", file.path, "signature", file.path+signatureSuffix)
+	return nil
+}
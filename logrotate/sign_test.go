@@ -0,0 +1,106 @@
+package logrotate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func generateTestSigningKey(t *testing.T) (string, ed25519.PublicKey) {
+	t.Helper()
+
+	privatePEM, publicPEM, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "signing.key")
+	_ = os.WriteFile(keyPath, privatePEM, 0600)
+
+	publicKey, err := ParseVerifyKey(publicPEM)
+	if err != nil {
+		t.Fatalf("ParseVerifyKey() error: %v", err)
+	}
+	return keyPath, publicKey
+}
+
+func TestVerifySignatures(t *testing.T) {
+	keyPath, publicKey := generateTestSigningKey(t)
+	_, otherKey := generateTestSigningKey(t)
+
+	tempDir := t.TempDir()
+	for _, name := range []string{"good.log.1", "tampered.log.1", "renamed.log.1", "deleted.log.1"} {
+		file := filepath.Join(tempDir, name)
+		_ = os.WriteFile(file, []byte(strings.Repeat("security event\n", 100)), 0644)
+		if _, err := compressFile(context.Background(), OSFS{}, file, "gzip", compressOptions{SignWith: keyPath}); err != nil {
+			t.Fatalf("compressFile() error: %v", err)
+		}
+	}
+	unsigned := filepath.Join(tempDir, "unsigned.log.1")
+	_ = os.WriteFile(unsigned, []byte("security event\n"), 0644)
+	if _, err := compressFile(context.Background(), OSFS{}, unsigned, "gzip", compressOptions{}); err != nil {
+		t.Fatalf("compressFile() error: %v", err)
+	}
+
+	_ = os.WriteFile(filepath.Join(tempDir, "tampered.log.1.gz"), []byte("tampered"), 0644)
+	_ = os.Rename(filepath.Join(tempDir, "renamed.log.1.gz"), filepath.Join(tempDir, "other.log.1.gz"))
+	_ = os.Rename(filepath.Join(tempDir, "renamed.log.1.gz.sig"), filepath.Join(tempDir, "other.log.1.gz.sig"))
+	_ = os.Remove(filepath.Join(tempDir, "deleted.log.1.gz"))
+
+	checks, err := VerifySignatures(context.Background(), tempDir, publicKey)
+	if err != nil {
+		t.Fatalf("VerifySignatures() error: %v", err)
+	}
+
+	failed := map[string]bool{}
+	for _, check := range checks {
+		failed[filepath.Base(check.Path)] = check.Err != nil
+	}
+	want := map[string]bool{
+		"deleted.log.1.gz":  true,
+		"good.log.1.gz":     false,
+		"other.log.1.gz":    true,
+		"tampered.log.1.gz": true,
+		"unsigned.log.1.gz": true,
+	}
+	if len(failed) != len(want) {
+		t.Fatalf("Expected checks of %v, got %+v", want, checks)
+	}
+	for name, wantFailed := range want {
+		if failed[name] != wantFailed {
+			t.Errorf("Expected failed=%v for %s, got %+v", wantFailed, name, checks)
+		}
+	}
+
+	checks, _ = VerifySignatures(context.Background(), tempDir, otherKey)
+	for _, check := range checks {
+		if filepath.Base(check.Path) == "good.log.1.gz" && !errors.Is(check.Err, ErrBadSignature) {
+			t.Errorf("Expected ErrBadSignature with another key, got %v", check.Err)
+		}
+	}
+}
+
+func TestRemoveOldFilesSignatures(t *testing.T) {
+	keyPath, _ := generateTestSigningKey(t)
+
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log")
+	for _, rotated := range []string{file + ".1", file + ".2"} {
+		_ = os.WriteFile(rotated, []byte("log line\n"), 0644)
+		options := compressOptions{Checksum: true, SignWith: keyPath}
+		if _, err := compressFile(context.Background(), OSFS{}, rotated, "gzip", options); err != nil {
+			t.Fatalf("compressFile() error: %v", err)
+		}
+	}
+
+	if _, err := removeOldFiles(OSFS{}, New(Config{}, Options{}).log(), tempDir, "app.log", 1); err != nil {
+		t.Fatalf("removeOldFiles() error: %v", err)
+	}
+	remaining, _ := filepath.Glob(file + ".*")
+	if len(remaining) != 3 {
+		t.Errorf("Expected one archive with its checksum and signature to be kept, got %v", remaining)
+	}
+}
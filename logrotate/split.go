@@ -0,0 +1,186 @@
+package logrotate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+)
+
+// splitFile cuts path into chunks named path.001, path.002, ... and removes
+// it, unless it fits a single chunk. Chunks end at line ends and hold at most
+// chunkSize bytes, except a chunk of a single longer line. The file is
+// streamed, only a line or maxLineSize of it is in memory at a time.
+func splitFile(ctx context.Context, fsys FS, path string, chunkSize int64) ([]string, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+
+	input, err := fsys.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for splitting: %v", err)
+	}
+	defer input.Close()
+
+	fileInfo, err := input.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %v", err)
+	}
+	if fileInfo.Size() <= chunkSize {
+		return nil, nil
+	}
+
+	digits := max(3, len(strconv.FormatInt((fileInfo.Size()+chunkSize-1)/chunkSize, 10)))
+	chunkName := func(n int) string {
+		return fmt.Sprintf("%s.%0*d", path, digits, n)
+	}
+
+	var chunks []string
+	var chunk *chunkWriter
+	fail := func(err error) ([]string, error) {
+		if chunk != nil {
+			chunk.abort()
+		}
+		for _, done := range chunks {
+			fsys.Remove(done)
+		}
+		return nil, err
+	}
+
+	reader := bufio.NewReaderSize(&contextReader{ctx: ctx, r: input}, maxLineSize)
+	lineStart := true
+	var offset int64 // of piece in the file
+	for {
+		piece, readErr := reader.ReadSlice('\n')
+		if readErr != nil && readErr != bufio.ErrBufferFull && readErr != io.EOF {
+			return fail(fmt.Errorf("failed to read file for splitting: %v", readErr))
+		}
+
+		lineSize := int64(len(piece))
+		if chunk != nil && lineStart && readErr == bufio.ErrBufferFull && chunk.written+lineSize <= chunkSize {
+			// The line goes on past the buffer, so the rest of it is measured
+			// and read again.
+			piece = append([]byte(nil), piece...)
+			rest, err := measureLine(reader, chunkSize-chunk.written-lineSize)
+			if err != nil {
+				return fail(fmt.Errorf("failed to read file for splitting: %v", err))
+			}
+			lineSize += rest
+			if _, err := input.Seek(offset+int64(len(piece)), io.SeekStart); err != nil {
+				return fail(fmt.Errorf("failed to seek file for splitting: %v", err))
+			}
+			reader.Reset(&contextReader{ctx: ctx, r: input})
+		}
+		offset += int64(len(piece))
+
+		if len(piece) > 0 {
+			if chunk != nil && lineStart && chunk.written+lineSize > chunkSize {
+				if err := chunk.finish(); err != nil {
+					return fail(err)
+				}
+				chunks = append(chunks, chunk.path)
+				chunk = nil
+			}
+			if chunk == nil {
+				if chunk, err = newChunkWriter(fsys, chunkName(len(chunks)+1)); err != nil {
+					return fail(err)
+				}
+			}
+			if err := chunk.write(piece); err != nil {
+				return fail(err)
+			}
+			lineStart = piece[len(piece)-1] == '\n'
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if chunk != nil {
+		if err := chunk.finish(); err != nil {
+			return fail(err)
+		}
+		chunks = append(chunks, chunk.path)
+		chunk = nil
+	}
+
+	input.Close()
+	if err := syncDir(fsys, filepath.Dir(path)); err != nil {
+		return fail(err)
+	}
+	if err := fsys.Remove(path); err != nil {
+		return fail(fmt.Errorf("failed to remove split file: %v", err))
+	}
+	return chunks, nil
+}
+
+// measureLine returns the size of the rest of the line reader is in, up to
+// its end or EOF, or once it is larger than limit.
+func measureLine(reader *bufio.Reader, limit int64) (int64, error) {
+	var size int64
+	for size <= limit {
+		piece, err := reader.ReadSlice('\n')
+		size += int64(len(piece))
+		switch err {
+		case nil, io.EOF:
+			return size, nil
+		case bufio.ErrBufferFull:
+		default:
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// chunkWriter writes a chunk to its temporary name, renaming it into place
+// once finished.
+type chunkWriter struct {
+	fs      FS
+	path    string
+	file    File
+	buf     *bufio.Writer
+	written int64
+}
+
+func newChunkWriter(fsys FS, path string) (*chunkWriter, error) {
+	file, err := fsys.Create(tempName(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk: %v", err)
+	}
+	return &chunkWriter{fs: fsys, path: path, file: file, buf: bufio.NewWriter(file)}, nil
+}
+
+func (c *chunkWriter) write(p []byte) error {
+	n, err := c.buf.Write(p)
+	c.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write chunk: %v", err)
+	}
+	return nil
+}
+
+func (c *chunkWriter) finish() error {
+	err := c.buf.Flush()
+	if err == nil {
+		err = c.file.Sync()
+	}
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = c.fs.Rename(tempName(c.path), c.path)
+	}
+	if err != nil {
+		c.fs.Remove(tempName(c.path))
+		return fmt.Errorf("failed to write chunk: %v", err)
+	}
+	return nil
+}
+
+func (c *chunkWriter) abort() {
+	c.file.Close()
+	c.fs.Remove(tempName(c.path))
+}
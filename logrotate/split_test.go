@@ -0,0 +1,126 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		chunkSize int64
+		want      []string
+	}{
+		{name: "fits a chunk", content: "one\ntwo\n", chunkSize: 8, want: nil},
+		{name: "line aligned", content: "one\ntwo\nthree\nfour\n", chunkSize: 9, want: []string{"one\ntwo\n", "three\n", "four\n"}},
+		{name: "line longer than a chunk", content: "a\n" + strings.Repeat("x", 20) + "\nb\n", chunkSize: 5, want: []string{"a\n", strings.Repeat("x", 20) + "\n", "b\n"}},
+		{name: "no final newline", content: "one\ntwo\nthree", chunkSize: 8, want: []string{"one\ntwo\n", "three"}},
+		{name: "line longer than the read buffer", content: strings.Repeat("x", 2*maxLineSize) + "\nend\n", chunkSize: maxLineSize, want: []string{strings.Repeat("x", 2*maxLineSize) + "\n", "end\n"}},
+		{name: "line longer than the read buffer after a short one", content: "start\n" + strings.Repeat("x", maxLineSize+10) + "\nend\n", chunkSize: maxLineSize + 12, want: []string{"start\n", strings.Repeat("x", maxLineSize+10) + "\n", "end\n"}},
+		{name: "line longer than the read buffer fitting a chunk", content: "start\n" + strings.Repeat("x", maxLineSize+5) + "\nend\n", chunkSize: maxLineSize + 12, want: []string{"start\n" + strings.Repeat("x", maxLineSize+5) + "\n", "end\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "app.log.20240913-120000")
+			_ = os.WriteFile(file, []byte(tt.content), 0644)
+
+			chunks, err := splitFile(context.Background(), OSFS{}, file, tt.chunkSize)
+			if err != nil {
+				t.Fatalf("splitFile() error: %v", err)
+			}
+
+			if tt.want == nil {
+				if chunks != nil {
+					t.Errorf("Expected no chunks, got %v", chunks)
+				}
+				if content, _ := os.ReadFile(file); string(content) != tt.content {
+					t.Errorf("Expected file left alone, got %q", content)
+				}
+				return
+			}
+
+			var got []string
+			for i, chunk := range chunks {
+				if want := file + "." + []string{"001", "002", "003"}[i]; chunk != want {
+					t.Errorf("Expected chunk %d named %s, got %s", i+1, want, chunk)
+				}
+				content, _ := os.ReadFile(chunk)
+				got = append(got, string(content))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected chunks %q, got %q", tt.want, got)
+			}
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Errorf("Expected split file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSplitFileInvalidChunkSize(t *testing.T) {
+	for _, chunkSize := range []int64{0, -1} {
+		file := filepath.Join(t.TempDir(), "app.log.20240913-120000")
+		_ = os.WriteFile(file, []byte("one\ntwo\n"), 0644)
+
+		if _, err := splitFile(context.Background(), OSFS{}, file, chunkSize); err == nil {
+			t.Errorf("Expected error for chunk size %d", chunkSize)
+		}
+		if content, _ := os.ReadFile(file); string(content) != "one\ntwo\n" {
+			t.Errorf("Expected file left alone for chunk size %d, got %q", chunkSize, content)
+		}
+	}
+}
+
+func TestRotateFilesSplit(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log")
+	_ = os.WriteFile(file, []byte("one\ntwo\nthree\nfour\n"), 0644)
+
+	now := time.Date(2024, 9, 13, 12, 0, 0, 0, time.UTC)
+	rotated := rotatedFileName(OSFS{}, now, file)
+
+	var actions []string
+	clock := &fakeClock{now: now}
+	engine := New(Config{}, Options{Clock: clock, Hooks: Hooks{OnFile: func(event FileEvent) { actions = append(actions, event.Action) }}})
+	err := engine.task(LogEntry{
+		Name:      "app",
+		Path:      Paths{file},
+		Type:      "rotate",
+		Condition: &Condition{Size: stringPtr("1"), ChunkSize: stringPtr("9"), MaxKeep: intPtr(2)},
+	})(context.Background())
+	if err != nil {
+		t.Fatalf("task() error: %v", err)
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(tempDir, "app.log.*"))
+	want := []string{rotated + ".002.gz", rotated + ".003.gz"}
+	if !reflect.DeepEqual(archives, want) {
+		t.Fatalf("Expected %v after pruning, got %v", want, archives)
+	}
+
+	for i, content := range []string{"three\n", "four\n"} {
+		archive, _ := os.Open(want[i])
+		gzipReader, err := gzip.NewReader(archive)
+		if err != nil {
+			t.Fatalf("Expected gzip chunk, got %v", err)
+		}
+		if got, _ := io.ReadAll(gzipReader); string(got) != content {
+			t.Errorf("Expected chunk %s to hold %q, got %q", want[i], content, got)
+		}
+		archive.Close()
+	}
+
+	wantActions := []string{"rotated", "split", "compressed", "compressed", "compressed", "pruned"}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Errorf("Expected actions %v, got %v", wantActions, actions)
+	}
+}
@@ -0,0 +1,217 @@
+package logrotate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolDirName  = "spool"
+	spoolFileName = "spool.json"
+)
+
+// spooledFile is a copy in the spool of a file to be uploaded as Key.
+type spooledFile struct {
+	Path string `json:"path"`
+	Key  string `json:"key"`
+}
+
+// spoolRecord is an archive that could not be uploaded because the host was
+// unreachable. Its files are uploaded in order, the archive itself last. Its
+// copies are kept in a directory of their own, named by its ID, so archives
+// of the same name never share a copy.
+type spoolRecord struct {
+	ID      string        `json:"id"`
+	Entry   string        `json:"entry"`
+	Files   []spooledFile `json:"files"`
+	Spooled time.Time     `json:"spooled"`
+}
+
+func (r spoolRecord) archive() string {
+	return r.Files[len(r.Files)-1].Path
+}
+
+// spool keeps copies of archives in the state directory while their upload
+// host is unreachable, until a later upload of the same entry gets through.
+// Without a state directory nothing is spooled.
+type spool struct {
+	fs   FS
+	dir  string
+	path string
+
+	mu       sync.Mutex
+	flushing sync.Mutex // held while the spool is being flushed
+}
+
+func newSpool(fsys FS, stateDir string) *spool {
+	s := &spool{fs: fsys}
+	if stateDir != "" {
+		s.dir = filepath.Join(stateDir, spoolDirName)
+		s.path = filepath.Join(stateDir, spoolFileName)
+	}
+	return s
+}
+
+func (s *spool) enabled() bool {
+	return s.path != ""
+}
+
+// add copies files into a new directory of the spool directory of entry,
+// recording them to be uploaded as keys.
+func (s *spool) add(entry string, files []spooledFile, now time.Time) (spoolRecord, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return spoolRecord{}, fmt.Errorf("failed to generate spool record ID: %v", err)
+	}
+	id := now.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix)
+	dir := filepath.Join(s.dir, spoolDirOf(entry), id)
+	if err := s.fs.MkdirAll(dir, 0755); err != nil {
+		return spoolRecord{}, fmt.Errorf("failed to create spool directory: %v", err)
+	}
+
+	record := spoolRecord{ID: id, Entry: entry, Spooled: now}
+	for _, file := range files {
+		spooled := filepath.Join(dir, filepath.Base(file.Path))
+		if err := copyFile(s.fs, file.Path, spooled); err != nil {
+			s.removeFiles(record)
+			return spoolRecord{}, fmt.Errorf("failed to copy file to spool: %v", err)
+		}
+		record.Files = append(record.Files, spooledFile{Path: spooled, Key: file.Key})
+	}
+
+	err := s.update(func(records []spoolRecord) []spoolRecord {
+		return append(records, record)
+	})
+	if err != nil {
+		s.removeFiles(record)
+		return spoolRecord{}, err
+	}
+	return record, nil
+}
+
+// remove deletes the files of an uploaded record and the record itself.
+func (s *spool) remove(record spoolRecord) error {
+	err := s.update(func(records []spoolRecord) []spoolRecord {
+		kept := records[:0]
+		for _, r := range records {
+			if r.ID != record.ID {
+				kept = append(kept, r)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		return err
+	}
+	return s.removeFiles(record)
+}
+
+func (s *spool) removeFiles(record spoolRecord) error {
+	for _, file := range record.Files {
+		if err := s.fs.Remove(file.Path); err != nil {
+			return fmt.Errorf("failed to remove spooled file: %v", err)
+		}
+	}
+	if record.ID != "" {
+		// The directory of the record, empty once its files are gone.
+		s.fs.Remove(filepath.Join(s.dir, spoolDirOf(record.Entry), record.ID))
+	}
+	return nil
+}
+
+// spoolDirOf returns the name of the spool directory of entry, which may
+// hold any character.
+func spoolDirOf(entry string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' || strings.ContainsRune(`*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, entry)
+	if name == "" || strings.Trim(name, ".") == "" {
+		name = "_" + name
+	}
+	return name
+}
+
+// records returns the records of entry in the order they were spooled.
+func (s *spool) records(entry string) ([]spoolRecord, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	var found []spoolRecord
+	for _, record := range records {
+		if record.Entry == entry {
+			found = append(found, record)
+		}
+	}
+	return found, nil
+}
+
+func (s *spool) update(change func([]spoolRecord) []spoolRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+	records = change(records)
+	if err := writeStateFile(s.fs, s.path, records, len(records) == 0); err != nil {
+		return fmt.Errorf("failed to write spool: %v", err)
+	}
+	return nil
+}
+
+// read must be called with s.mu held.
+func (s *spool) read() ([]spoolRecord, error) {
+	var records []spoolRecord
+	if err := readStateFile(s.fs, s.path, &records); err != nil {
+		return nil, fmt.Errorf("failed to read spool: %v", err)
+	}
+	return records, nil
+}
+
+// copyFile atomically copies src to dst.
+func copyFile(fsys FS, src, dst string) error {
+	in, err := fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tempPath := tempName(dst)
+	out, err := fsys.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Rename(tempPath, dst)
+	}
+	if err != nil {
+		fsys.Remove(tempPath)
+	}
+	return err
+}
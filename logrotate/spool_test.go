@@ -0,0 +1,58 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolSameNamedArchives(t *testing.T) {
+	stateDir := t.TempDir()
+	s := newSpool(OSFS{}, stateDir)
+	now := time.Date(2024, 9, 13, 12, 0, 0, 0, time.UTC)
+
+	var records []spoolRecord
+	for _, dir := range []string{"a", "b"} {
+		archive := filepath.Join(t.TempDir(), dir, "app.log.gz")
+		_ = os.MkdirAll(filepath.Dir(archive), 0755)
+		_ = os.WriteFile(archive, []byte(dir), 0644)
+		record, err := s.add("app", []spooledFile{{Path: archive, Key: dir + "/app.log.gz"}}, now)
+		if err != nil {
+			t.Fatalf("add() error: %v", err)
+		}
+		records = append(records, record)
+	}
+	if records[0].ID == records[1].ID || records[0].archive() == records[1].archive() {
+		t.Fatalf("Expected records with their own ID and copy, got %+v", records)
+	}
+
+	if err := s.remove(records[0]); err != nil {
+		t.Fatalf("remove() error: %v", err)
+	}
+	if data, err := os.ReadFile(records[1].archive()); err != nil || string(data) != "b" {
+		t.Errorf("Expected copy of the other archive to be kept, got %q, %v", data, err)
+	}
+	left, err := s.records("app")
+	if err != nil || len(left) != 1 || left[0].ID != records[1].ID {
+		t.Errorf("Expected only the other record to be left, got %+v, %v", left, err)
+	}
+}
+
+func TestSpoolEntryNameStaysInSpool(t *testing.T) {
+	stateDir := t.TempDir()
+	s := newSpool(OSFS{}, stateDir)
+	archive := filepath.Join(t.TempDir(), "app.log.gz")
+	_ = os.WriteFile(archive, []byte("archive"), 0644)
+
+	for _, entry := range []string{"..", filepath.Join("..", "..", "escape"), `C:\logs`} {
+		record, err := s.add(entry, []spooledFile{{Path: archive, Key: "app.log.gz"}}, time.Now())
+		if err != nil {
+			t.Fatalf("add(%q) error: %v", entry, err)
+		}
+		if !strings.HasPrefix(record.archive(), filepath.Join(stateDir, spoolDirName)+string(filepath.Separator)) {
+			t.Errorf("Expected copy of entry %q in the spool, got %s", entry, record.archive())
+		}
+	}
+}
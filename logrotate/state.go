@@ -0,0 +1,64 @@
+package logrotate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// readStateFile decodes the JSON file at path of the state directory into v,
+// leaving v alone if the file does not exist.
+func readStateFile(fsys FS, path string, v any) error {
+	file, err := fsys.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// writeStateFile atomically replaces the JSON file at path of the state
+// directory with v, or removes it if empty.
+func writeStateFile(fsys FS, path string, v any, empty bool) error {
+	if empty {
+		if err := fsys.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	tempPath := tempName(path)
+	file, err := fsys.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(v)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Rename(tempPath, path)
+	}
+	if err != nil {
+		fsys.Remove(tempPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// taskRun collects the errors of a single run of a log entry so the run can
+// be reported as a whole while individual files keep being processed. Files
+// may be processed concurrently.
+type taskRun struct {
+	engine *Engine
+	entry  string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	errs    []error
+	bundled []string // files collected by an archive step, see archiveStep
+}
+
+func (e *Engine) newTaskRun(logEntry LogEntry, action string) *taskRun {
+	return &taskRun{
+		engine: e,
+		entry:  logEntry.Name,
+		logger: e.log().With("entry", logEntry.Name, "action", action),
+	}
+}
+
+func (r *taskRun) fail(kind, msg, path string, err error) {
+	r.logger.Error(msg, "path", path, "kind", kind, "error", err)
+	if r.engine.hooks.OnError != nil {
+		r.engine.hooks.OnError(ErrorEvent{Entry: r.entry, Kind: kind, Path: path, Err: err})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, fmt.Errorf("%s: %v", path, err))
+}
+
+func (r *taskRun) done(event FileEvent) {
+	event.Entry = r.entry
+	if r.engine.hooks.OnFile != nil {
+		r.engine.hooks.OnFile(event)
+	}
+}
+
+func (r *taskRun) addToBundle(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bundled = append(r.bundled, path)
+}
+
+func (r *taskRun) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return errors.Join(r.errs...)
+}
+
+// task returns the function running logEntry once.
+func (e *Engine) task(logEntry LogEntry) func(context.Context) error {
+	return func(ctx context.Context) error {
+		logger := e.log().With("entry", logEntry.Name, "action", logEntry.Type)
+		start := e.clock.Now()
+
+		var err error
+		switch logEntry.Type {
+		case "delete":
+			err = e.deleteFiles(ctx, logEntry)
+		case "rotate":
+			err = e.rotateFiles(ctx, logEntry)
+		case "pipeline":
+			err = e.runPipeline(ctx, logEntry)
+		case "copy", "move", "compress", "truncate", "archive", "compact", "recompress":
+			err = e.runSteps(ctx, logEntry, logEntry.Type, logEntry.pipeline())
+		default:
+			err = fmt.Errorf("unsupported task type: %s", logEntry.Type)
+			if e.hooks.OnError != nil {
+				e.hooks.OnError(ErrorEvent{Entry: logEntry.Name, Kind: "config", Err: err})
+			}
+		}
+
+		duration := e.clock.Now().Sub(start)
+		if err != nil {
+			logger.Error("Task finished with errors", "duration", duration, "error", err)
+		} else {
+			logger.Info("Task finished", "duration", duration)
+		}
+		return err
+	}
+}
+
+// matchFiles expands the paths of logEntry.
+func (e *Engine) matchFiles(run *taskRun, logEntry LogEntry) []string {
+	var files []string
+	for _, path := range logEntry.Path {
+		run.logger.Info("Running task", "path", path)
+		matchingFiles, err := e.fs.Glob(filepath.Clean(path))
+		if err != nil {
+			run.fail("glob", "Failed to expand wildcard", path, err)
+			continue
+		}
+		files = append(files, matchingFiles...)
+	}
+	return files
+}
+
+// activeFiles returns the most recently modified of files in every
+// directory, taken to be those their application is still writing to.
+func (e *Engine) activeFiles(files []string) map[string]bool {
+	type newestFile struct {
+		path    string
+		modTime time.Time
+	}
+	newest := make(map[string]newestFile)
+	for _, file := range files {
+		name := filepath.Base(file)
+		if isArchive(name) || isSidecar(name) {
+			continue
+		}
+		fileInfo, err := e.fs.Stat(file)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Dir(file)
+		if current, ok := newest[dir]; !ok || fileInfo.ModTime().After(current.modTime) {
+			newest[dir] = newestFile{path: file, modTime: fileInfo.ModTime()}
+		}
+	}
+
+	active := make(map[string]bool, len(newest))
+	for _, file := range newest {
+		active[file.path] = true
+	}
+	return active
+}
+
+// processFiles calls process for every file, with at most Config.Workers
+// files being processed at a time across all entries. Files another task is
+// processing, e.g. of an entry whose glob overlaps, are skipped for this run.
+func (e *Engine) processFiles(ctx context.Context, run *taskRun, files []string, process func(file string)) {
+	e.mu.Lock()
+	workers := e.workers
+	e.mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, file := range files {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			run.fail("cancelled", "Task cancelled", file, ctx.Err())
+			return
+		}
+		if ctx.Err() != nil {
+			<-workers
+			run.fail("cancelled", "Task cancelled", file, ctx.Err())
+			return
+		}
+
+		if !e.lockFile(file) {
+			<-workers
+			run.logger.Info("Skipping file processed by another task", "path", file)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				e.unlockFile(file)
+				<-workers
+			}()
+			process(file)
+		}()
+	}
+}
+
+func (e *Engine) deleteFiles(ctx context.Context, logEntry LogEntry) error {
+	return e.runSteps(ctx, logEntry, "delete", logEntry.pipeline())
+}
+
+func (e *Engine) rotateFiles(ctx context.Context, logEntry LogEntry) error {
+	return e.runSteps(ctx, logEntry, "rotate", logEntry.pipeline())
+}
+
+func (e *Engine) runPipeline(ctx context.Context, logEntry LogEntry) error {
+	return e.runSteps(ctx, logEntry, "pipeline", logEntry.pipeline())
+}
+
+// dueByAge reports whether the file is older than the age of step, which
+// every file is without one.
+func (e *Engine) dueByAge(step *Step, file *pipelineFile) (fs.FileInfo, bool, error) {
+	fileInfo, err := e.fs.Stat(file.path)
+	if err != nil {
+		return nil, false, stepFailed("stat", "Failed to get file info", file.path, err)
+	}
+
+	if step.Age != nil {
+		ageDuration, err := ParseDuration(*step.Age)
+		if err != nil {
+			return nil, false, stepFailed("config", "Invalid age format", file.path, err)
+		}
+
+		if e.clock.Now().Sub(fileInfo.ModTime()) < ageDuration {
+			return fileInfo, false, nil
+		}
+	}
+	return fileInfo, true, nil
+}
+
+// deleteStep deletes the file if it is older than the age of step. Without an
+// age, entries with a free_space condition only delete files under disk
+// pressure, see relieveDiskPressure.
+func (e *Engine) deleteStep(run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	if step.Age == nil && step.FreeSpace != nil {
+		return false, nil
+	}
+	fileInfo, due, err := e.dueByAge(step, file)
+	if err != nil || !due {
+		return false, err
+	}
+
+	run.logger.Debug("Deleting file", "path", file.path)
+	if err := e.fs.Remove(file.path); err != nil {
+		return false, stepFailed("delete", "Failed to delete file", file.path, err)
+	}
+	run.logger.Info("Deleted file", "path", file.path, "bytes", fileInfo.Size())
+	run.done(FileEvent{Action: "deleted", Path: file.path, Size: fileInfo.Size()})
+	return true, nil
+}
+
+// rotateStep renames the file aside if it reached the size or age of step,
+// splitting it into chunks that go through the remaining steps one by one if
+// step has a chunk size.
+func (e *Engine) rotateStep(ctx context.Context, run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	fileInfo, err := e.fs.Stat(file.path)
+	if err != nil {
+		return false, stepFailed("stat", "Failed to get file info", file.path, err)
+	}
+
+	if due, err := e.dueBySizeOrAge(step, file.path, fileInfo); err != nil || !due {
+		return false, err
+	}
+
+	rotatedFilePath := rotatedFileName(e.fs, e.clock.Now(), file.path)
+	if err := e.fs.Rename(file.path, rotatedFilePath); err != nil {
+		return false, stepFailed("rename", "Failed to rotate log file", file.path, err)
+	}
+	run.logger.Info("Rotated log file", "path", file.path, "rotated", rotatedFilePath, "bytes", fileInfo.Size())
+	run.done(FileEvent{Action: "rotated", Path: file.path, NewPath: rotatedFilePath, Size: fileInfo.Size()})
+	file.path = rotatedFilePath
+
+	if step.ChunkSize != nil {
+		chunkSize, err := ParseSize(*step.ChunkSize)
+		if err != nil {
+			return false, stepFailed("config", "Invalid chunk size", file.path, err)
+		}
+		chunks, err := splitFile(ctx, e.fs, file.path, chunkSize)
+		if err != nil {
+			return false, stepFailed("split", "Failed to split rotated log file", file.path, err)
+		}
+		if len(chunks) > 0 {
+			run.logger.Info("Split rotated log file", "path", file.path, "chunks", len(chunks), "bytes", fileInfo.Size())
+			run.done(FileEvent{Action: "split", Path: file.path, NewPath: chunks[0], Size: fileInfo.Size()})
+			file.chunks = chunks
+		}
+	}
+	return true, nil
+}
+
+// dueBySizeOrAge reports whether the file reached the size or the age of
+// step, which it never does without either.
+func (e *Engine) dueBySizeOrAge(step *Step, path string, fileInfo fs.FileInfo) (bool, error) {
+	if step.Size != nil {
+		maxSize, err := ParseSize(*step.Size)
+		if err != nil {
+			return false, stepFailed("config", "Invalid size format", path, err)
+		}
+
+		if fileInfo.Size() >= maxSize {
+			return true, nil
+		}
+	}
+
+	if step.Age != nil {
+		ageDuration, err := ParseDuration(*step.Age)
+		if err != nil {
+			return false, stepFailed("config", "Invalid age format", path, err)
+		}
+
+		if e.clock.Now().Sub(fileInfo.ModTime()) >= ageDuration {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compressStep compresses the file into an archive with the options of step.
+//
+// A file that no earlier step renamed was rolled by the application writing
+// it and is compressed in place: archives, sidecars and the active file are
+// left alone, and so are files below the size and age of step if it has
+// either.
+func (e *Engine) compressStep(ctx context.Context, run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	fileInfo, err := e.fs.Stat(file.path)
+	if err != nil {
+		return false, stepFailed("stat", "Failed to get file info", file.path, err)
+	}
+
+	if file.path == file.original {
+		name := filepath.Base(file.path)
+		if isArchive(name) || isSidecar(name) || strings.HasSuffix(name, tempName("")) {
+			return false, nil
+		}
+		if file.active {
+			run.logger.Debug("Skipping active file", "path", file.path)
+			return false, nil
+		}
+		if step.Size != nil || step.Age != nil {
+			if due, err := e.dueBySizeOrAge(step, file.path, fileInfo); err != nil || !due {
+				return false, err
+			}
+		}
+	}
+
+	format := compressionFormat(&step.Condition)
+	compressStart := e.clock.Now()
+	result, err := e.compress(ctx, run, file.path, format, compressOptionsOf(&step.Condition))
+	if err != nil {
+		return false, stepFailed("compress", "Failed to compress log file", file.path, err)
+	}
+
+	attrs := []any{"path", file.path, "format", format, "bytes", result.Size, "duration", e.clock.Now().Sub(compressStart)}
+	if len(step.Redact) > 0 {
+		attrs = append(attrs, "redacted_lines", result.Redacted, "dropped_lines", result.Dropped)
+	}
+	run.logger.Info("Compressed log file", attrs...)
+	run.done(FileEvent{Action: "compressed", Path: file.path, NewPath: result.Path, Size: fileInfo.Size(), NewSize: result.Size, Redacted: result.Redacted, Dropped: result.Dropped})
+	file.path = result.Path
+	return true, nil
+}
+
+// pruneStep keeps the max_keep of step newest rotated files of the file, or
+// newest bundles if it is one.
+func (e *Engine) pruneStep(run *taskRun, step *Step, file *pipelineFile) error {
+	pattern := file.siblings
+	if pattern == "" {
+		pattern = filepath.Join(filepath.Dir(file.original), filepath.Base(file.original)+".*")
+	}
+	removed, err := removeMatchingFiles(e.fs, run.logger, pattern, *step.MaxKeep)
+	for _, event := range removed {
+		run.done(event)
+	}
+	if err != nil {
+		return stepFailed("prune", "Failed to remove old log files", file.original, err)
+	}
+	return nil
+}
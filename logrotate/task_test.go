@@ -0,0 +1,235 @@
+package logrotate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// failingFS is the OS filesystem with Remove failing for every file.
+type failingFS struct {
+	OSFS
+}
+
+func (failingFS) Remove(name string) error {
+	return errors.New("access denied")
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestRotateFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	file1 := filepath.Join(tempDir, "log1.log")
+	file2 := filepath.Join(tempDir, "log2.log")
+	_ = os.WriteFile(file1, make([]byte, 1024*1024*5), 0644)  // 5MB log file
+	_ = os.WriteFile(file2, make([]byte, 1024*1024*10), 0644) // 10MB log file
+
+	logEntry := LogEntry{
+		Name: "app",
+		Path: Paths{filepath.Join(tempDir, "*.log")},
+		Type: "rotate",
+		Condition: &Condition{
+			Size:     stringPtr("1MB"),
+			Compress: boolPtr(true),
+			MaxKeep:  intPtr(1),
+		},
+	}
+
+	var mu sync.Mutex
+	var events []FileEvent
+	engine := New(Config{}, Options{Hooks: Hooks{OnFile: func(event FileEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}}})
+
+	if err := engine.rotateFiles(context.Background(), logEntry); err != nil {
+		t.Fatalf("rotateFiles() error: %v", err)
+	}
+
+	compressedFiles, _ := filepath.Glob(filepath.Join(tempDir, "*.gz"))
+	if len(compressedFiles) == 0 {
+		t.Errorf("Expected compressed log files, but found none.")
+	}
+
+	remainingFiles, _ := filepath.Glob(filepath.Join(tempDir, "*.log"))
+	if len(remainingFiles) > 1 {
+		t.Errorf("Expected at most 1 log file, but found %d", len(remainingFiles))
+	}
+
+	actions := map[string]int{}
+	for _, event := range events {
+		if event.Entry != "app" {
+			t.Errorf("Expected event for entry app, got %+v", event)
+		}
+		actions[event.Action]++
+	}
+	if actions["rotated"] != 2 || actions["compressed"] != 2 {
+		t.Errorf("Expected 2 rotated and 2 compressed events, got %v", actions)
+	}
+}
+
+func TestDeleteFilesAge(t *testing.T) {
+	tempDir := t.TempDir()
+
+	file := filepath.Join(tempDir, "app.log")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+	modTime := time.Date(2024, 9, 13, 12, 0, 0, 0, time.UTC)
+	_ = os.Chtimes(file, modTime, modTime)
+
+	logEntry := LogEntry{
+		Name:      "app",
+		Path:      Paths{filepath.Join(tempDir, "*.log")},
+		Type:      "delete",
+		Condition: &Condition{Age: stringPtr("1d")},
+	}
+
+	clock := &fakeClock{now: modTime.Add(23 * time.Hour)}
+	engine := New(Config{}, Options{Clock: clock})
+
+	if err := engine.deleteFiles(context.Background(), logEntry); err != nil {
+		t.Fatalf("deleteFiles() error: %v", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("Expected file younger than 1d to be kept: %v", err)
+	}
+
+	clock.now = modTime.Add(25 * time.Hour)
+	if err := engine.deleteFiles(context.Background(), logEntry); err != nil {
+		t.Fatalf("deleteFiles() error: %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected file older than 1d to be deleted")
+	}
+}
+
+func TestDeleteFilesErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(tempDir, "app.log"), []byte("log line\n"), 0644)
+
+	var errorEvents []ErrorEvent
+	engine := New(Config{}, Options{
+		FS: failingFS{},
+		Hooks: Hooks{OnError: func(event ErrorEvent) {
+			errorEvents = append(errorEvents, event)
+		}},
+	})
+
+	err := engine.deleteFiles(context.Background(), LogEntry{
+		Name: "app",
+		Path: Paths{filepath.Join(tempDir, "*.log")},
+		Type: "delete",
+	})
+	if err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("Expected access denied error, got %v", err)
+	}
+	if len(errorEvents) != 1 || errorEvents[0].Kind != "delete" || errorEvents[0].Entry != "app" {
+		t.Errorf("Expected a single delete error event, got %+v", errorEvents)
+	}
+}
+
+func TestProcessFilesWorkers(t *testing.T) {
+	engine := New(Config{Workers: 2}, Options{})
+	run := engine.newTaskRun(LogEntry{Name: "app"}, "test")
+
+	var running, maxRunning atomic.Int32
+	var processed atomic.Int32
+	files := []string{"a.log", "b.log", "c.log", "d.log", "e.log"}
+
+	engine.processFiles(context.Background(), run, files, func(file string) {
+		current := running.Add(1)
+		for {
+			max := maxRunning.Load()
+			if current <= max || maxRunning.CompareAndSwap(max, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		processed.Add(1)
+	})
+
+	if got := processed.Load(); got != int32(len(files)) {
+		t.Errorf("Expected %d files to be processed, got %d", len(files), got)
+	}
+	if got := maxRunning.Load(); got != 2 {
+		t.Errorf("Expected 2 files to be processed in parallel, got %d", got)
+	}
+}
+
+func TestProcessFilesSkipsLockedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "app.log")
+	_ = os.WriteFile(file, []byte("log line\n"), 0644)
+
+	engine := New(Config{}, Options{})
+
+	// Another entry whose glob also matches the file is processing it.
+	if !engine.lockFile(filepath.Join(tempDir, ".", "app.log")) {
+		t.Fatalf("Expected to lock unlocked file")
+	}
+
+	if err := engine.deleteFiles(context.Background(), LogEntry{Name: "app", Path: Paths{filepath.Join(tempDir, "*.log")}, Type: "delete"}); err != nil {
+		t.Fatalf("deleteFiles() error: %v", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("Expected file locked by another task to be skipped: %v", err)
+	}
+
+	engine.unlockFile(file)
+	if err := engine.deleteFiles(context.Background(), LogEntry{Name: "app", Path: Paths{filepath.Join(tempDir, "*.log")}, Type: "delete"}); err != nil {
+		t.Fatalf("deleteFiles() error: %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected file to be deleted once unlocked")
+	}
+}
+
+func TestTaskLogOutput(t *testing.T) {
+	logBuf := new(bytes.Buffer)
+	engine := New(Config{}, Options{Logger: slog.New(slog.NewTextHandler(logBuf, nil))})
+
+	task := engine.task(LogEntry{
+		Name: "app",
+		Path: Paths{"/tmp/test/logs/delete/*.log"},
+		Type: "delete",
+		Condition: &Condition{
+			Age: stringPtr("1h"),
+		},
+	})
+
+	if err := task(context.Background()); err != nil {
+		t.Fatalf("task() error: %v", err)
+	}
+
+	if !strings.Contains(logBuf.String(), "Running task") || !strings.Contains(logBuf.String(), "path=/tmp/test/logs/delete/*.log") {
+		t.Errorf("Expected log output for running task, got %s", logBuf.String())
+	}
+}
@@ -0,0 +1,167 @@
+package logrotate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const truncateBufferSize = 64 * 1024
+
+// truncateStep shrinks the file to its last keep_bytes or keep_lines, or to
+// nothing, once it reached the size or age of step, or every run without
+// either. Like copytruncate, the file is rewritten through its own handle,
+// so it may be held open by its writer; what is written between reading
+// the tail and truncating is lost, and a writer not appending leaves a gap.
+func (e *Engine) truncateStep(run *taskRun, step *Step, file *pipelineFile) (bool, error) {
+	fileInfo, err := e.fs.Stat(file.path)
+	if err != nil {
+		return false, stepFailed("stat", "Failed to get file info", file.path, err)
+	}
+	if step.Size != nil || step.Age != nil {
+		if due, err := e.dueBySizeOrAge(step, file.path, fileInfo); err != nil || !due {
+			return false, err
+		}
+	}
+
+	f, err := e.fs.OpenFile(file.path, os.O_RDWR, 0)
+	if err != nil {
+		return false, stepFailed("truncate", "Failed to open file for truncation", file.path, err)
+	}
+	defer f.Close()
+
+	// Measure the file again through the handle, its writer may have added
+	// to it since.
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, stepFailed("truncate", "Failed to get file size", file.path, err)
+	}
+
+	start, err := tailStart(f, size, step)
+	if err != nil {
+		return false, stepFailed("truncate", "Failed to find tail of file", file.path, err)
+	}
+	if start == 0 {
+		return true, nil
+	}
+	kept, err := shiftTail(f, start, size)
+	if err != nil {
+		return false, stepFailed("truncate", "Failed to truncate file", file.path, err)
+	}
+
+	run.logger.Info("Truncated file", "path", file.path, "bytes", size, "kept", kept)
+	run.done(FileEvent{Action: "truncated", Path: file.path, Size: size, NewSize: kept})
+	return true, nil
+}
+
+// tailStart returns the offset of the tail of f to keep, always at the start
+// of a line.
+func tailStart(f File, size int64, step *Step) (int64, error) {
+	switch {
+	case step.KeepBytes != nil:
+		keep, err := ParseSize(*step.KeepBytes)
+		if err != nil {
+			return 0, fmt.Errorf("invalid keep_bytes: %v", err)
+		}
+		if keep >= size {
+			return 0, nil
+		}
+		// The tail starts after the first newline at or after size-keep-1, so
+		// a line cut in half is dropped.
+		return nextLineStart(f, size-keep-1, size)
+	case step.KeepLines != nil:
+		return lastLinesStart(f, size, *step.KeepLines)
+	default:
+		return size, nil
+	}
+}
+
+// nextLineStart returns the offset after the first newline of f at or after
+// offset, or size if there is none.
+func nextLineStart(f File, offset, size int64) (int64, error) {
+	buf := make([]byte, truncateBufferSize)
+	for offset < size {
+		n, err := readAt(f, buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		offset += int64(n)
+	}
+	return size, nil
+}
+
+// lastLinesStart returns the offset of the last lines of f, where a final
+// line without a newline counts as a line.
+func lastLinesStart(f File, size int64, lines int) (int64, error) {
+	if lines <= 0 {
+		return size, nil
+	}
+
+	buf := make([]byte, truncateBufferSize)
+	end := size
+	newlines := 0
+	for end > 0 {
+		chunk := min(int64(len(buf)), end)
+		offset := end - chunk
+		n, err := readAt(f, buf[:chunk], offset)
+		if err != nil && int64(n) < chunk {
+			return 0, err
+		}
+		for i := chunk - 1; i >= 0; i-- {
+			if buf[i] != '\n' || offset+i == size-1 {
+				continue
+			}
+			newlines++
+			if newlines == lines {
+				return offset + i + 1, nil
+			}
+		}
+		end = offset
+	}
+	return 0, nil
+}
+
+// shiftTail moves the bytes of f from start to size to its beginning and
+// truncates it after them, returning their length.
+func shiftTail(f File, start, size int64) (int64, error) {
+	buf := make([]byte, truncateBufferSize)
+	var written int64
+	for start+written < size {
+		n, err := readAt(f, buf[:min(int64(len(buf)), size-start-written)], start+written)
+		if n == 0 && err != nil {
+			return 0, err
+		}
+		if _, err := f.Seek(written, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := f.Write(buf[:n]); err != nil {
+			return 0, err
+		}
+		written += int64(n)
+	}
+
+	if err := f.Truncate(written); err != nil {
+		return 0, err
+	}
+	return written, f.Sync()
+}
+
+// readAt fills buf from offset of f, returning io.EOF if the file ends first.
+func readAt(f File, buf []byte, offset int64) (int, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,73 @@
+package logrotate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTruncateFiles(t *testing.T) {
+	lines := "one\ntwo\nthree\nfour\n"
+	tests := []struct {
+		name      string
+		content   string
+		condition Condition
+		want      string
+	}{
+		{name: "to zero", content: lines, want: ""},
+		{name: "keep bytes on a line boundary", content: lines, condition: Condition{KeepBytes: stringPtr("11")}, want: "three\nfour\n"},
+		{name: "keep bytes dropping a cut line", content: lines, condition: Condition{KeepBytes: stringPtr("8")}, want: "four\n"},
+		{name: "keep bytes of a single line", content: "no newline at all", condition: Condition{KeepBytes: stringPtr("5")}, want: ""},
+		{name: "keep lines", content: lines, condition: Condition{KeepLines: intPtr(2)}, want: "three\nfour\n"},
+		{name: "keep lines without final newline", content: "one\ntwo\nthree", condition: Condition{KeepLines: intPtr(2)}, want: "two\nthree"},
+		{name: "keep more lines than there are", content: lines, condition: Condition{KeepLines: intPtr(10)}, want: lines},
+		{name: "below size", content: lines, condition: Condition{Size: stringPtr("1KB")}, want: lines},
+		{name: "reached size", content: lines, condition: Condition{Size: stringPtr("10"), KeepLines: intPtr(1)}, want: "four\n"},
+		{name: "long tail", content: strings.Repeat("x", 3*truncateBufferSize) + "\n" + strings.Repeat("y", 2*truncateBufferSize) + "\n", condition: Condition{KeepLines: intPtr(1)}, want: strings.Repeat("y", 2*truncateBufferSize) + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "debug.log")
+			_ = os.WriteFile(file, []byte(tt.content), 0644)
+
+			condition := tt.condition
+			engine := New(Config{}, Options{})
+			err := engine.task(LogEntry{Name: "debug", Path: Paths{file}, Type: "truncate", Condition: &condition})(context.Background())
+			if err != nil {
+				t.Fatalf("task() error: %v", err)
+			}
+
+			if got, _ := os.ReadFile(file); string(got) != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTruncateFileHeldOpen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "debug.log")
+	writer, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	defer writer.Close()
+	_, _ = writer.WriteString("old\nkept\n")
+
+	var events []FileEvent
+	engine := New(Config{}, Options{Hooks: Hooks{OnFile: func(event FileEvent) { events = append(events, event) }}})
+	err = engine.task(LogEntry{Name: "debug", Path: Paths{file}, Type: "truncate", Condition: &Condition{KeepLines: intPtr(1)}})(context.Background())
+	if err != nil {
+		t.Fatalf("task() error: %v", err)
+	}
+	_, _ = writer.WriteString("new\n")
+
+	if got, _ := os.ReadFile(file); string(got) != "kept\nnew\n" {
+		t.Errorf("Expected writer to append after the kept tail, got %q", got)
+	}
+	if len(events) != 1 || events[0].Reclaimed() != 4 {
+		t.Errorf("Expected truncated event reclaiming 4 bytes, got %+v", events)
+	}
+}
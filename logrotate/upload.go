@@ -0,0 +1,197 @@
+package logrotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultUploadRetries = 3
+
+// Upload ships archives to remote storage once they are written. An archive
+// is stored as the prefix of its target followed by its file name, where the
+// prefix may contain {entry}, {host}, {year}, {month} and {day}. Checksum and
+// signature sidecars are uploaded along with it.
+//
+// With a state directory, archives whose host is unreachable are copied to
+// its spool and uploaded by the next upload of the same entry.
+type Upload struct {
+	S3          *S3Target   `yaml:"s3,omitempty"`
+	SFTP        *SFTPTarget `yaml:"sftp,omitempty"`
+	DeleteLocal bool        `yaml:"delete_local,omitempty"` // remove the local archive and its sidecars once the upload is confirmed or spooled
+	Retries     *int        `yaml:"retries,omitempty"`      // attempts after a failed request, defaults to 3
+}
+
+// uploader stores local files under a key at a remote destination. Errors
+// caused by the destination being unreachable wrap errUnreachable.
+type uploader interface {
+	upload(ctx context.Context, fsys FS, localPath, key string) error
+	String() string
+}
+
+// newUploader returns the uploader of the target configured in upload and
+// the prefix of the keys it stores archives under. Credentials files are read
+// from fsys.
+func newUploader(fsys FS, upload *Upload, backoff time.Duration) (uploader, string, error) {
+	retry := retryPolicy{attempts: defaultUploadRetries + 1, backoff: backoff}
+	if upload.Retries != nil {
+		retry.attempts = *upload.Retries + 1
+	}
+
+	switch {
+	case upload.S3 != nil && upload.SFTP != nil:
+		return nil, "", fmt.Errorf("more than one upload target configured")
+	case upload.S3 != nil:
+		client, err := newS3Client(fsys, upload.S3, retry)
+		return client, upload.S3.Prefix, err
+	case upload.SFTP != nil:
+		client, err := newSFTPClient(upload.SFTP, retry)
+		return client, upload.SFTP.Path, err
+	default:
+		return nil, "", fmt.Errorf("no upload target configured")
+	}
+}
+
+// expandPrefix fills in the placeholders of an upload prefix.
+func expandPrefix(prefix, entry string, now time.Time) string {
+	hostname, _ := os.Hostname()
+	return strings.NewReplacer(
+		"{entry}", entry,
+		"{host}", hostname,
+		"{year}", now.Format("2006"),
+		"{month}", now.Format("01"),
+		"{day}", now.Format("02"),
+	).Replace(prefix)
+}
+
+// uploadFiles uploads files in order, stopping at the first error.
+func uploadFiles(ctx context.Context, fsys FS, target uploader, files []spooledFile) error {
+	for _, file := range files {
+		if err := target.upload(ctx, fsys, file.Path, file.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadArchive uploads archivePath and its sidecars, the archive last so its
+// presence implies theirs, and removes them locally if configured to. Archives
+// spooled earlier for the entry are uploaded first.
+func (e *Engine) uploadArchive(ctx context.Context, run *taskRun, upload *Upload, archivePath string) error {
+	target, prefix, err := newUploader(e.fs, upload, e.retryBackoff)
+	if err != nil {
+		return stepFailed("upload", "Invalid upload configuration", archivePath, err)
+	}
+	prefix = expandPrefix(prefix, run.entry, e.clock.Now())
+
+	fileInfo, err := e.fs.Stat(archivePath)
+	if err != nil {
+		return stepFailed("upload", "Failed to get archive info", archivePath, err)
+	}
+
+	var files []spooledFile
+	for _, suffix := range sidecarSuffixes {
+		if _, err := e.fs.Stat(archivePath + suffix); err == nil {
+			files = append(files, spooledFile{Path: archivePath + suffix, Key: path.Join(prefix, filepath.Base(archivePath+suffix))})
+		}
+	}
+	files = append(files, spooledFile{Path: archivePath, Key: path.Join(prefix, filepath.Base(archivePath))})
+
+	// While spooled archives cannot be delivered, neither can this one.
+	err = e.flushSpool(ctx, run, target)
+	if err == nil {
+		err = uploadFiles(ctx, e.fs, target, files)
+	}
+	if errors.Is(err, errUnreachable) && e.spool.enabled() {
+		return e.spoolArchive(run, upload, files, fileInfo.Size(), err)
+	}
+	if err != nil {
+		return stepFailed("upload", "Failed to upload archive", archivePath, err)
+	}
+
+	destination := target.String() + "/" + files[len(files)-1].Key
+	run.logger.Info("Uploaded archive", "path", archivePath, "destination", destination, "bytes", fileInfo.Size())
+	run.done(FileEvent{Action: "uploaded", Path: archivePath, NewPath: destination, Size: fileInfo.Size()})
+
+	if upload.DeleteLocal {
+		return e.deleteUploaded(run, files, fileInfo.Size())
+	}
+	return nil
+}
+
+// spoolArchive copies files to the spool after uploadErr found their host
+// unreachable.
+func (e *Engine) spoolArchive(run *taskRun, upload *Upload, files []spooledFile, size int64, uploadErr error) error {
+	archivePath := files[len(files)-1].Path
+	record, err := e.spool.add(run.entry, files, e.clock.Now())
+	if err != nil {
+		return stepFailed("upload", "Failed to spool archive", archivePath, errors.Join(uploadErr, err))
+	}
+	run.logger.Warn("Spooled archive, upload host unreachable", "path", archivePath, "spooled", record.archive(), "bytes", size, "error", uploadErr)
+	run.done(FileEvent{Action: "spooled", Path: archivePath, NewPath: record.archive(), Size: size})
+
+	if upload.DeleteLocal {
+		return e.deleteUploaded(run, files, size)
+	}
+	return nil
+}
+
+// flushSpool uploads the archives spooled for the entry of run, unless
+// another upload is already flushing the spool. It returns an error wrapping
+// errUnreachable if the host is still unreachable.
+func (e *Engine) flushSpool(ctx context.Context, run *taskRun, target uploader) error {
+	if !e.spool.flushing.TryLock() {
+		return nil
+	}
+	defer e.spool.flushing.Unlock()
+
+	records, err := e.spool.records(run.entry)
+	if err != nil {
+		run.fail("upload", "Failed to read spool", e.spool.path, err)
+		return nil
+	}
+
+	for _, record := range records {
+		archivePath := record.archive()
+		err := uploadFiles(ctx, e.fs, target, record.Files)
+		if errors.Is(err, errUnreachable) {
+			return err
+		}
+		if err != nil {
+			run.fail("upload", "Failed to upload spooled archive", archivePath, err)
+			continue
+		}
+
+		destination := target.String() + "/" + record.Files[len(record.Files)-1].Key
+		fileInfo, _ := e.fs.Stat(archivePath)
+		var size int64
+		if fileInfo != nil {
+			size = fileInfo.Size()
+		}
+		run.logger.Info("Uploaded spooled archive", "path", archivePath, "destination", destination, "bytes", size, "spooled_at", record.Spooled)
+		run.done(FileEvent{Action: "uploaded", Path: archivePath, NewPath: destination, Size: size})
+
+		if err := e.spool.remove(record); err != nil {
+			run.fail("upload", "Failed to remove uploaded archive from spool", archivePath, err)
+		}
+	}
+	return nil
+}
+
+// deleteUploaded removes the local copies of files once they are safe.
+func (e *Engine) deleteUploaded(run *taskRun, files []spooledFile, size int64) error {
+	archivePath := files[len(files)-1].Path
+	for _, file := range files {
+		if err := e.fs.Remove(file.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stepFailed("delete", "Failed to remove uploaded archive", file.Path, err)
+		}
+	}
+	run.logger.Info("Deleted uploaded archive", "path", archivePath, "bytes", size)
+	run.done(FileEvent{Action: "deleted", Path: archivePath, Size: size})
+	return nil
+}
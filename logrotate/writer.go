@@ -0,0 +1,203 @@
+package logrotate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Writer is an io.Writer appending to a log file which it rotates according
+// to the size, age, max_keep and compression settings of a Condition.
+// Rotation happens under the same lock as writes so no line is lost or split
+// between files during the switch. wingologrotate writes its own log through
+// it.
+type Writer struct {
+	mu       sync.Mutex
+	finishMu sync.Mutex
+	wg       sync.WaitGroup
+	fs       FS
+	clock    Clock
+	path     string
+	policy   *Condition
+	maxSize  int64
+	maxAge   time.Duration
+	file     File
+	size     int64
+	openedAt time.Time
+}
+
+// NewWriter opens path for appending on the FS of options, rotating it by
+// the time of their Clock. A nil policy never rotates.
+func NewWriter(path string, policy *Condition, options Options) (*Writer, error) {
+	w := &Writer{fs: options.filesystem(), clock: options.clock(), path: path, policy: policy}
+
+	if policy != nil && policy.Size != nil {
+		maxSize, err := ParseSize(*policy.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size: %v", err)
+		}
+		w.maxSize = maxSize
+	}
+
+	if policy != nil && policy.Age != nil {
+		maxAge, err := ParseDuration(*policy.Age)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age: %v", err)
+		}
+		w.maxAge = maxAge
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	file, err := w.fs.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to get log file info: %v", err)
+	}
+
+	w.file = file
+	w.size = fileInfo.Size()
+	w.openedAt = w.clock.Now()
+	if w.maxAge > 0 {
+		w.openedAt = w.started()
+	}
+	return nil
+}
+
+// startedPath returns the path of the file recording when the log file was
+// started, hidden and named so rotated file patterns do not match it.
+func (w *Writer) startedPath() string {
+	return filepath.Join(filepath.Dir(w.path), "."+filepath.Base(w.path)+".started")
+}
+
+// started returns when the open log file was started, which its age counts
+// from. The time is recorded next to it, as neither its modification time,
+// its last write, nor its creation time, which Windows carries over to a
+// file created under the name of one just renamed, tell. A file started
+// before the time was recorded counts from now.
+func (w *Writer) started() time.Time {
+	var started time.Time
+	if w.size > 0 {
+		_ = readStateFile(w.fs, w.startedPath(), &started)
+	}
+	if started.IsZero() {
+		started = w.clock.Now()
+		if err := writeStateFile(w.fs, w.startedPath(), started, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record start of log file %s: %v\n", w.path, err)
+		}
+	}
+	return started
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			// Keep writing to whatever file is open rather than dropping output.
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", w.path, err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *Writer) shouldRotate(incoming int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+incoming > w.maxSize {
+		return true
+	}
+	if w.maxAge > 0 && w.clock.Now().Sub(w.openedAt) >= w.maxAge {
+		return true
+	}
+	return false
+}
+
+// rotate must be called with w.mu held.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %v", err)
+	}
+	w.file = nil
+
+	rotatedFilePath := rotatedFileName(w.fs, w.clock.Now(), w.path)
+	renameErr := w.fs.Rename(w.path, rotatedFilePath)
+
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	// Compression and pruning may log through this writer, so they have to
+	// run outside of Write.
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.finishRotation(rotatedFilePath)
+	}()
+	return nil
+}
+
+func (w *Writer) finishRotation(rotatedFilePath string) {
+	w.finishMu.Lock()
+	defer w.finishMu.Unlock()
+
+	logger := slog.With("entry", filepath.Base(w.path), "action", "rotate")
+	logger.Info("Rotated log file", "path", w.path, "rotated", rotatedFilePath)
+
+	if w.policy.Compress == nil || *w.policy.Compress {
+		format := compressionFormat(w.policy)
+		if _, err := compressFile(context.Background(), w.fs, rotatedFilePath, format, compressOptionsOf(w.policy)); err != nil {
+			logger.Error("Failed to compress rotated log file", "path", rotatedFilePath, "error", err)
+		} else {
+			logger.Info("Compressed log file", "path", rotatedFilePath, "format", format)
+		}
+	}
+
+	if w.policy.MaxKeep != nil {
+		if _, err := removeOldFiles(w.fs, logger, filepath.Dir(w.path), filepath.Base(w.path), *w.policy.MaxKeep); err != nil {
+			logger.Error("Failed to remove old log files", "path", w.path, "error", err)
+		}
+	}
+}
+
+// Close waits for pending compression of rotated files and closes the log
+// file.
+func (w *Writer) Close() error {
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
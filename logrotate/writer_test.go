@@ -0,0 +1,165 @@
+package logrotate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterSize(t *testing.T) {
+	tempDir := t.TempDir()
+	logPath := filepath.Join(tempDir, "wingologrotate.log")
+
+	writer, err := NewWriter(logPath, &Condition{
+		Size:     stringPtr("100"),
+		Compress: boolPtr(false),
+	}, Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err := fmt.Fprintf(writer, "line %02d of the self log\n", i); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(tempDir, "wingologrotate.log*"))
+	if len(files) < 2 {
+		t.Fatalf("Expected log file to be rotated, found %v", files)
+	}
+
+	seen := map[string]bool{}
+	for _, file := range files {
+		fileInfo, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", file, err)
+		}
+		if fileInfo.Size() > 100 {
+			t.Errorf("Expected %s to be at most 100 bytes, got %d", file, fileInfo.Size())
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file, err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			seen[scanner.Text()] = true
+		}
+		f.Close()
+	}
+
+	for i := 0; i < 20; i++ {
+		line := fmt.Sprintf("line %02d of the self log", i)
+		if !seen[line] {
+			t.Errorf("Expected %q to be kept across rotations", line)
+		}
+	}
+}
+
+func TestWriterCompressAndMaxKeep(t *testing.T) {
+	tempDir := t.TempDir()
+	logPath := filepath.Join(tempDir, "wingologrotate.log")
+
+	writer, err := NewWriter(logPath, &Condition{
+		Size:    stringPtr("50"),
+		MaxKeep: intPtr(2),
+	}, Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := writer.Write([]byte(strings.Repeat("x", 40) + "\n")); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	compressedFiles, _ := filepath.Glob(filepath.Join(tempDir, "wingologrotate.log.*.gz"))
+	if len(compressedFiles) == 0 || len(compressedFiles) > 2 {
+		t.Errorf("Expected 1 or 2 compressed log files, found %v", compressedFiles)
+	}
+
+	if _, err := os.Stat(logPath); err != nil {
+		t.Errorf("Expected active log file to exist: %v", err)
+	}
+}
+
+func TestWriterAgeUsesClock(t *testing.T) {
+	tempDir := t.TempDir()
+	logPath := filepath.Join(tempDir, "wingologrotate.log")
+	clock := &fakeClock{now: time.Date(2024, 9, 13, 12, 0, 0, 0, time.Local)}
+
+	writer, err := NewWriter(logPath, &Condition{Age: stringPtr("1h"), Compress: boolPtr(false)}, Options{Clock: clock})
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	defer writer.Close()
+
+	fmt.Fprintln(writer, "first")
+	fmt.Fprintln(writer, "second")
+	if rotated, _ := filepath.Glob(logPath + ".*"); len(rotated) != 0 {
+		t.Fatalf("Expected no rotation before the age passed on the clock, found %v", rotated)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	fmt.Fprintln(writer, "third")
+	if rotated, _ := filepath.Glob(logPath + ".*"); len(rotated) != 1 {
+		t.Errorf("Expected one rotation once the age passed on the clock, found %v", rotated)
+	}
+}
+
+func TestWriterAgeAcrossRestarts(t *testing.T) {
+	tempDir := t.TempDir()
+	logPath := filepath.Join(tempDir, "wingologrotate.log")
+	clock := &fakeClock{now: time.Date(2024, 9, 13, 12, 0, 0, 0, time.Local)}
+	policy := &Condition{Age: stringPtr("1h"), Compress: boolPtr(false)}
+
+	writer, err := NewWriter(logPath, policy, Options{Clock: clock})
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	fmt.Fprintln(writer, "first")
+	writer.Close()
+
+	// Restarted and written to half an hour later, the file still counts
+	// from when it was started, not from its last write.
+	clock.now = clock.now.Add(30 * time.Minute)
+	if writer, err = NewWriter(logPath, policy, Options{Clock: clock}); err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	fmt.Fprintln(writer, "second")
+	writer.Close()
+
+	clock.now = clock.now.Add(30 * time.Minute)
+	if writer, err = NewWriter(logPath, policy, Options{Clock: clock}); err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	defer writer.Close()
+	fmt.Fprintln(writer, "third")
+	if rotated, _ := filepath.Glob(logPath + ".*"); len(rotated) != 1 {
+		t.Errorf("Expected one rotation an hour after the file was started, found %v", rotated)
+	}
+}
+
+func TestNewWriterInvalidPolicy(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "wingologrotate.log")
+
+	if _, err := NewWriter(logPath, &Condition{Size: stringPtr("abc")}, Options{}); err == nil {
+		t.Errorf("Expected error for invalid size, got none")
+	}
+	if _, err := NewWriter(logPath, &Condition{Age: stringPtr("1x")}, Options{}); err == nil {
+		t.Errorf("Expected error for invalid age, got none")
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strings"
 
@@ -13,9 +15,13 @@ import (
 func usage(errmsg string) {
 	fmt.Fprintf(os.Stderr,
 		"%s\n\n"+
-			"usage: %s <command>\n"+
+			"usage: %s [-name <service>] [-wait] <command>\n"+
 			"       where <command> is one of\n"+
-			"       install, remove, debug, start, stop, pause or continue.\n",
+			"       install, remove, debug, run-once, verify, start, stop, pause or continue,\n"+
+			"       keygen [-sign] <name>, decrypt -key <private key> <archive>...,\n"+
+			"       verify-signature -key <public key> <archive directory>,\n"+
+			"       or one of the control commands of the running instance\n"+
+			"       status, reload, trigger <entry>, pause-entry <entry> or resume-entry <entry>.\n",
 		errmsg, os.Args[0])
 	os.Exit(2)
 }
@@ -24,6 +30,7 @@ var svcName = "WingologRotateService"
 
 func main() {
 	flag.StringVar(&svcName, "name", svcName, "name of the service")
+	flag.BoolVar(&waitForLock, "wait", false, "wait for a running instance to exit instead of exiting")
 	flag.Parse()
 
 	inService, err := svc.IsWindowsService()
@@ -35,15 +42,40 @@ func main() {
 		return
 	}
 
-	if len(os.Args) < 2 {
+	if flag.NArg() < 1 {
 		usage("no command specified")
 	}
 
-	cmd := strings.ToLower(os.Args[1])
+	cmd := strings.ToLower(flag.Arg(0))
 	switch cmd {
 	case "debug":
 		runService(svcName, true)
 		return
+	case "run-once":
+		if err := runOnce(context.Background()); err != nil {
+			log.Fatalf("failed to run once: %v", err)
+		}
+		return
+	case "verify":
+		if err := verifyArchives(context.Background(), os.Stdout); err != nil {
+			log.Fatalf("failed to verify archives: %v", err)
+		}
+		return
+	case "keygen":
+		if err := generateKeys(flag.Args()[1:]); err != nil {
+			log.Fatalf("failed to generate keys: %v", err)
+		}
+		return
+	case "decrypt":
+		if err := decryptArchives(flag.Args()[1:]); err != nil {
+			log.Fatalf("failed to decrypt: %v", err)
+		}
+		return
+	case "verify-signature":
+		if err := verifySignatures(context.Background(), flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("failed to verify signatures: %v", err)
+		}
+		return
 	case "install":
 		err = installService(svcName, "Wingolog Rotate Service")
 	case "remove":
@@ -56,6 +88,8 @@ func main() {
 		err = controlService(svcName, svc.Pause, svc.Paused)
 	case "continue":
 		err = controlService(svcName, svc.Continue, svc.Running)
+	case "status", "reload", "trigger", "pause-entry", "resume-entry":
+		err = runControlCommand(cmd, flag.Args()[1:])
 	default:
 		usage(fmt.Sprintf("invalid command %s", cmd))
 	}
@@ -63,3 +97,31 @@ func main() {
 		log.Fatalf("failed to %s %s: %v", cmd, svcName, err)
 	}
 }
+
+func runControlCommand(cmd string, args []string) error {
+	method, path := "POST", ""
+	switch cmd {
+	case "status":
+		method, path = "GET", "/status"
+	case "reload":
+		path = "/reload"
+	default:
+		if len(args) != 1 {
+			usage(fmt.Sprintf("%s requires an entry name", cmd))
+		}
+		action := strings.TrimSuffix(cmd, "-entry")
+		path = "/entries/" + url.PathEscape(args[0]) + "/" + action
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	out, err := controlRequest(config, method, path)
+	if err != nil {
+		return err
+	}
+	fmt.Println(out)
+	return nil
+}
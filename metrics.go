@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"pakalucki/wingologrotate/logrotate"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	filesProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wingologrotate_files_total",
+		Help: "Number of files processed, by entry and action (rotated, split, deleted, copied, moved, compressed, recompressed, archived, compacted, encrypted, truncated, pruned, uploaded, spooled).",
+	}, []string{"entry", "action"})
+
+	bytesReclaimed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wingologrotate_bytes_reclaimed_total",
+		Help: "Disk space freed by deleting, compressing and pruning files.",
+	}, []string{"entry"})
+
+	compressionRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wingologrotate_compression_ratio",
+		Help: "Compressed size divided by original size of the last compressed file.",
+	}, []string{"entry"})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wingologrotate_errors_total",
+		Help: "Number of errors, by entry and kind.",
+	}, []string{"entry", "kind"})
+
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wingologrotate_task_duration_seconds",
+		Help:    "Duration of task runs.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"entry"})
+
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wingologrotate_last_success_timestamp_seconds",
+		Help: "Unix time of the last task run that finished without errors.",
+	}, []string{"entry"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		filesProcessed,
+		bytesReclaimed,
+		compressionRatio,
+		errorsTotal,
+		taskDuration,
+		lastSuccess,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// initEntryMetrics makes the per entry series visible before the first run.
+func initEntryMetrics(entry string) {
+	for _, action := range []string{"rotated", "deleted", "compressed", "pruned"} {
+		filesProcessed.WithLabelValues(entry, action)
+	}
+	bytesReclaimed.WithLabelValues(entry)
+	taskDuration.WithLabelValues(entry)
+}
+
+// metricsHooks collects the metrics from the events of the rotation engine.
+func metricsHooks() logrotate.Hooks {
+	return logrotate.Hooks{
+		OnScheduled: initEntryMetrics,
+		OnFile: func(event logrotate.FileEvent) {
+			filesProcessed.WithLabelValues(event.Entry, event.Action).Inc()
+			if reclaimed := event.Reclaimed(); reclaimed > 0 {
+				bytesReclaimed.WithLabelValues(event.Entry).Add(float64(reclaimed))
+			}
+			if event.Action == "compressed" && event.Size > 0 {
+				compressionRatio.WithLabelValues(event.Entry).Set(float64(event.NewSize) / float64(event.Size))
+			}
+		},
+		OnError: func(event logrotate.ErrorEvent) {
+			errorsTotal.WithLabelValues(event.Entry, event.Kind).Inc()
+		},
+		OnTaskDone: func(event logrotate.TaskEvent) {
+			taskDuration.WithLabelValues(event.Entry).Observe(event.Duration.Seconds())
+			if event.Err == nil {
+				lastSuccess.WithLabelValues(event.Entry).SetToCurrentTime()
+			}
+		},
+	}
+}
+
+func newMetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"pakalucki/wingologrotate/logrotate"
+)
+
+func newMetricsEngine(logs ...logrotate.LogEntry) *logrotate.Engine {
+	return logrotate.New(logrotate.Config{Schedule: "@every 1h", Logs: logs}, logrotate.Options{Hooks: metricsHooks()})
+}
+
+func TestRotateMetrics(t *testing.T) {
+	tempDir := t.TempDir()
+
+	file := filepath.Join(tempDir, "metrics.log")
+	_ = os.WriteFile(file, make([]byte, 1024*1024*2), 0644) // 2MB log file
+
+	engine := newMetricsEngine(logrotate.LogEntry{
+		Name: "metrics-rotate",
+		Path: logrotate.Paths{filepath.Join(tempDir, "*.log")},
+		Type: "rotate",
+		Condition: &logrotate.Condition{
+			Size:     stringPtr("1MB"),
+			Compress: boolPtr(true),
+		},
+	})
+
+	if err := engine.Trigger("metrics-rotate"); err != nil {
+		t.Fatalf("Trigger() error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(filesProcessed.WithLabelValues("metrics-rotate", "rotated")); got != 1 {
+		t.Errorf("Expected 1 rotated file, got %v", got)
+	}
+	if got := testutil.ToFloat64(filesProcessed.WithLabelValues("metrics-rotate", "compressed")); got != 1 {
+		t.Errorf("Expected 1 compressed file, got %v", got)
+	}
+	if got := testutil.ToFloat64(bytesReclaimed.WithLabelValues("metrics-rotate")); got <= 0 {
+		t.Errorf("Expected reclaimed bytes from compression, got %v", got)
+	}
+	if got := testutil.ToFloat64(compressionRatio.WithLabelValues("metrics-rotate")); got <= 0 || got >= 1 {
+		t.Errorf("Expected compression ratio between 0 and 1, got %v", got)
+	}
+}
+
+func TestDeleteMetrics(t *testing.T) {
+	tempDir := t.TempDir()
+
+	file := filepath.Join(tempDir, "old.log")
+	_ = os.WriteFile(file, make([]byte, 100), 0644)
+
+	engine := newMetricsEngine(
+		logrotate.LogEntry{
+			Name: "metrics-delete",
+			Path: logrotate.Paths{filepath.Join(tempDir, "*.log")},
+			Type: "delete",
+		},
+		logrotate.LogEntry{
+			Name:      "metrics-invalid",
+			Path:      logrotate.Paths{filepath.Join(tempDir, "*.log")},
+			Type:      "delete",
+			Condition: &logrotate.Condition{Age: stringPtr("soon")},
+		},
+	)
+
+	if err := engine.Trigger("metrics-delete"); err != nil {
+		t.Fatalf("Trigger() error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(filesProcessed.WithLabelValues("metrics-delete", "deleted")); got != 1 {
+		t.Errorf("Expected 1 deleted file, got %v", got)
+	}
+	if got := testutil.ToFloat64(bytesReclaimed.WithLabelValues("metrics-delete")); got != 100 {
+		t.Errorf("Expected 100 reclaimed bytes, got %v", got)
+	}
+	if got := testutil.ToFloat64(lastSuccess.WithLabelValues("metrics-delete")); got == 0 {
+		t.Errorf("Expected last success timestamp to be set")
+	}
+
+	_ = os.WriteFile(file, make([]byte, 100), 0644)
+	if err := engine.Trigger("metrics-invalid"); err == nil {
+		t.Fatalf("Expected Trigger() error for invalid age, got none")
+	}
+
+	if got := testutil.ToFloat64(errorsTotal.WithLabelValues("metrics-invalid", "config")); got != 1 {
+		t.Errorf("Expected 1 config error, got %v", got)
+	}
+	if got := testutil.ToFloat64(lastSuccess.WithLabelValues("metrics-invalid")); got != 0 {
+		t.Errorf("Expected no last success timestamp after a failed run, got %v", got)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	engine := newMetricsEngine(logrotate.LogEntry{Name: "metrics-handler", Type: "delete"})
+
+	server := httptest.NewServer(newHTTPHandler(engine))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), `wingologrotate_files_total{action="rotated",entry="metrics-handler"} 0`) {
+		t.Errorf("Expected per entry series in metrics output, got:\n%s", body)
+	}
+}
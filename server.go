@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"pakalucki/wingologrotate/logrotate"
+)
+
+func newHTTPHandler(engine *logrotate.Engine) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", newMetricsHandler())
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := engine.Status()
+		code := http.StatusOK
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, struct {
+			Healthy bool     `json:"healthy"`
+			Reasons []string `json:"reasons,omitempty"`
+		}{status.Healthy, status.Reasons})
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, engine.Status())
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write HTTP response", "error", err)
+	}
+}
+
+func startHTTPServer(settings *HTTPServer, engine *logrotate.Engine) *http.Server {
+	server := &http.Server{
+		Addr:              settings.Listen,
+		Handler:           newHTTPHandler(engine),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Starting HTTP listener", "address", settings.Listen)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP listener failed", "address", settings.Listen, "error", err)
+		}
+	}()
+
+	return server
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pakalucki/wingologrotate/logrotate"
+)
+
+func TestHealthAndStatusEndpoints(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var config Config
+	config.Schedule = "@every 1h"
+	config.HTTP = &HTTPServer{UnhealthyAfter: 1}
+	config.Logs = []logrotate.LogEntry{
+		{Name: "app", Type: "delete", Path: logrotate.Paths{filepath.Join(tempDir, "*.log")}},
+		{Name: "broken", Type: "shred", Path: logrotate.Paths{filepath.Join(tempDir, "*.log")}},
+	}
+
+	engine := newEngine(config)
+	engine.Start(context.Background())
+	defer engine.Stop(time.Second)
+
+	server := httptest.NewServer(newHTTPHandler(engine))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected healthy status 200, got %d", resp.StatusCode)
+	}
+
+	_ = engine.Trigger("broken")
+
+	resp, err = http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected unhealthy status 503, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatalf("GET /status error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var status logrotate.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode /status response: %v", err)
+	}
+	if !status.Running || len(status.Entries) != 2 || status.Entries[1].LastResult != "failure" {
+		t.Errorf("Unexpected /status response: %+v", status)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -12,13 +13,21 @@ import (
 
 var elog debug.Log
 
+// stopTimeout is how long a stopping service waits for running tasks before
+// cancelling them.
+const stopTimeout = 20 * time.Second
+
 type logRotateService struct{}
 
 func (m *logRotateService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
 	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
 	changes <- svc.Status{State: svc.StartPending}
 
-	go runLogRotation()
+	rotation, err := startLogRotation(context.Background())
+	if err != nil {
+		elog.Error(1, fmt.Sprintf("failed to start log rotation: %v", err))
+		return false, 1
+	}
 
 	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 
@@ -35,9 +44,11 @@ loop:
 				elog.Info(1, "Service stopping")
 				break loop
 			case svc.Pause:
+				rotation.pause()
 				changes <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
 				elog.Info(1, "Service paused")
 			case svc.Continue:
+				rotation.resume()
 				changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 				elog.Info(1, "Service continued")
 			default:
@@ -45,7 +56,8 @@ loop:
 			}
 		}
 	}
-	changes <- svc.Status{State: svc.StopPending}
+	changes <- svc.Status{State: svc.StopPending, WaitHint: uint32((stopTimeout + 10*time.Second).Milliseconds())}
+	rotation.stop(stopTimeout)
 	return
 }
 
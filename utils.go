@@ -1,22 +1,20 @@
 package main
 
 import (
-	"archive/zip"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
-	"time"
+
+	"pakalucki/wingologrotate/logrotate"
 )
 
-var logFile *os.File
+var logWriter *logrotate.Writer
 
-func setupLogging(outputFilePath string) {
+func setupLogging(outputFilePath string, policy *logrotate.Condition, logging *Logging) {
 	logDir := filepath.Dir(outputFilePath)
 	if _, err := os.Stat(logDir); os.IsNotExist(err) {
 		err := os.MkdirAll(logDir, 0755)
@@ -25,177 +23,72 @@ func setupLogging(outputFilePath string) {
 		}
 	}
 
-	logFile, err := os.OpenFile(outputFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-
+	writer, err := logrotate.NewWriter(outputFilePath, policy, logrotate.Options{})
 	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+		log.Fatalf("Failed to set up log file: %v", err)
 	}
+	logWriter = writer
 
-	// defer logFile.Close()
-
-	log.SetOutput(logFile)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-}
-
-func closeLogFile() {
-	if logFile != nil {
-		err := logFile.Close()
-		if err != nil {
-			log.Printf("Error closing log file: %v", err)
-		}
-	}
-}
-
-func parseDuration(ageStr string) (time.Duration, error) {
-	if len(ageStr) < 2 {
-		return 0, fmt.Errorf("invalid age format: %s", ageStr)
-	}
-
-	unit := ageStr[len(ageStr)-1]
-	value := ageStr[:len(ageStr)-1]
-
-	ageValue, err := strconv.Atoi(value)
-	if err != nil || ageValue < 0 {
-		return 0, fmt.Errorf("invalid age value: %s", value)
-	}
-
-	switch unit {
-	case 'd':
-		return time.Duration(ageValue) * time.Hour * 24, nil
-	case 'h':
-		return time.Duration(ageValue) * time.Hour, nil
-	case 'm':
-		return time.Duration(ageValue) * time.Minute, nil
-	case 's':
-		return time.Duration(ageValue) * time.Second, nil
-	default:
-		return 0, fmt.Errorf("invalid age unit: %c", unit)
-	}
-}
-
-func getExecutablePath() string {
-	exePath, err := os.Executable()
+	handler, err := newLogHandler(logWriter, logging)
 	if err != nil {
-		log.Fatalf("Failed to get executable path: %v", err)
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
-	return filepath.Dir(exePath)
+	slog.SetDefault(slog.New(handler))
 }
 
-func parseSize(sizeStr string) (int64, error) {
-	sizeStr = strings.ToUpper(strings.TrimSpace(sizeStr))
-	var multiplier int64 = 1
-
-	switch {
-	case strings.HasSuffix(sizeStr, "KB"):
-		multiplier = 1024
-		sizeStr = strings.TrimSuffix(sizeStr, "KB")
-	case strings.HasSuffix(sizeStr, "MB"):
-		multiplier = 1024 * 1024
-		sizeStr = strings.TrimSuffix(sizeStr, "MB")
-	case strings.HasSuffix(sizeStr, "GB"):
-		multiplier = 1024 * 1024 * 1024
-		sizeStr = strings.TrimSuffix(sizeStr, "GB")
+// newLogHandler builds the slog handler described by the logging config
+// section. Without one, output is text at info level with source locations.
+func newLogHandler(w io.Writer, logging *Logging) (slog.Handler, error) {
+	if logging == nil {
+		logging = &Logging{}
 	}
 
-	sizeValue, err := strconv.ParseInt(sizeStr, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid size: %s", sizeStr)
-	}
-
-	return sizeValue * multiplier, nil
-}
-
-func compressLogFile(filePath string, compressionFormat string) error {
-	var compressedFilePath string
-	var compressFunc func(input *os.File, output *os.File) error
-
-	switch compressionFormat {
-	case "gzip":
-		compressedFilePath = filePath + ".gz"
-		compressFunc = func(input *os.File, output *os.File) error {
-			gzipWriter := gzip.NewWriter(output)
-			defer gzipWriter.Close()
-
-			if _, err := io.Copy(gzipWriter, input); err != nil {
-				return fmt.Errorf("failed to compress file with gzip: %v", err)
+	options := &slog.HandlerOptions{
+		Level:     slog.LevelInfo,
+		AddSource: logging.Source == nil || *logging.Source,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if source, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+				a.Value = slog.StringValue(fmt.Sprintf("%s:%d", filepath.Base(source.File), source.Line))
 			}
-			return nil
-		}
-	case "zip":
-		compressedFilePath = filePath + ".zip"
-		compressFunc = func(input *os.File, output *os.File) error {
-			archive := zip.NewWriter(output)
-			defer archive.Close()
-
-			writer, err := archive.Create(filepath.Base(filePath))
-			if err != nil {
-				return fmt.Errorf("failed to create zip entry: %v", err)
-			}
-
-			if _, err := io.Copy(writer, input); err != nil {
-				return fmt.Errorf("failed to write to zip: %v", err)
-			}
-			return nil
-		}
-	default:
-		return fmt.Errorf("unsupported compression format: %s", compressionFormat)
-	}
-
-	inputFile, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file for compression: %v", err)
-	}
-	defer inputFile.Close()
-
-	outputFile, err := os.Create(compressedFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create compressed file: %v", err)
+			return a
+		},
 	}
-	defer outputFile.Close()
 
-	if err := compressFunc(inputFile, outputFile); err != nil {
-		return err
-	}
-
-	if err := inputFile.Close(); err != nil {
-		return fmt.Errorf("failed to close input file: %v", err)
+	if logging.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(logging.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level: %s", logging.Level)
+		}
+		options.Level = level
 	}
 
-	if err := os.Remove(filePath); err != nil {
-		return fmt.Errorf("failed to remove original file after compression: %v", err)
+	switch strings.ToLower(logging.Format) {
+	case "", "text":
+		return slog.NewTextHandler(w, options), nil
+	case "json":
+		return slog.NewJSONHandler(w, options), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", logging.Format)
 	}
-
-	return nil
 }
 
-func removeOldLogFiles(dir, baseFileName string, maxKeep int) error {
-	matches, err := filepath.Glob(filepath.Join(dir, baseFileName+".*"))
-	if err != nil {
-		return fmt.Errorf("failed to list rotated log files: %v", err)
-	}
-
-	sort.Slice(matches, func(i, j int) bool {
-		fileInfoI, err := os.Stat(matches[i])
-		if err != nil {
-			return false
-		}
-		fileInfoJ, err := os.Stat(matches[j])
+func closeLogFile() {
+	if logWriter != nil {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+		err := logWriter.Close()
 		if err != nil {
-			return true
-		}
-		return fileInfoI.ModTime().Before(fileInfoJ.ModTime())
-	})
-
-	for len(matches) > maxKeep {
-		oldestFile := matches[0]
-		if err := os.Remove(oldestFile); err != nil {
-			return fmt.Errorf("failed to remove old log file %s: %v", oldestFile, err)
+			slog.Error("Error closing log file", "error", err)
 		}
-		log.Printf("Removed old log file: %s", oldestFile)
-		matches = matches[1:]
+		logWriter = nil
 	}
+}
 
-	return nil
+func getExecutablePath() string {
+	exePath, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to get executable path: %v", err)
+	}
+	return filepath.Dir(exePath)
 }
 
 func exePath() (string, error) {
@@ -1,83 +1,16 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
-	"compress/gzip"
+	"encoding/json"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
-	"time"
 )
 
-func TestParseDuration(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected time.Duration
-		hasError bool
-	}{
-		{"1d", 24 * time.Hour, false},
-		{"1h", time.Hour, false},
-		{"30m", 30 * time.Minute, false},
-		{"15s", 15 * time.Second, false},
-		{"2h", 2 * time.Hour, false},
-		{"0m", 0, false},
-		{"invalid", 0, true}, // Invalid format
-		{"10x", 0, true},     // Invalid unit
-		{"", 0, true},        // Empty input
-		{"-5m", 0, true},     // Negative value
-		{"1h30m", 0, true},   // Unsupported combined format
-		{"1.5h", 0, true},    // Unsupported float format
-	}
-
-	for _, tt := range tests {
-		result, err := parseDuration(tt.input)
-
-		if tt.hasError && err == nil {
-			t.Errorf("parseDuration(%s) expected error, got none", tt.input)
-		}
-		if !tt.hasError && err != nil {
-			t.Errorf("parseDuration(%s) unexpected error: %v", tt.input, err)
-		}
-		if result != tt.expected {
-			t.Errorf("parseDuration(%s) = %v, expected %v", tt.input, result, tt.expected)
-		}
-	}
-}
-
-func TestParseSize(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-		wantErr  bool
-	}{
-		{"10KB", 10 * 1024, false},             // 10 KB
-		{"20MB", 20 * 1024 * 1024, false},      // 20 MB
-		{"5GB", 5 * 1024 * 1024 * 1024, false}, // 5 GB
-		{"100", 100, false},                    // 100 bytes (default to bytes if no suffix)
-		{"15kb", 15 * 1024, false},             // 15 kb (case insensitive)
-		{"2mb", 2 * 1024 * 1024, false},        // 2 mb (case insensitive)
-		{"1gb", 1024 * 1024 * 1024, false},     // 1 gb (case insensitive)
-		{"", 0, true},                          // Invalid: Empty input
-		{"10XB", 0, true},                      // Invalid: Unrecognized suffix
-		{"abc", 0, true},                       // Invalid: Non-numeric input
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			got, err := parseSize(tt.input)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("parseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
-				return
-			}
-			if got != tt.expected {
-				t.Errorf("parseSize(%q) = %d, want %d", tt.input, got, tt.expected)
-			}
-		})
-	}
-}
-
 func TestExePath(t *testing.T) {
 	originalArgs := os.Args
 	defer func() { os.Args = originalArgs }()
@@ -137,107 +70,65 @@ func TestExePath(t *testing.T) {
 	}
 }
 
-func TestCompressLogFile(t *testing.T) {
-	tempDir := t.TempDir()
-
-	originalFilePath := filepath.Join(tempDir, "test.log")
-	originalContent := []byte("This is a test log file content.")
-	err := os.WriteFile(originalFilePath, originalContent, 0644)
-	if err != nil {
-		t.Fatalf("Failed to create test log file: %v", err)
-	}
-
-	t.Run("gzip Compression", func(t *testing.T) {
-		err = compressLogFile(originalFilePath, "gzip")
+func TestNewLogHandler(t *testing.T) {
+	t.Run("json with level", func(t *testing.T) {
+		var buf bytes.Buffer
+		handler, err := newLogHandler(&buf, &Logging{Format: "json", Level: "warn", Source: boolPtr(false)})
 		if err != nil {
-			t.Fatalf("compressLogFile() error: %v", err)
+			t.Fatalf("newLogHandler() error: %v", err)
 		}
+		logger := slog.New(handler)
 
-		compressedFilePath := originalFilePath + ".gz"
-		if _, err := os.Stat(compressedFilePath); os.IsNotExist(err) {
-			t.Errorf("Compressed file does not exist: %s", compressedFilePath)
-		}
+		logger.Info("Rotated log file", "entry", "app")
+		logger.Warn("Failed to delete file", "entry", "app", "path", "C:\\logs\\app.log", "bytes", 42)
 
-		if _, err := os.Stat(originalFilePath); err == nil {
-			t.Errorf("Original file still exists: %s", originalFilePath)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("Expected 1 line above warn level, got %d: %s", len(lines), buf.String())
 		}
 
-		compressedFile, err := os.Open(compressedFilePath)
-		if err != nil {
-			t.Fatalf("Failed to open compressed file: %v", err)
+		var record map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+			t.Fatalf("Expected JSON output, got %s: %v", lines[0], err)
 		}
-		defer compressedFile.Close()
-
-		gzipReader, err := gzip.NewReader(compressedFile)
-		if err != nil {
-			t.Fatalf("Failed to create gzip reader: %v", err)
-		}
-		defer gzipReader.Close()
-
-		var decompressedContent bytes.Buffer
-		if _, err := io.Copy(&decompressedContent, gzipReader); err != nil {
-			t.Fatalf("Failed to decompress file: %v", err)
+		if record["entry"] != "app" || record["path"] != "C:\\logs\\app.log" || record["bytes"] != float64(42) {
+			t.Errorf("Unexpected fields in %v", record)
 		}
-
-		if !bytes.Equal(decompressedContent.Bytes(), originalContent) {
-			t.Errorf("Decompressed content does not match original. Got: %s, Want: %s", decompressedContent.String(), string(originalContent))
+		if _, ok := record[slog.SourceKey]; ok {
+			t.Errorf("Expected no source field, got %v", record[slog.SourceKey])
 		}
 	})
 
-	t.Run("Zip Compression", func(t *testing.T) {
-		err = os.WriteFile(originalFilePath, originalContent, 0644)
+	t.Run("text with source", func(t *testing.T) {
+		var buf bytes.Buffer
+		handler, err := newLogHandler(&buf, nil)
 		if err != nil {
-			t.Fatalf("Failed to create test log file: %v", err)
+			t.Fatalf("newLogHandler() error: %v", err)
 		}
+		slog.New(handler).Info("Running task", "path", "/var/log/app.log")
 
-		err = compressLogFile(originalFilePath, "zip")
-		if err != nil {
-			t.Fatalf("compressLogFile() error: %v", err)
+		if !strings.Contains(buf.String(), "source=utils_test.go:") {
+			t.Errorf("Expected short source location, got %s", buf.String())
 		}
-
-		compressedFilePath := originalFilePath + ".zip"
-		if _, err := os.Stat(compressedFilePath); os.IsNotExist(err) {
-			t.Errorf("Compressed file does not exist: %s", compressedFilePath)
+		if !strings.Contains(buf.String(), "path=/var/log/app.log") {
+			t.Errorf("Expected path field, got %s", buf.String())
 		}
+	})
 
-		if _, err := os.Stat(originalFilePath); err == nil {
-			t.Errorf("Original file still exists: %s", originalFilePath)
-		}
-
-		zipFile, err := os.Open(compressedFilePath)
-		if err != nil {
-			t.Fatalf("Failed to open zip file: %v", err)
-		}
-		defer zipFile.Close()
-
-		zipReader, err := zip.NewReader(zipFile, zipFileSize(zipFile))
-		if err != nil {
-			t.Fatalf("Failed to create zip reader: %v", err)
-		}
-
-		if len(zipReader.File) == 0 || zipReader.File[0].Name != "test.log" {
-			t.Fatalf("Expected zipped file to contain 'test.log', got: %v", zipReader.File)
-		}
-
-		zippedFile, err := zipReader.File[0].Open()
-		if err != nil {
-			t.Fatalf("Failed to open file inside zip: %v", err)
-		}
-		defer zippedFile.Close()
-
-		var decompressedContent bytes.Buffer
-		if _, err := io.Copy(&decompressedContent, zippedFile); err != nil {
-			t.Fatalf("Failed to decompress file from zip: %v", err)
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := newLogHandler(io.Discard, &Logging{Format: "xml"}); err == nil {
+			t.Errorf("Expected error for invalid format, got none")
 		}
-
-		if !bytes.Equal(decompressedContent.Bytes(), originalContent) {
-			t.Errorf("Decompressed content from zip does not match original. Got: %s, Want: %s", decompressedContent.String(), string(originalContent))
+		if _, err := newLogHandler(io.Discard, &Logging{Level: "loud"}); err == nil {
+			t.Errorf("Expected error for invalid level, got none")
 		}
 	})
 }
 
-// Helper function to get zip file size
-func zipFileSize(file *os.File) int64 {
-	fileInfo, _ := file.Stat()
-	return fileInfo.Size()
+func stringPtr(s string) *string {
+	return &s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
 }